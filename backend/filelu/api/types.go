@@ -1,14 +1,39 @@
 // Package api defines types for interacting with the FileLu API.
 package api
 
+import "encoding/json"
+
+// isEmptyArray returns whether data is a JSON array with nothing in it.
+//
+// Some endpoints return "result": [] instead of an object when they
+// have nothing to report.
+func isEmptyArray(data []byte) bool {
+	var items []json.RawMessage
+	return json.Unmarshal(data, &items) == nil && len(items) == 0
+}
+
 // FolderListResponse represents the response from the folder/list API.
 type FolderListResponse struct {
-	Status int    `json:"status"` // HTTP status code of the response.
-	Msg    string `json:"msg"`    // Message describing the response.
-	Result struct {
-		Files   []FolderListFile   `json:"files"`   // List of files in the folder.
-		Folders []FolderListFolder `json:"folders"` // List of folders in the folder.
-	} `json:"result"` // Nested result structure containing files and folders.
+	Status int              `json:"status"` // HTTP status code of the response.
+	Msg    string           `json:"msg"`    // Message describing the response.
+	Result FolderListResult `json:"result"` // Nested result structure containing files and folders.
+}
+
+// FolderListResult is the result of the FolderListResponse.
+type FolderListResult struct {
+	Files   []FolderListFile   `json:"files"`   // List of files in the folder.
+	Folders []FolderListFolder `json:"folders"` // List of folders in the folder.
+}
+
+// UnmarshalJSON decodes the result, reading an empty array as no files
+// or folders.
+func (r *FolderListResult) UnmarshalJSON(data []byte) error {
+	if isEmptyArray(data) {
+		*r = FolderListResult{}
+		return nil
+	}
+	type plain FolderListResult
+	return json.Unmarshal(data, (*plain)(r))
 }
 
 // FolderListFile represents a file in the FolderListResponse.
@@ -18,39 +43,167 @@ type FolderListFile struct {
 	Uploaded  string `json:"uploaded"`  // Upload date as a string.
 	Thumbnail string `json:"thumbnail"` // URL to the file's thumbnail.
 	Link      string `json:"link"`      // URL to access the file.
-	FldID     int    `json:"fld_id"`    // Folder ID containing the file.
+	FldID     int64  `json:"fld_id"`    // Folder ID containing the file.
 	FileCode  string `json:"file_code"` // Unique code for the file.
 	Hash      string `json:"hash"`      // Hash of the file for verification.
 }
 
 // FolderListFolder represents a folder in the FolderListResponse.
 type FolderListFolder struct {
-	Name      string `json:"name"`       // Folder name.
-	Code      string `json:"code"`       // Unique code for the folder.
-	FldID     int    `json:"fld_id"`     // Folder ID.
-	FldPublic int    `json:"fld_public"` // Indicates if the folder is public.
-	Filedrop  int    `json:"filedrop"`   // Indicates if the folder supports file drop.
+	Name      string          `json:"name"`       // Folder name.
+	Code      string          `json:"code"`       // Unique code for the folder.
+	FldID     int64           `json:"fld_id"`     // Folder ID.
+	FldPublic int             `json:"fld_public"` // Indicates if the folder is public.
+	Filedrop  int             `json:"filedrop"`   // Indicates if the folder supports file drop.
+	Size      json.RawMessage `json:"size"`       // Total size of the files in the folder in bytes, if reported.
+}
+
+// FileInfoResponse represents the response from the file/info API.
+type FileInfoResponse struct {
+	Status int        `json:"status"` // HTTP status code of the response.
+	Msg    string     `json:"msg"`    // Message describing the response.
+	Result []FileInfo `json:"result"` // Information about the files asked for.
+}
+
+// FileInfo represents a file in the FileInfoResponse.
+type FileInfo struct {
+	Name        string `json:"name"`         // File name.
+	FileCode    string `json:"file_code"`    // Unique code for the file.
+	Size        string `json:"size"`         // File size in bytes, as a string.
+	Uploaded    string `json:"uploaded"`     // Upload date as a string.
+	Hash        string `json:"hash"`         // Hash of the file for verification.
+	FileStatus  string `json:"file_status"`  // Processing state such as "processing" or "ready", if reported.
+	MimeType    string `json:"mime_type"`    // MIME type of the file, if reported.
+	ContentType string `json:"content_type"` // MIME type under another name, if reported.
 }
 
 // AccountInfoResponse represents the response for account information.
 type AccountInfoResponse struct {
-	Status int    `json:"status"` // HTTP status code of the response.
-	Msg    string `json:"msg"`    // Message describing the response.
-	Result struct {
-		PremiumExpire string `json:"premium_expire"` // Expiration date of premium access.
-		Email         string `json:"email"`          // User's email address.
-		UType         string `json:"utype"`          // User type (e.g., premium or free).
-		Storage       string `json:"storage"`        // Total storage available to the user.
-		StorageUsed   string `json:"storage_used"`   // Amount of storage used.
-	} `json:"result"` // Nested result structure containing account details.
-}
-
-// FolderDeleteResponse represents the response for deleting a folder.
-type FolderDeleteResponse struct {
-	Status     int    `json:"status"`      // HTTP status code of the response.
-	Msg        string `json:"msg"`         // Message describing the response.
-	Result     string `json:"result"`      // Result of the deletion operation.
-	ServerTime string `json:"server_time"` // Server timestamp of the operation.
+	Status int               `json:"status"` // HTTP status code of the response.
+	Msg    string            `json:"msg"`    // Message describing the response.
+	Result AccountInfoResult `json:"result"` // Nested result structure containing account details.
+}
+
+// AccountInfoResult is the result of the AccountInfoResponse.
+type AccountInfoResult struct {
+	PremiumExpire string      `json:"premium_expire"` // Expiration date of premium access.
+	Email         string      `json:"email"`          // User's email address.
+	UType         string      `json:"utype"`          // User type (e.g., premium or free).
+	Storage       string      `json:"storage"`        // Total storage available to the user.
+	StorageUsed   string      `json:"storage_used"`   // Amount of storage used.
+	TotalFiles    json.Number `json:"total_files"`    // Number of files in the account, if reported.
+}
+
+// UnmarshalJSON decodes the result, reading an empty array as no details.
+func (r *AccountInfoResult) UnmarshalJSON(data []byte) error {
+	if isEmptyArray(data) {
+		*r = AccountInfoResult{}
+		return nil
+	}
+	type plain AccountInfoResult
+	return json.Unmarshal(data, (*plain)(r))
+}
+
+// CloneResponse represents the response from the file/clone API.
+type CloneResponse struct {
+	Status int         `json:"status"` // HTTP status code of the response.
+	Msg    string      `json:"msg"`    // Message describing the response.
+	Result CloneResult `json:"result"` // Nested result structure containing the copy.
+}
+
+// CloneResult is the result of the CloneResponse.
+type CloneResult struct {
+	FileCode string `json:"filecode"` // Code of the new copy.
+}
+
+// UnmarshalJSON decodes the result, reading an empty array as no copy.
+func (r *CloneResult) UnmarshalJSON(data []byte) error {
+	if isEmptyArray(data) {
+		*r = CloneResult{}
+		return nil
+	}
+	type plain CloneResult
+	return json.Unmarshal(data, (*plain)(r))
+}
+
+// DirectLinkResponse represents the response from the file/direct_link API.
+type DirectLinkResponse struct {
+	Status int              `json:"status"` // HTTP status code of the response.
+	Msg    string           `json:"msg"`    // Message describing the response.
+	Result DirectLinkResult `json:"result"` // Nested result structure containing the links.
+}
+
+// DirectLinkResult is the result of the DirectLinkResponse.
+type DirectLinkResult struct {
+	URL  string   `json:"url"`  // URL to download the file from.
+	URLs []string `json:"urls"` // Mirrors to download the file from, if reported.
+	Size int64    `json:"size"` // File size in bytes.
+}
+
+// UnmarshalJSON decodes the result, reading an empty array as no links.
+func (r *DirectLinkResult) UnmarshalJSON(data []byte) error {
+	if isEmptyArray(data) {
+		*r = DirectLinkResult{}
+		return nil
+	}
+	type plain DirectLinkResult
+	return json.Unmarshal(data, (*plain)(r))
+}
+
+// FolderZipResponse represents the response from the folder/zip API.
+type FolderZipResponse struct {
+	Status int             `json:"status"` // HTTP status code of the response.
+	Msg    string          `json:"msg"`    // Message describing the response.
+	Result FolderZipResult `json:"result"` // Nested result structure containing the archive.
+}
+
+// FolderZipResult is the result of the FolderZipResponse.
+type FolderZipResult struct {
+	URL string `json:"url"` // URL to download the archive from.
+}
+
+// UnmarshalJSON decodes the result, reading an empty array as no archive.
+func (r *FolderZipResult) UnmarshalJSON(data []byte) error {
+	if isEmptyArray(data) {
+		*r = FolderZipResult{}
+		return nil
+	}
+	type plain FolderZipResult
+	return json.Unmarshal(data, (*plain)(r))
+}
+
+// FolderResponse represents the response from the folder/create,
+// folder/delete, folder/rename and folder/move APIs.
+//
+// The result is a message string, an object or an array depending on
+// the endpoint and the outcome, so it is kept raw and read with FldID.
+type FolderResponse struct {
+	Status     int             `json:"status"`      // HTTP status code of the response.
+	Msg        string          `json:"msg"`         // Message describing the response.
+	Result     json.RawMessage `json:"result"`      // Result of the operation in whichever shape was sent.
+	ServerTime string          `json:"server_time"` // Server timestamp of the operation.
+}
+
+// folderResult is the part of a folder result object which is read.
+type folderResult struct {
+	FldID json.Number `json:"fld_id"` // Folder ID, sent as a number or a string.
+}
+
+// FldID returns the folder ID in the result, from the first item if it
+// is an array, or "" if it doesn't have one.
+func (r *FolderResponse) FldID() string {
+	var items []folderResult
+	if json.Unmarshal(r.Result, &items) == nil {
+		if len(items) == 0 {
+			return ""
+		}
+		return items[0].FldID.String()
+	}
+	var item folderResult
+	if json.Unmarshal(r.Result, &item) == nil {
+		return item.FldID.String()
+	}
+	return ""
 }
 
 // DeleteResponse represents the response for deleting a file or folder.
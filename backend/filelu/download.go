@@ -0,0 +1,136 @@
+package filelu
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/accounting"
+)
+
+// commandStdout is where the download command writes to for "-"
+var commandStdout io.Writer = os.Stdout
+
+// downloadResult is returned by the download command
+type downloadResult struct {
+	FileCode string `json:"file_code"` // file code of the file downloaded
+	Path     string `json:"path"`      // local path written, or "-" for stdout
+	Bytes    int64  `json:"bytes"`     // number of bytes downloaded
+	Resumed  int64  `json:"resumed"`   // number of bytes already there
+}
+
+// download downloads the file with the file code in link to the local
+// file localPath, or to stdout if it is "-".
+//
+// If localPath is a directory the file is written into it with its own
+// name. A partial file left by an earlier download is resumed and the
+// finished file is checked against the hash FileLu reports.
+func (f *Fs) download(ctx context.Context, link string, localPath string) (*downloadResult, error) {
+	fileCode, err := fileCodeFromLink(link)
+	if err != nil {
+		return nil, fmt.Errorf("download: %w", err)
+	}
+	o, err := f.fileCodeObject(ctx, fileCode)
+	if err != nil {
+		return nil, fmt.Errorf("download: %w", err)
+	}
+	result := &downloadResult{FileCode: fileCode, Path: localPath}
+
+	if localPath == "-" {
+		result.Bytes, err = downloadRange(ctx, o, commandStdout, 0)
+		if err != nil {
+			return nil, fmt.Errorf("download: %w", err)
+		}
+		return result, nil
+	}
+
+	if info, err := os.Stat(localPath); err == nil && info.IsDir() {
+		localPath = filepath.Join(localPath, o.remote)
+		result.Path = localPath
+	}
+	out, err := os.OpenFile(localPath, os.O_WRONLY|os.O_CREATE, 0666)
+	if err != nil {
+		return nil, fmt.Errorf("download: failed to open local file: %w", err)
+	}
+	info, err := out.Stat()
+	if err == nil && info.Size() <= o.size {
+		result.Resumed, err = out.Seek(info.Size(), io.SeekStart)
+	} else if err == nil {
+		// Bigger than the file so can't be part of it
+		err = out.Truncate(0)
+	}
+	if err == nil {
+		result.Bytes, err = downloadRange(ctx, o, out, result.Resumed)
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return result, fmt.Errorf("download: failed to write %q: %w", localPath, err)
+	}
+
+	if err := o.checkLocalFile(ctx, localPath); err != nil {
+		return result, fmt.Errorf("download: %w", err)
+	}
+	return result, nil
+}
+
+// downloadRange copies o from offset to the end into out, opening it
+// again from where it got to if the download breaks off, up to the
+// low level retries times
+func downloadRange(ctx context.Context, o *Object, out io.Writer, offset int64) (n int64, err error) {
+	if offset >= o.size {
+		return 0, nil
+	}
+	tr := accounting.Stats(ctx).NewTransfer(o, nil)
+	defer func() {
+		tr.Done(ctx, err)
+	}()
+	retries := fs.GetConfig(ctx).LowLevelRetries
+	for try := 1; ; try++ {
+		var in io.ReadCloser
+		in, err = o.Open(ctx, &fs.SeekOption{Offset: offset + n})
+		if err != nil {
+			return n, err
+		}
+		acc := tr.Account(ctx, in)
+		var written int64
+		written, err = io.Copy(out, acc)
+		n += written
+		if closeErr := acc.Close(); closeErr != nil {
+			fs.Logf(o, "Failed to close reader: %v", closeErr)
+		}
+		if err == nil || ctx.Err() != nil || try >= retries {
+			return n, err
+		}
+		fs.Debugf(o, "download: resuming at %d after error (%d/%d): %v", offset+n, try, retries, err)
+	}
+}
+
+// checkLocalFile checks the local file at localPath has the size and
+// hash FileLu reports for o
+func (o *Object) checkLocalFile(ctx context.Context, localPath string) error {
+	info, err := os.Stat(localPath)
+	if err != nil {
+		return err
+	}
+	if info.Size() != o.size {
+		return fmt.Errorf("downloaded %d bytes of %q but expected %d", info.Size(), localPath, o.size)
+	}
+	remoteSum, err := o.Hash(ctx, o.fs.hashType)
+	if err != nil || remoteSum == "" {
+		return nil
+	}
+	localSum, err := localHash(localPath, o.fs.hashType)
+	if err != nil {
+		return err
+	}
+	if !strings.EqualFold(localSum, remoteSum) {
+		return fmt.Errorf("corrupted on download: %s hash differ (%s vs %s)", o.fs.hashType, localSum, remoteSum)
+	}
+	return nil
+}
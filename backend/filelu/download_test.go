@@ -0,0 +1,66 @@
+package filelu
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDownloadCommand(t *testing.T) {
+	ctx := context.Background()
+	f, m := newMockFs(t, "", nil)
+	content := "the quick brown fox jumps over the lazy dog"
+	code := m.addFile("dir/fox.txt", content)
+	dir := t.TempDir()
+
+	// To a named file
+	target := filepath.Join(dir, "out.txt")
+	out, err := f.Command(ctx, "download", []string{code, target}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, &downloadResult{FileCode: code, Path: target, Bytes: int64(len(content))}, out)
+	data, err := os.ReadFile(target)
+	require.NoError(t, err)
+	assert.Equal(t, content, string(data))
+
+	// Into a directory under its own name, from a link
+	out, err = f.Command(ctx, "download", []string{"https://filelu.com/" + code, dir}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(dir, "fox.txt"), out.(*downloadResult).Path)
+	data, err = os.ReadFile(filepath.Join(dir, "fox.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, content, string(data))
+
+	// Resuming a partial download
+	require.NoError(t, os.WriteFile(target, []byte(content[:10]), 0666))
+	out, err = f.Command(ctx, "download", []string{code, target}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, &downloadResult{FileCode: code, Path: target, Bytes: int64(len(content) - 10), Resumed: 10}, out)
+	data, err = os.ReadFile(target)
+	require.NoError(t, err)
+	assert.Equal(t, content, string(data))
+
+	// A corrupted partial download is caught
+	require.NoError(t, os.WriteFile(target, []byte("XXXXXXXXXX"), 0666))
+	_, err = f.Command(ctx, "download", []string{code, target}, nil)
+	assert.ErrorContains(t, err, "corrupted on download")
+
+	// To stdout
+	var buf bytes.Buffer
+	oldStdout := commandStdout
+	commandStdout = &buf
+	defer func() { commandStdout = oldStdout }()
+	out, err = f.Command(ctx, "download", []string{code, "-"}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, &downloadResult{FileCode: code, Path: "-", Bytes: int64(len(content))}, out)
+	assert.Equal(t, content, buf.String())
+
+	_, err = f.Command(ctx, "download", []string{"abcdefghijkl", target}, nil)
+	assert.Error(t, err)
+	_, err = f.Command(ctx, "download", []string{code}, nil)
+	assert.Error(t, err)
+}
@@ -0,0 +1,157 @@
+package filelu
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rclone/rclone/backend/filelu/api"
+	"github.com/rclone/rclone/fs"
+)
+
+// fileInfoResult is returned by the fileinfo command
+//
+// Each source is nil if it wasn't read, with the reason in Errors.
+type fileInfoResult struct {
+	FileCode   string            `json:"file_code"`
+	Path       string            `json:"path,omitempty"`        // path relative to the root if given one
+	Listing    *fileInfoSource   `json:"listing,omitempty"`     // from listing the file's folder
+	Info       *fileInfoSource   `json:"info,omitempty"`        // from file/info
+	DirectLink *fileInfoSource   `json:"direct_link,omitempty"` // from file/direct_link
+	Errors     map[string]string `json:"errors,omitempty"`      // why a source couldn't be read
+}
+
+// fileInfoSource is what one source says about a file
+type fileInfoSource struct {
+	Name    string `json:"name,omitempty"`
+	Size    int64  `json:"size"`
+	ModTime string `json:"mod_time,omitempty"`
+	Hash    string `json:"hash,omitempty"`
+	URL     string `json:"url,omitempty"`
+}
+
+// directLinkResult is returned by the directlink command
+type directLinkResult struct {
+	FileCode string    `json:"file_code"`
+	Path     string    `json:"path,omitempty"`    // path relative to the root if given one
+	URL      string    `json:"url"`               // download URL
+	Mirrors  []string  `json:"mirrors,omitempty"` // other URLs for the same file
+	Size     int64     `json:"size"`
+	Expires  time.Time `json:"expires"` // when rclone stops reusing the link
+}
+
+// fileInfo reads what the folder listing, file/info and file/direct_link
+// each say about the file at arg, a path relative to the root or a file
+// code or link, so they can be compared.
+//
+// The listing is only read for a path as a file code doesn't say which
+// folder the file is in. Links aren't taken from the link cache.
+func (f *Fs) fileInfo(ctx context.Context, arg string) (*fileInfoResult, error) {
+	result := &fileInfoResult{Errors: map[string]string{}}
+	if isFileCode(arg) || strings.Contains(arg, "://") {
+		fileCode, err := fileCodeFromLink(arg)
+		if err != nil {
+			return nil, fmt.Errorf("fileinfo: %w", err)
+		}
+		result.FileCode = fileCode
+	} else {
+		result.Path = strings.Trim(arg, "/")
+		obj, err := f.NewObject(ctx, result.Path)
+		if err != nil {
+			return nil, fmt.Errorf("fileinfo: %w", err)
+		}
+		o := obj.(*Object)
+		result.FileCode = o.fileCode
+		result.Listing = &fileInfoSource{
+			Name:    path.Base(o.remote),
+			Size:    o.size,
+			ModTime: o.modTime.Format(time.RFC3339),
+			Hash:    o.hash,
+		}
+	}
+
+	info, err := f.rawFileInfo(ctx, result.FileCode)
+	if err != nil {
+		result.Errors["info"] = err.Error()
+	} else {
+		result.Info = info
+	}
+
+	link, size, err := f.getDirectLinkByCode(ctx, result.FileCode)
+	if err != nil {
+		result.Errors["direct_link"] = err.Error()
+	} else {
+		result.DirectLink = &fileInfoSource{Size: size, URL: link}
+	}
+	return result, nil
+}
+
+// refreshDirectLink fetches a new direct link for the file at arg, a path
+// relative to the root or a file code or link, replacing the one in the
+// link cache
+func (f *Fs) refreshDirectLink(ctx context.Context, arg string) (*directLinkResult, error) {
+	result := &directLinkResult{}
+	if isFileCode(arg) || strings.Contains(arg, "://") {
+		fileCode, err := fileCodeFromLink(arg)
+		if err != nil {
+			return nil, fmt.Errorf("directlink: %w", err)
+		}
+		result.FileCode = fileCode
+	} else {
+		result.Path = strings.Trim(arg, "/")
+		obj, err := f.NewObject(ctx, result.Path)
+		if err != nil {
+			return nil, fmt.Errorf("directlink: %w", err)
+		}
+		result.FileCode = obj.(*Object).fileCode
+	}
+
+	f.forgetDirectLink(result.FileCode, "")
+	urls, size, err := f.cachedDirectLink(ctx, result.FileCode, "")
+	if err != nil {
+		return nil, fmt.Errorf("directlink: %w", err)
+	}
+	result.URL, result.Mirrors, result.Size = urls[0], urls[1:], size
+	f.linkCacheMu.Lock()
+	result.Expires = f.linkCache[directLinkKey(result.FileCode, "")].expires
+	f.linkCacheMu.Unlock()
+	return result, nil
+}
+
+// rawFileInfo reads file/info for the file with fileCode without
+// applying any options, so the hash is reported even if hash_on_list is
+// off
+func (f *Fs) rawFileInfo(ctx context.Context, fileCode string) (*fileInfoSource, error) {
+	var result api.FileInfoResponse
+	err := f.pacer.Call(func() (bool, error) {
+		err := f.callAPI(ctx, "/file/info", url.Values{"file_code": {fileCode}}, &result)
+		return shouldRetry(ctx, err)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if result.Status != 200 {
+		return nil, fmt.Errorf("error: %s", result.Msg)
+	}
+	if len(result.Result) == 0 {
+		return nil, errors.New("no file info returned")
+	}
+	info := result.Result[0]
+	size, err := strconv.ParseInt(info.Size, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse file size: %w", err)
+	}
+	source := &fileInfoSource{Name: info.Name, Size: size, Hash: info.Hash}
+	if modTime, err := parseUploadedTime(info.Uploaded); err == nil {
+		source.ModTime = modTime.Format(time.RFC3339)
+	} else {
+		fs.Debugf(f, "fileinfo: %v", err)
+		source.ModTime = info.Uploaded
+	}
+	return source, nil
+}
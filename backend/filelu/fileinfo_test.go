@@ -0,0 +1,106 @@
+package filelu
+
+import (
+	"context"
+	"crypto/md5"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileInfoCommand(t *testing.T) {
+	ctx := context.Background()
+	f, m := newMockFs(t, "", nil)
+	content := "some content"
+	code := m.addFile("dir/file.txt", content)
+	sum := fmt.Sprintf("%x", md5.Sum([]byte(content)))
+
+	// By path every source is read
+	out, err := f.Command(ctx, "fileinfo", []string{"dir/file.txt"}, nil)
+	require.NoError(t, err)
+	result := out.(*fileInfoResult)
+	assert.Equal(t, code, result.FileCode)
+	assert.Equal(t, "dir/file.txt", result.Path)
+	assert.Empty(t, result.Errors)
+	require.NotNil(t, result.Listing)
+	require.NotNil(t, result.Info)
+	require.NotNil(t, result.DirectLink)
+	assert.Equal(t, &fileInfoSource{Name: "file.txt", Size: int64(len(content)), ModTime: result.Info.ModTime, Hash: sum}, result.Listing)
+	assert.Equal(t, &fileInfoSource{Name: "file.txt", Size: int64(len(content)), ModTime: result.Listing.ModTime, Hash: sum}, result.Info)
+	assert.NotEmpty(t, result.Info.ModTime)
+	assert.Equal(t, int64(len(content)), result.DirectLink.Size)
+	assert.Contains(t, result.DirectLink.URL, "/download/"+code)
+
+	// By file code there is no listing
+	out, err = f.Command(ctx, "fileinfo", []string{code}, nil)
+	require.NoError(t, err)
+	result = out.(*fileInfoResult)
+	assert.Nil(t, result.Listing)
+	assert.NotNil(t, result.Info)
+	assert.NotNil(t, result.DirectLink)
+
+	// Sources which fail are reported
+	out, err = f.Command(ctx, "fileinfo", []string{"abcdefghijkl"}, nil)
+	require.NoError(t, err)
+	result = out.(*fileInfoResult)
+	assert.Nil(t, result.Info)
+	assert.Nil(t, result.DirectLink)
+	assert.Contains(t, result.Errors, "info")
+	assert.Contains(t, result.Errors, "direct_link")
+
+	_, err = f.Command(ctx, "fileinfo", []string{"dir/missing.txt"}, nil)
+	assert.Error(t, err)
+	_, err = f.Command(ctx, "fileinfo", nil, nil)
+	assert.Error(t, err)
+}
+
+func TestDirectLinkCommand(t *testing.T) {
+	ctx := context.Background()
+	f, m := newMockFs(t, "", nil)
+	content := "some content"
+	code := m.addFile("dir/file.txt", content)
+
+	// A dead link in the cache is replaced
+	f.linkCache = map[string]directLinkInfo{
+		directLinkKey(code, ""): {urls: []string{"https://dead.example/"}, size: 1, expires: time.Now().Add(time.Hour)},
+	}
+	before := time.Now()
+	out, err := f.Command(ctx, "directlink", []string{"dir/file.txt"}, nil)
+	require.NoError(t, err)
+	result := out.(*directLinkResult)
+	assert.Equal(t, code, result.FileCode)
+	assert.Equal(t, "dir/file.txt", result.Path)
+	assert.Contains(t, result.URL, "/download/"+code)
+	assert.Empty(t, result.Mirrors)
+	assert.Equal(t, int64(len(content)), result.Size)
+	assert.False(t, result.Expires.Before(before.Add(directLinkTTL)))
+	assert.Equal(t, 1, m.callCount("/file/direct_link"))
+
+	// and the new one is used to open the file
+	urls, size, err := f.cachedDirectLink(ctx, code, "")
+	require.NoError(t, err)
+	assert.Equal(t, []string{result.URL}, urls)
+	assert.Equal(t, int64(len(content)), size)
+	assert.Equal(t, 1, m.callCount("/file/direct_link"))
+
+	// By file code, with mirrors
+	m.mirrors = 1
+	out, err = f.Command(ctx, "directlink", []string{code}, nil)
+	require.NoError(t, err)
+	result = out.(*directLinkResult)
+	assert.Equal(t, "", result.Path)
+	assert.Contains(t, result.URL, "/unavailable/0/"+code)
+	require.Len(t, result.Mirrors, 1)
+	assert.Contains(t, result.Mirrors[0], "/download/"+code)
+	assert.Equal(t, 2, m.callCount("/file/direct_link"))
+
+	_, err = f.Command(ctx, "directlink", []string{"abcdefghijkl"}, nil)
+	assert.Error(t, err)
+	_, err = f.Command(ctx, "directlink", []string{"dir/missing.txt"}, nil)
+	assert.Error(t, err)
+	_, err = f.Command(ctx, "directlink", nil, nil)
+	assert.Error(t, err)
+}
@@ -4,9 +4,9 @@ package filelu
 import (
 	"bytes"
 	"context"
-	"crypto/md5"
-	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"mime/multipart"
@@ -14,26 +14,122 @@ import (
 	"net/url"
 	"os"
 	"path"
+	"path/filepath"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/rclone/rclone/backend/filelu/api"
 	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/accounting"
+	"github.com/rclone/rclone/fs/config"
 	"github.com/rclone/rclone/fs/config/configmap"
 	"github.com/rclone/rclone/fs/config/configstruct"
 	"github.com/rclone/rclone/fs/fserrors"
 	"github.com/rclone/rclone/fs/fshttp"
 	"github.com/rclone/rclone/fs/hash"
+	"github.com/rclone/rclone/fs/object"
+	"github.com/rclone/rclone/fs/walk"
+	"github.com/rclone/rclone/lib/dircache"
+	"github.com/rclone/rclone/lib/pacer"
 )
 
+const (
+	rootFolderID  = "0" // folder ID of the account root
+	minSleep      = 10 * time.Millisecond
+	maxSleep      = 2 * time.Second
+	decayConstant = 2                // bigger for slower decay, exponential
+	directLinkTTL = 10 * time.Minute // how long a direct link is reused for
+	usageTTL      = time.Minute      // how long the result of About is reused for
+	sessionTTL    = 10 * time.Minute // how long an idle upload session is reused for
+
+	minFileCodeLen = 10 // shortest file code accepted, see isFileCode
+	maxFileCodeLen = 16 // longest file code accepted
+)
+
+// retryErrorCodes is a slice of error codes that we will retry
+var retryErrorCodes = []int{
+	429, // Too Many Requests.
+	500, // Internal Server Error
+	502, // Bad Gateway
+	503, // Service Unavailable
+	504, // Gateway Timeout
+	509, // Bandwidth Limit Exceeded
+}
+
+// statusError is returned by callAPI when the server replies with an
+// HTTP status other than 200
+type statusError struct {
+	StatusCode int
+}
+
+// Error satisfies the error interface
+func (e *statusError) Error() string {
+	return fmt.Sprintf("received HTTP status %d", e.StatusCode)
+}
+
+// errUnavailable is returned when FileLu is down, for example for
+// maintenance, and is always retried
+var errUnavailable = errors.New("FileLu service temporarily unavailable")
+
+// shouldRetry returns a boolean as to whether this err deserves to be
+// retried.  It returns the err as a convenience
+func shouldRetry(ctx context.Context, err error) (bool, error) {
+	if fserrors.ContextError(ctx, &err) {
+		return false, err
+	}
+	if errors.Is(err, errUnavailable) {
+		return true, err
+	}
+	// A single API call ran out of time, see the timeout option
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true, err
+	}
+	var statusErr *statusError
+	if errors.As(err, &statusErr) {
+		for _, code := range retryErrorCodes {
+			if statusErr.StatusCode == code {
+				return true, err
+			}
+		}
+		return false, err
+	}
+	return fserrors.ShouldRetry(err), err
+}
+
 // Register the backend with Rclone
 func init() {
 	fs.Register(&fs.RegInfo{
 		Name:        "filelu",
 		Description: "FileLu Cloud Storage",
 		NewFs:       NewFs,
+		CommandHelp: commandHelp,
+		Config: func(ctx context.Context, name string, m configmap.Mapper, config fs.ConfigIn) (*fs.ConfigOut, error) {
+			switch config.State {
+			case "":
+				return fs.ConfigConfirm("check", true, "config_check_key", `Check the key by connecting to FileLu now?
+
+Say N here to skip the check, for example when configuring offline.`)
+			case "check":
+				if config.Result == "false" {
+					return nil, nil
+				}
+				f, err := NewFs(ctx, name, "", m)
+				if err != nil {
+					return nil, err
+				}
+				plan, err := f.(*Fs).checkKey(ctx)
+				if err != nil {
+					return fs.ConfigError("", err.Error())
+				}
+				fs.Logf(nil, "FileLu key is valid: %s", plan)
+				return nil, nil
+			}
+			return nil, fmt.Errorf("unknown state %q", config.State)
+		},
 		Options: []fs.Option{
 			{
 				Name:      "FileLu Rclone Key",
@@ -41,32 +137,935 @@ func init() {
 				Required:  true,
 				Sensitive: true, // Hides the key when displayed
 			},
+			{
+				Name: "size_method",
+				Help: `How to discover the size of files.
+
+The size of each file is looked up once and then remembered.
+
+FileLu lists files as empty while it is still processing them after an
+upload. With the listing method an empty file uploaded in the last hour
+has its size checked with file/info, and with file/direct_link if FileLu
+says it is still processing the file.`,
+				Default: sizeMethodListing,
+				Examples: []fs.OptionExample{{
+					Value: sizeMethodListing,
+					Help:  "Use the size reported in the folder listing (fastest)",
+				}, {
+					Value: sizeMethodInfo,
+					Help:  "Ask file/info for the size of every file",
+				}, {
+					Value: sizeMethodHead,
+					Help:  "Send a HEAD request to the direct link of every file",
+				}},
+				Advanced: true,
+			},
+			{
+				Name: "no_head_object",
+				Help: `Don't send HEAD requests to discover the size of files.
+
+If set, the head size method falls back to asking file/info.`,
+				Default:  false,
+				Advanced: true,
+			},
+			{
+				Name: "list_order",
+				Help: `The order to return the entries of a directory listing in.
+
+Entries which compare equal are ordered by file code so listings are
+always returned in the same order.`,
+				Default: listOrderName,
+				Examples: []fs.OptionExample{{
+					Value: listOrderName,
+					Help:  "Sort by name, A to Z",
+				}, {
+					Value: listOrderNameDesc,
+					Help:  "Sort by name, Z to A",
+				}, {
+					Value: listOrderSize,
+					Help:  "Sort by size, smallest first",
+				}, {
+					Value: listOrderSizeDesc,
+					Help:  "Sort by size, largest first",
+				}, {
+					Value: listOrderUploaded,
+					Help:  "Sort by upload time, oldest first",
+				}, {
+					Value: listOrderUploadedDesc,
+					Help:  "Sort by upload time, newest first",
+				}},
+				Advanced: true,
+			},
+			{
+				Name: "timeout",
+				Help: `Time limit for each API call.
+
+An API call such as listing a folder which takes longer than this is
+cancelled and retried. This doesn't limit how long uploads and downloads
+of file contents may take.
+
+Set to 0 to disable.`,
+				Default:  fs.Duration(2 * time.Minute),
+				Advanced: true,
+			},
+			{
+				Name: "root_is_filedrop",
+				Help: `Treat the root of the remote as the code of a filedrop folder.
+
+A filedrop only accepts uploads, so in this mode files can be copied
+to the remote but it can't be listed, read from or deleted from.
+
+Use it like this:
+
+    rclone copy /local/dir filelu:DROPCODE --filelu-root-is-filedrop`,
+				Default:  false,
+				Advanced: true,
+			},
+			{
+				Name: "disable_checksum",
+				Help: `Don't read or compute MD5 hashes.
+
+This makes transfers faster, but rclone can no longer check that files
+arrived intact, and uploads of files which are already there with the
+same content are no longer skipped.`,
+				Default:  false,
+				Advanced: true,
+			},
+			{
+				Name: "batch_delete",
+				Help: `Number of files to delete in a single API call.
+
+Purge and the delete backend command delete files by file code. If
+this is more than 1 they are deleted in batches of this many codes,
+which is much faster for large numbers of files. If a batch fails the
+files in it are deleted one at a time instead.
+
+Set to 0 to delete files one at a time.`,
+				Default:  0,
+				Advanced: true,
+			},
+			{
+				Name: "hash_on_list",
+				Help: `Use the MD5 hashes in folder listings.
+
+FileLu returns the hash of each file when listing a folder, so reading
+the hash of a listed file needs no extra API call. Turn this off to
+ignore the listed hashes and ask file/info for the hash of each file
+when it is needed instead.`,
+				Default:  true,
+				Advanced: true,
+			},
+			{
+				Name: "upload_retries",
+				Help: `Number of times to upload a file again if it arrives corrupted.
+
+If this is more than 0 the MD5 hash FileLu reports for each file
+uploaded is checked against the hash of the data sent. If they differ
+the bad copy is deleted and the file is uploaded again, up to this many
+times, before giving up with an error.
+
+Set to 0 to not check uploads. Checking does nothing if
+disable_checksum is set.`,
+				Default:  0,
+				Advanced: true,
+			},
+			{
+				Name: "temp_dir",
+				Help: `Directory to keep temporary copies of uploads in.
+
+Files are copied to a local temporary file before being uploaded, and
+files written with --vfs-cache-mode writes are staged in one, so this
+needs enough free space for the largest file uploaded.
+
+The files are only readable by the user running rclone. Leave empty to
+use the system temporary directory.`,
+				Default:  "",
+				Advanced: true,
+			},
+			{
+				Name: "folder_id_in_path",
+				Help: `Show folders with their ID in front of the name, as in "(123) name".
+
+Older versions of this backend listed folders like this. It is
+deprecated and will be removed, so only set it while moving scripts
+which use decorated paths over to plain folder names.
+
+When set, decorated paths are found by folder ID so folders with the
+same name can be told apart. Files and folders whose names really start
+with a number in brackets can't be used with it.`,
+				Default:  false,
+				Advanced: true,
+			},
+			{
+				Name: "list_recurse_limit",
+				Help: `How many levels of folders to descend into when listing recursively.
+
+This limits the recursive listings used with --fast-list so they can't
+run for ever on accounts with very deep folder trees. Folders at the
+limit are listed but not looked inside, so files below them are left
+out of the listing. With --max-depth as well the listing stops at
+whichever is shallower.
+
+As files below the limit aren't seen, don't use it with --fast-list
+when syncing from FileLu, or they will be deleted from the destination.
+
+Set to 0 for no limit.`,
+				Default:  0,
+				Advanced: true,
+			},
+			{
+				Name: "strict_mkdir",
+				Help: `Only make a folder if its parent already exists.
+
+Normally making a folder makes any of its parents which are missing
+too. Set this to make it fail with a directory not found error instead,
+so a mistyped path doesn't make a new tree of folders.`,
+				Default:  false,
+				Advanced: true,
+			},
+			{
+				Name: "overwrite",
+				Help: `What to do when uploading a file whose name is already taken.
+
+Uploading a file with the same content as the one already there never
+uploads it again, whatever this is set to.
+
+With error the existing file is kept and the upload fails saying so. Use
+--ignore-existing to skip such files quietly instead.`,
+				Default: overwriteReplace,
+				Examples: []fs.OptionExample{{
+					Value: overwriteReplace,
+					Help:  "Upload the new file then delete the old one",
+				}, {
+					Value: overwriteError,
+					Help:  "Keep the old file and fail the upload",
+				}, {
+					Value: overwriteRename,
+					Help:  "Keep the old file and upload the new one as \"name (1).ext\"",
+				}},
+				Advanced: true,
+			},
+			{
+				Name: "no_modtime",
+				Help: `Don't read the modification time of files.
+
+FileLu only reports the time each file was uploaded, which is shown as
+its modification time. Set this to ignore it, so files all show the
+Unix epoch as their modification time.
+
+Either way sync and copy don't compare modification times, only sizes,
+or hashes with --checksum.`,
+				Default:  false,
+				Advanced: true,
+			},
+			{
+				Name: "assume_root_folder_exists",
+				Help: `Don't look at the account when the remote is made.
+
+Normally rclone lists the root of the account when it starts, to find
+the type of hash FileLu reports. Set this for a long running rclone,
+such as a mount or rcd, which knows the folders it uses exist, so it
+doesn't send any requests until the first operation. Folders are still
+looked up as they are used.
+
+Hashes are then assumed to be MD5. If the root is missing or the key is
+wrong nothing is said when rclone starts and the first operation fails
+instead, for example with "directory not found".`,
+				Default:  false,
+				Advanced: true,
+			},
+			{
+				Name: "default_folder_id",
+				Help: `ID of the folder to put files uploaded to the account root in.
+
+FileLu puts new files in the root of the account. Set this to the ID of
+a folder, as shown by the foldertree command, to have files which would
+end up in the account root put in that folder instead, so they don't
+clutter the root. Files uploaded into a folder aren't affected.
+
+The files are then not in the root, so don't use this with commands
+which look for the files they upload, such as sync to the account root.
+
+0 leaves files in the account root.`,
+				Default:  0,
+				Advanced: true,
+			},
+			{
+				Name: "account_type",
+				Help: `The type of FileLu account, which sets the fields sent with uploads.
+
+FileLu's upload servers expect different fields from premium and free
+accounts. By default the type is read from the account info before the
+first upload. Set it to save that request or if the account info
+reports a type rclone doesn't know.`,
+				Default: accountTypeAuto,
+				Examples: []fs.OptionExample{{
+					Value: accountTypeAuto,
+					Help:  "Read the type from the account info",
+				}, {
+					Value: accountTypePremium,
+					Help:  "A premium account",
+				}, {
+					Value: accountTypeFree,
+					Help:  "A free account",
+				}},
+				Advanced: true,
+			},
+			{
+				Name: "upload_poll_interval",
+				Help: `How often to check whether FileLu has finished processing an upload.
+
+FileLu may scan or otherwise process a file after it is uploaded before
+it can be used. If the upload reports this, file/info is checked this
+often until it says the file is ready.`,
+				Default:  fs.Duration(time.Second),
+				Advanced: true,
+			},
+			{
+				Name: "upload_poll_timeout",
+				Help: `How long to wait for FileLu to finish processing an upload.
+
+If the file still isn't ready after this the upload fails. Set to 0 to
+not wait, so the file is used while FileLu is still processing it.`,
+				Default:  fs.Duration(time.Minute),
+				Advanced: true,
+			},
+			{
+				Name: "mirror_selection",
+				Help: `Which download URL to try first when FileLu offers several.
+
+If file/direct_link returns more than one URL for a file they are tried
+in turn, moving on to the next if a download fails or the server
+returns an error.`,
+				Default: mirrorSelectionFirst,
+				Examples: []fs.OptionExample{{
+					Value: mirrorSelectionFirst,
+					Help:  "Try the URLs in the order FileLu returns them",
+				}, {
+					Value: mirrorSelectionRandom,
+					Help:  "Try the URLs in a random order to spread the load",
+				}},
+				Advanced: true,
+			},
+			{
+				Name: "no_gzip",
+				Help: `Don't ask for API responses to be compressed.
+
+By default API calls accept gzip and deflate encoded responses, which
+makes listing large folders much quicker. Set this if a proxy or
+FileLu's servers mangle compressed responses.`,
+				Default:  false,
+				Advanced: true,
+			},
+			{
+				Name: "list_cache_time",
+				Help: `How long to reuse a folder listing for.
+
+Listing a folder again within this time, for example when a mount or
+repeated ls look at the same folder, doesn't ask FileLu again. Changes
+rclone makes to a folder are seen straight away but changes made
+elsewhere may take this long to appear.
+
+Set to 0 to disable.`,
+				Default:  fs.Duration(0),
+				Advanced: true,
+			},
+			{
+				Name: "upload_path_field",
+				Help: `Name of an upload form field to send each file's folder in.
+
+Uploads land in the account root and are moved into their folder
+afterwards. If set, the folder the file is going to, from the account
+root, is sent with the upload in a field of this name, so an upload
+whose move never happened can be matched to where it belongs.
+
+FileLu doesn't document a field for this, so none is sent by default.`,
+				Default:  "",
+				Advanced: true,
+			},
 		},
 	})
 }
 
+// commandHelp describes the backend commands
+var commandHelp = []fs.CommandHelp{{
+	Name:  "rename",
+	Short: "Rename a file",
+	Long: `This command renames the file the remote points to.
+
+Usage:
+
+    rclone backend rename filelu:path/to/file.txt new_name.txt
+`,
+}, {
+	Name:  "movefile",
+	Short: "Move a file to another folder",
+	Long: `This command moves the file the remote points to into another folder.
+
+Usage:
+
+    rclone backend movefile filelu:path/to/file.txt /destination/folder
+`,
+}, {
+	Name:  "movefolder",
+	Short: "Move a folder to another folder",
+	Long: `This command moves a folder into another folder, keeping its name.
+This is either the folder the remote points to, moved into a folder
+given from the account root, or the folder at source_folder_path moved
+into destination_folder_path, both relative to the remote.
+
+Usage:
+
+    rclone backend movefolder filelu:path/to/folder /destination/folder
+    rclone backend movefolder filelu: path/to/folder destination/folder
+    rclone backend movefolder filelu: 12345 67890 -o id=true
+    rclone backend movefolder filelu: abcdefghijkl mnopqrstuvwx -o code=true
+
+With the id option the folders are given by folder ID, 0 being the
+account root, and with the code option by folder code, as shown by
+foldertree.
+
+It fails if either folder doesn't exist, if the destination is the
+folder itself or one of its own folders, or if a folder with the same
+name is already there.
+
+Result:
+
+    {
+        "from": "/path/to/folder",
+        "to": "/destination/folder/folder"
+    }
+`,
+}, {
+	Name:  "renamefolder",
+	Short: "Rename a folder",
+	Long: `This command renames a folder, either the one the remote points to
+or the one at folder_path relative to it.
+
+Usage:
+
+    rclone backend renamefolder filelu:path/to/folder new_name
+    rclone backend renamefolder filelu: path/to/folder new_name
+    rclone backend renamefolder filelu: abcdefghijkl new_name -o code=true
+
+With the code option folder_path is the folder code, as shown by
+foldertree, of a folder under the remote rather than its path.
+
+It fails if the folder doesn't exist or if a folder called new_name
+already exists next to it, and returns the new path of the folder.
+`,
+}, {
+	Name:  "editfolder",
+	Short: "Change the settings of a folder",
+	Long: `This command changes the settings of a folder, either the one the
+remote points to or the one at folder_path relative to it, given as
+options.
+
+Usage:
+
+    rclone backend editfolder filelu:path/to/folder -o name=new_name
+    rclone backend editfolder filelu: path/to/folder -o name=new_name
+    rclone backend editfolder filelu: abcdefghijkl -o name=new_name -o code=true
+
+The settings FileLu lets rclone change are:
+
+- name - rename the folder, as renamefolder does
+
+Other options are ignored and listed in the result so scripts can tell
+what wasn't changed. The code option gives the folder by its folder
+code.
+
+Result:
+
+    {
+        "path": "/path/to/new_name",
+        "applied": {"name": "new_name"},
+        "ignored": ["description"]
+    }
+`,
+}, {
+	Name:  "dedupe",
+	Short: "Find and remove files with identical content",
+	Long: `This command walks the remote recursively, groups the files by the
+hash reported by FileLu and removes all but one file of each group.
+
+Usage:
+
+    rclone backend dedupe filelu:path
+    rclone backend dedupe filelu:path -o mode=oldest
+    rclone backend dedupe filelu:path -o mode=list
+    rclone backend dedupe filelu:path -o interactive=true
+
+The mode option chooses which file of a group is kept:
+
+- newest - keep the most recently uploaded file (default)
+- oldest - keep the first uploaded file
+- list - only report the duplicates, don't remove anything
+
+With -o interactive=true the file to keep is chosen at a prompt for
+each group instead of by the mode.
+
+Result:
+
+    [
+        {
+            "hash": "...",
+            "kept": "path/to/kept.txt",
+            "removed": ["path/to/duplicate.txt"]
+        }
+    ]
+`,
+}, {
+	Name:  "delete",
+	Short: "Delete files by file code or path",
+	Long: `This command deletes each of the files given, either by file code or
+by path relative to the remote.
+
+Usage:
+
+    rclone backend delete filelu: abcdefghijkl path/to/file.txt
+
+Every file is tried even if deleting an earlier one fails. If any fail
+the error lists which files were deleted and why the others weren't.
+
+Result:
+
+    {
+        "deleted": ["abcdefghijkl", "path/to/file.txt"],
+        "failed": {}
+    }
+`,
+}, {
+	Name:  "copyfolder",
+	Short: "Copy a folder server side",
+	Long: `This command copies a folder and everything in it to another path,
+both relative to the remote, without downloading anything.
+
+Usage:
+
+    rclone backend copyfolder filelu: path/to/folder path/to/copy
+    rclone backend copyfolder filelu: abcdefghijkl path/to/copy -o code=true
+
+The code option gives the folder to copy by its folder code.
+
+Each file copied shows up in the transfer stats. Files which fail to
+copy are listed in the result rather than stopping the copy.
+
+Result:
+
+    {
+        "files": 17,
+        "bytes": 123456,
+        "failed": []
+    }
+`,
+}, {
+	Name:  "importlink",
+	Short: "Save a public FileLu file into the account",
+	Long: `This command saves the file behind a public FileLu link, or with a file
+code, into a folder of the account without downloading it. The folder
+is either the one the remote points to or the one at folder_path
+relative to it, and is created if needed.
+
+Usage:
+
+    rclone backend importlink filelu:path/to/folder https://filelu.com/abcdefghijkl
+    rclone backend importlink filelu: path/to/folder abcdefghijkl
+    rclone backend importlink filelu: mnopqrstuvwx abcdefghijkl -o code=true
+
+The code option gives an existing folder by its folder code.
+
+Result:
+
+    {
+        "file_code": "mnopqrstuvwx",
+        "folder": "/path/to/folder"
+    }
+`,
+}, {
+	Name:  "foldertree",
+	Short: "Show the tree of folders under the remote",
+	Long: `This command lists the folders under the remote recursively and
+returns them as a nested tree with their IDs and flags.
+
+Usage:
+
+    rclone backend foldertree filelu:
+    rclone backend foldertree filelu:path/to/folder -o depth=2
+
+The depth option limits how many levels of folders are listed. The
+folders of a folder which wasn't listed because of it are null.
+
+Result:
+
+    {
+        "fld_id": "0",
+        "name": "",
+        "public": false,
+        "filedrop": false,
+        "folders": [
+            {
+                "fld_id": "12",
+                "code": "abcdefghijkl",
+                "name": "photos",
+                "parent": "0",
+                "public": true,
+                "filedrop": false,
+                "folders": []
+            }
+        ]
+    }
+`,
+}, {
+	Name:  "mkdirtree",
+	Short: "Make many folders at once",
+	Long: `This command makes the folders at the paths given, relative to the
+remote, along with any parents they need. It is much quicker than making
+them one at a time, so is useful before copying into a large tree.
+
+Usage:
+
+    rclone backend mkdirtree filelu:base a/b/c a/b/d a/e
+
+Result is the ID of every folder made or found, by path:
+
+    {
+        "a": "12",
+        "a/b": "13",
+        "a/b/c": "14",
+        "a/b/d": "15",
+        "a/e": "16"
+    }
+`,
+}, {
+	Name:  "exportmanifest",
+	Short: "Write a manifest of the remote to a local file",
+	Long: `This command walks the remote and writes the path, file code, hash,
+size and upload time of every file, and the ID of every folder, to a
+local JSON file.
+
+Usage:
+
+    rclone backend exportmanifest filelu:path /local/manifest.json
+
+Result:
+
+    {
+        "folders": 3,
+        "files": 17
+    }
+`,
+}, {
+	Name:  "importmanifest",
+	Short: "Seed the caches from a manifest written by exportmanifest",
+	Long: `This command reads a manifest written by exportmanifest and remembers
+the folders and files in it, so they don't need to be looked up again.
+This is only useful from the rc or when the remote stays in memory, for
+example when resuming a large sync with rclone rcd.
+
+Usage:
+
+    rclone backend importmanifest filelu:path /local/manifest.json
+
+Result:
+
+    {
+        "folders": 3,
+        "files": 17
+    }
+`,
+}, {
+	Name:  "verify",
+	Short: "Check the files in a folder against a local directory",
+	Long: `This command compares the MD5 hashes of the files in a folder, either
+the one the remote points to or the one at folder_path relative to it,
+with the files in a local directory.
+
+Usage:
+
+    rclone backend verify filelu:path/to/folder /local/dir
+    rclone backend verify filelu: path/to/folder /local/dir
+    rclone backend verify filelu: abcdefghijkl /local/dir -o code=true
+
+The code option gives the folder by its folder code.
+
+Result:
+
+    {
+        "matched": 10,
+        "mismatched": ["changed.txt"],
+        "missing_remote": ["only/local.txt"],
+        "missing_local": ["only/remote.txt"]
+    }
+`,
+}, {
+	Name:  "download",
+	Short: "Download a file by file code to a local file or stdout",
+	Long: `This command downloads the file with a file code, or behind a public
+FileLu link, to a local file. If local_path is a directory the file is
+written into it under its own name, and if it is "-" the file is
+written to stdout.
+
+Usage:
+
+    rclone backend download filelu: abcdefghijkl /local/file.bin
+    rclone backend download filelu: abcdefghijkl - > file.bin
+
+A partial local file left by an interrupted download is resumed rather
+than downloaded again. The local file is checked against the size and
+hash FileLu reports when it is finished.
+
+Result:
+
+    {
+        "file_code": "abcdefghijkl",
+        "path": "/local/file.bin",
+        "bytes": 123456,
+        "resumed": 0
+    }
+`,
+}, {
+	Name:  "fileinfo",
+	Short: "Show what each FileLu API says about a file",
+	Long: `This command shows the name, size, modification time and hash of a
+file as reported by the folder listing, file/info and file/direct_link
+side by side, to help track down files whose size or time looks wrong.
+The file is given by its path relative to the remote or by file code.
+
+Usage:
+
+    rclone backend fileinfo filelu: path/to/file.txt
+    rclone backend fileinfo filelu: abcdefghijkl
+
+The listing is only read when a path is given. A source which can't be
+read is left out and the reason given under errors.
+
+Result:
+
+    {
+        "file_code": "abcdefghijkl",
+        "path": "path/to/file.txt",
+        "listing": {"name": "file.txt", "size": 123, "mod_time": "2024-01-02T03:04:05Z", "hash": "..."},
+        "info": {"name": "file.txt", "size": 123, "mod_time": "2024-01-02T03:04:05Z", "hash": "..."},
+        "direct_link": {"size": 123, "url": "https://..."}
+    }
+`,
+}, {
+	Name:  "directlink",
+	Short: "Get a fresh direct download link for a file",
+	Long: `This command asks FileLu for a new direct download link for a file,
+given by its path relative to the remote or by file code, for scripts
+whose saved link has expired.
+
+Usage:
+
+    rclone backend directlink filelu: path/to/file.txt
+    rclone backend directlink filelu: abcdefghijkl
+
+The link replaces any rclone was reusing for the file. FileLu doesn't
+say when links expire, so expires is when rclone stops reusing it.
+
+Result:
+
+    {
+        "file_code": "abcdefghijkl",
+        "path": "path/to/file.txt",
+        "url": "https://...",
+        "mirrors": ["https://..."],
+        "size": 123,
+        "expires": "2024-01-02T03:14:05Z"
+    }
+`,
+}, {
+	Name:  "downloadfolder",
+	Short: "Download a whole folder to a local directory",
+	Long: `This command downloads a folder, either the one the remote points to or
+the one at folder_path relative to it, into a local directory.
+
+Usage:
+
+    rclone backend downloadfolder filelu:path/to/folder /local/dir
+    rclone backend downloadfolder filelu: path/to/folder /local/dir
+    rclone backend downloadfolder filelu: abcdefghijkl /local/dir -o code=true
+
+The code option gives the folder by its folder code.
+
+If FileLu can produce an archive of the folder it is streamed to
+/local/dir/folder.zip, otherwise every file in the folder is
+downloaded into /local/dir keeping the folder structure.
+
+Result:
+
+    {
+        "archive": "",
+        "files": 17,
+        "bytes": 123456
+    }
+`,
+}, {
+	Name:  "setfolderid",
+	Short: "Change the folder the remote points to",
+	Long: `This command points the remote at the folder with the folder ID given,
+or the account root for 0, so a long running rclone, such as rclone rcd,
+can switch folders without making the remote again.
+
+Usage:
+
+    rclone backend setfolderid filelu: 12345
+
+The folder must exist. Operations running while the folder changes may
+use either folder.
+
+Result is the folder ID and its path:
+
+    {
+        "fld_id": "12345",
+        "root": "path/to/folder"
+    }
+`,
+}}
+
 // Options defines the configuration for the FileLu backend
 type Options struct {
-	RcloneKey string `config:"FileLu Rclone Key"`
+	RcloneKey        string      `config:"FileLu Rclone Key"`
+	SizeMethod       string      `config:"size_method"`
+	NoHeadObject     bool        `config:"no_head_object"`
+	RootIsDrop       bool        `config:"root_is_filedrop"`
+	ListOrder        string      `config:"list_order"`
+	Timeout          fs.Duration `config:"timeout"`
+	DisableChecksum  bool        `config:"disable_checksum"`
+	BatchDelete      int         `config:"batch_delete"`
+	HashOnList       bool        `config:"hash_on_list"`
+	UploadRetries    int         `config:"upload_retries"`
+	TempDir          string      `config:"temp_dir"`
+	FolderIDInPath   bool        `config:"folder_id_in_path"`
+	ListRecurseLimit int         `config:"list_recurse_limit"`
+	StrictMkdir      bool        `config:"strict_mkdir"`
+	Overwrite        string      `config:"overwrite"`
+	NoModTime        bool        `config:"no_modtime"`
+	AssumeRootExists bool        `config:"assume_root_folder_exists"`
+	DefaultFolderID  int64       `config:"default_folder_id"`
+	AccountType      string      `config:"account_type"`
+	PollInterval     fs.Duration `config:"upload_poll_interval"`
+	PollTimeout      fs.Duration `config:"upload_poll_timeout"`
+	MirrorSelection  string      `config:"mirror_selection"`
+	NoGzip           bool        `config:"no_gzip"`
+	ListCacheTime    fs.Duration `config:"list_cache_time"`
+	UploadPathField  string      `config:"upload_path_field"`
+}
+
+// errFiledrop is returned for operations a filedrop can't do
+var errFiledrop = fmt.Errorf("not supported when root_is_filedrop is set: %w", fs.ErrorNotImplemented)
+
+// errAccountRoot is returned when asked to remove the account root
+var errAccountRoot = errors.New("can't remove the account root")
+
+// Ways of discovering the size of a file, see the size_method option
+const (
+	sizeMethodListing = "listing"
+	sizeMethodInfo    = "info"
+	sizeMethodHead    = "head"
+)
+
+// What to do when an upload's name is taken, see the overwrite option
+const (
+	overwriteReplace = "replace"
+	overwriteError   = "error"
+	overwriteRename  = "rename"
+)
+
+// Types of account, see the account_type option
+const (
+	accountTypeAuto    = ""
+	accountTypePremium = "premium"
+	accountTypeFree    = "free"
+)
+
+// Orders to try download URLs in, see the mirror_selection option
+const (
+	mirrorSelectionFirst  = "first"
+	mirrorSelectionRandom = "random"
+)
+
+// uploadUTypes are the utype upload fields for each type of account
+var uploadUTypes = map[string]string{
+	accountTypePremium: "prem",
+	accountTypeFree:    "reg",
 }
 
 // Fs represents the FileLu file system
+//
+// An Fs is used by many goroutines at once. The fields set by NewFs
+// aren't changed afterwards, apart from the root which setfolderid
+// changes, so that is read with Root and rootFile. The caches each have
+// their own mutex, which is only held while the cache is read or
+// changed and never across an API call.
 type Fs struct {
-	name       string       // name of the remote
-	root       string       // root folder path
-	opt        Options      // backend options
-	endpoint   string       // FileLu endpoint
-	client     *http.Client // HTTP client
-	isFile     bool         // whether this fs points to a specific file
-	targetFile string       // specific file being targeted in single-file operations
+	name       string             // name of the remote
+	rootMu     sync.RWMutex       // protects root, isFile and targetFile
+	root       string             // root folder path
+	opt        Options            // backend options
+	endpoint   string             // FileLu endpoint
+	client     *http.Client       // HTTP client
+	isFile     bool               // whether this fs points to a specific file
+	targetFile string             // specific file being targeted in single-file operations
+	dirCache   *dircache.DirCache // folder IDs by path from the account root
+	pacer      *fs.Pacer          // pacer for API calls
+	features   *fs.Features       // optional features
+
+	objectCacheMu sync.Mutex              // protects objectCache
+	objectCache   map[string]manifestFile // files by remote, seeded by importmanifest
+
+	linkCacheMu sync.Mutex                // protects linkCache
+	linkCache   map[string]directLinkInfo // direct links by file code or path
+
+	listCacheMu sync.Mutex                // protects listCache
+	listCache   map[string]listCacheEntry // folder listings by path, see folderListing
+
+	hashType hash.Type // type of the hashes FileLu reports, see probeHashType
+
+	sessionMu sync.Mutex      // protects sessions
+	sessions  []uploadSession // idle upload sessions, see getUploadSession
+
+	accountTypeMu sync.Mutex // protects accountType
+	accountType   string     // type of account once known, see uploadFields
+
+	defaultFolderMu sync.Mutex // protects defaultFolder
+	defaultFolder   string     // path of the default_folder_id folder once found
+
+	usageMu      sync.Mutex // protects usage and usageExpires
+	usage        *fs.Usage  // last result of About
+	usageExpires time.Time  // when to read usage again
+}
+
+// uploadSession is an upload server and session from upload/server
+type uploadSession struct {
+	url     string    // where to post uploads
+	id      string    // session ID to post with them
+	expires time.Time // when to stop reusing the session
+}
+
+// directLinkInfo is a direct link remembered by the link cache
+type directLinkInfo struct {
+	urls    []string  // download URLs, the first being FileLu's choice
+	size    int64     // size of the file
+	expires time.Time // when to stop using the link
 }
 
 // Object describes a FileLu object
+//
+// Unlike the Fs an Object isn't guarded, so as with other backends it
+// mustn't be read and changed, for example by Update, at the same time.
 type Object struct {
-	fs      *Fs
-	remote  string
-	size    int64
-	modTime time.Time
+	fs       *Fs
+	remote   string
+	size     int64
+	hasSize  bool // set if size has been discovered
+	modTime  time.Time
+	hash     string // hash reported by the listing, if known
+	fileCode string // FileLu file code, if known
+	mimeType string // content type, once looked up
+	version  string // version of the content, once read from file/info
 }
 
 // NewFs creates a new Fs object for FileLu
@@ -82,6 +1081,36 @@ func NewFs(ctx context.Context, name string, root string, m configmap.Mapper) (f
 	if opt.RcloneKey == "" {
 		return nil, fmt.Errorf("FileLu Rclone Key is required")
 	}
+	switch opt.SizeMethod {
+	case sizeMethodListing, sizeMethodInfo, sizeMethodHead:
+	default:
+		return nil, fmt.Errorf("unknown size_method %q", opt.SizeMethod)
+	}
+	switch opt.Overwrite {
+	case overwriteReplace, overwriteError, overwriteRename:
+	default:
+		return nil, fmt.Errorf("unknown overwrite %q", opt.Overwrite)
+	}
+	switch opt.AccountType {
+	case accountTypeAuto, accountTypePremium, accountTypeFree:
+	default:
+		return nil, fmt.Errorf("unknown account_type %q", opt.AccountType)
+	}
+	switch opt.MirrorSelection {
+	case mirrorSelectionFirst, mirrorSelectionRandom:
+	default:
+		return nil, fmt.Errorf("unknown mirror_selection %q", opt.MirrorSelection)
+	}
+	if err := sortEntries(nil, opt.ListOrder); err != nil {
+		return nil, err
+	}
+	if opt.TempDir != "" {
+		if info, err := os.Stat(opt.TempDir); err != nil {
+			return nil, fmt.Errorf("bad temp_dir: %w", err)
+		} else if !info.IsDir() {
+			return nil, fmt.Errorf("bad temp_dir: %q is not a directory", opt.TempDir)
+		}
+	}
 
 	client := fshttp.NewClient(ctx)
 
@@ -90,7 +1119,14 @@ func NewFs(ctx context.Context, name string, root string, m configmap.Mapper) (f
 	filename := ""
 	cleanRoot := strings.Trim(root, "/")
 
-	if strings.Contains(cleanRoot, ".") {
+	if opt.RootIsDrop {
+		if cleanRoot == "" || strings.Contains(cleanRoot, "/") {
+			return nil, fmt.Errorf("root_is_filedrop needs the root to be a filedrop code, got %q", root)
+		}
+	} else if cleanRoot == rootFolderID {
+		// The account root is "" however it is given
+		cleanRoot = ""
+	} else if strings.Contains(cleanRoot, ".") {
 		isFile = true
 		filename = path.Base(cleanRoot)
 		cleanRoot = path.Dir(cleanRoot)
@@ -107,34 +1143,214 @@ func NewFs(ctx context.Context, name string, root string, m configmap.Mapper) (f
 		client:     client,
 		isFile:     isFile,
 		targetFile: filename,
+		pacer:      fs.NewPacer(ctx, pacer.NewDefault(pacer.MinSleep(minSleep), pacer.MaxSleep(maxSleep), pacer.DecayConstant(decayConstant))),
 	}
+	f.dirCache = dircache.New("", rootFolderID, f)
+	f.features = (&fs.Features{
+		CanHaveEmptyDirectories: true,
+		ServerSideAcrossConfigs: true,
+	}).Fill(ctx, f)
+	f.start(ctx)
 
-	fs.Debugf(nil, "NewFs: Created filesystem with root path %q, isFile=%v, targetFile=%q", f.root, isFile, filename)
+	fs.Debugf(nil, "NewFs: Created filesystem with root path %q, isFile=%v, targetFile=%q", f.Root(), isFile, filename)
 	return f, nil
 }
 
-// isFileCode checks if a string looks like a file code
-func isFileCode(s string) bool {
-	if len(s) != 12 {
-		return false
+// callAPI sends a GET request for endpoint with params to the FileLu API
+// and decodes the JSON response into result.
+//
+// The key is added to params. All API traffic goes through f.client which
+// is instrumented by fshttp, so it shows up with --dump headers/bodies.
+//
+// Each call is limited to the timeout option.
+func (f *Fs) callAPI(ctx context.Context, endpoint string, params url.Values, result interface{}) error {
+	values := url.Values{}
+	for k, v := range params {
+		values[k] = v
+	}
+	values.Set("key", f.opt.RcloneKey)
+	apiURL := f.endpoint + endpoint + "?" + values.Encode()
+
+	if f.opt.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(f.opt.Timeout))
+		defer cancel()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", apiURL, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
 	}
-	for _, c := range s {
-		if !((c >= 'a' && c <= 'z') || (c >= '0' && c <= '9')) {
-			return false
+	// Setting Accept-Encoding stops the transport decoding the response
+	// itself, so it is decoded below
+	if f.opt.NoGzip {
+		req.Header.Set("Accept-Encoding", "identity")
+	} else {
+		req.Header.Set("Accept-Encoding", "gzip, deflate")
+	}
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send request: %w", err)
+	}
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			fs.Logf(nil, "Failed to close response body: %v", err)
 		}
+	}()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read response: %w", err)
 	}
-	return true
+	data, err = decodeContent(resp.Header.Get("Content-Encoding"), data)
+	if err != nil {
+		return fmt.Errorf("failed to read response: %w", err)
+	}
+	if err := unavailableError(resp.StatusCode, resp.Header.Get("Content-Type"), data); err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return &statusError{StatusCode: resp.StatusCode}
+	}
+
+	err = json.Unmarshal(data, result)
+	if err != nil {
+		return fmt.Errorf("error decoding response: %w", err)
+	}
+	return nil
+}
+
+// FindLeaf finds the folder leaf in the folder with ID pathID
+//
+// It implements dircache.DirCacher
+func (f *Fs) FindLeaf(ctx context.Context, pathID, leaf string) (pathIDOut string, found bool, err error) {
+	folders, err := f.listFolders(ctx, pathID)
+	if err != nil {
+		return "", false, err
+	}
+	id, found := f.matchLeaf(folders, leaf)
+	return id, found, nil
+}
+
+// matchLeaf returns the ID of the folder in folders which leaf names,
+// the way FindLeaf looks it up
+func (f *Fs) matchLeaf(folders []api.FolderListFolder, leaf string) (string, bool) {
+	id, name, decorated := "", leaf, false
+	if f.opt.FolderIDInPath {
+		id, name, decorated = parseFolderDecoration(leaf)
+	}
+	if !decorated {
+		if i := matchFolderName(folders, name); i >= 0 {
+			return strconv.FormatInt(folders[i].FldID, 10), true
+		}
+		return "", false
+	}
+	for _, folder := range folders {
+		folderID := strconv.FormatInt(folder.FldID, 10)
+		if folderID == id {
+			return folderID, true
+		}
+	}
+	return "", false
+}
+
+// folderLeaf returns the name folder has in remotes, which is decorated
+// with its ID with folder_id_in_path
+func (f *Fs) folderLeaf(folder api.FolderListFolder) string {
+	if f.opt.FolderIDInPath {
+		return decorateFolderName(folder.FldID, folder.Name)
+	}
+	return folder.Name
+}
+
+// cacheFolders puts the IDs of folders, the subfolders of the folder at
+// parentPath from the account root, in the dir cache under the names
+// listings give them.
+//
+// FindLeaf takes the first of folders with the same name so that is the
+// one cached.
+func (f *Fs) cacheFolders(parentPath string, folders []api.FolderListFolder) {
+	cached := make(map[string]bool, len(folders))
+	for _, folder := range folders {
+		dir := path.Join(parentPath, f.folderLeaf(folder))
+		if !cached[dir] {
+			f.dirCache.Put(dir, strconv.FormatInt(folder.FldID, 10))
+			cached[dir] = true
+		}
+	}
+}
+
+// apiPath returns the path FileLu knows the file or folder at p, from the
+// account root, by.
+//
+// It starts with a slash and has any folder ID decorations removed, see
+// the folder_id_in_path option.
+func (f *Fs) apiPath(p string) string {
+	if f.opt.FolderIDInPath {
+		p = stripFolderIDs(p)
+	}
+	return "/" + strings.Trim(p, "/")
 }
 
-// resolveFolderPath takes a path and returns the folder ID, creating the folder if it doesn't exist
-// resolveFolderPath takes a path and returns the folder ID, verifying the ID if provided.
-func (f *Fs) resolveFolderPath(ctx context.Context, path string) (int, error) {
+// CreateDir makes a folder called leaf in the folder with ID pathID
+//
+// It implements dircache.DirCacher
+func (f *Fs) CreateDir(ctx context.Context, pathID, leaf string) (newID string, err error) {
+	var result api.FolderResponse
+	err = f.pacer.Call(func() (bool, error) {
+		err := f.callAPI(ctx, "/folder/create", url.Values{"parent_id": {pathID}, "name": {leaf}}, &result)
+		return shouldRetry(ctx, err)
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to create folder: %w", err)
+	}
+	if isFolderExists(result.Status, result.Msg) {
+		// Lost a race to create it so use the one which won
+		return f.existingFolderID(ctx, pathID, leaf)
+	}
+	if result.Status != 200 {
+		return "", fmt.Errorf("error: %s", result.Msg)
+	}
+	if fldID := result.FldID(); fldID != "" {
+		return fldID, nil
+	}
+	// Some responses only say the folder was made so look it up
+	id, found, err := f.FindLeaf(ctx, pathID, leaf)
+	if err != nil {
+		return "", fmt.Errorf("failed to find new folder: %w", err)
+	}
+	if !found {
+		return "", fmt.Errorf("folder %q made but not found", leaf)
+	}
+	return id, nil
+}
+
+// existingFolderID returns the ID of the folder leaf in the folder with ID
+// pathID, which folder/create said already exists
+func (f *Fs) existingFolderID(ctx context.Context, pathID, leaf string) (string, error) {
+	id, found, err := f.FindLeaf(ctx, pathID, leaf)
+	if err != nil {
+		return "", fmt.Errorf("failed to find existing folder: %w", err)
+	}
+	if !found {
+		return "", fmt.Errorf("folder %q said to exist but not found", leaf)
+	}
+	fs.Debugf(f, "Folder %q was created by someone else with ID %s", leaf, id)
+	return id, nil
+}
+
+// resolveFolderPath takes a path and returns the folder ID.
+//
+// With folder_id_in_path a "(id) name" part is taken to be the folder
+// with that ID, otherwise parts are only ever folder names.
+func (f *Fs) resolveFolderPath(ctx context.Context, path string) (int64, error) {
 	if path == "" {
 		return 0, nil // Root directory
 	}
 
 	parts := strings.Split(path, "/")
-	currentID := 0 // Start from root
+	currentID := int64(0) // Start from root
 
 	for _, part := range parts {
 		if part == "" {
@@ -142,11 +1358,9 @@ func (f *Fs) resolveFolderPath(ctx context.Context, path string) (int, error) {
 		}
 
 		// Extract folder ID if the format is "(id) name"
-		if strings.HasPrefix(part, "(") {
-			end := strings.Index(part, ")")
-			if end != -1 {
-				idStr := part[1:end]
-				if id, err := strconv.Atoi(idStr); err == nil {
+		if f.opt.FolderIDInPath {
+			if idStr, _, ok := parseFolderDecoration(part); ok {
+				if id, err := strconv.ParseInt(idStr, 10, 64); err == nil {
 					currentID = id
 					continue
 				}
@@ -154,212 +1368,295 @@ func (f *Fs) resolveFolderPath(ctx context.Context, path string) (int, error) {
 		}
 
 		// Lookup folder by name under the currentID
-		apiURL := fmt.Sprintf("%s/folder/list?fld_id=%d&key=%s",
-			f.endpoint,
-			currentID,
-			url.QueryEscape(f.opt.RcloneKey))
-
-		req, err := http.NewRequestWithContext(ctx, "GET", apiURL, nil)
-		if err != nil {
-			return 0, err
-		}
-
-		resp, err := f.client.Do(req)
-		if err != nil {
-			return 0, err
-		}
-
-		defer func() {
-			if err := resp.Body.Close(); err != nil {
-				fs.Logf(nil, "Failed to close response body: %v", err)
-			}
-		}()
-		if resp.StatusCode != 200 {
-			return 0, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
-		}
-
-		var result struct {
-			Status int    `json:"status"`
-			Msg    string `json:"msg"`
-			Result struct {
-				Folders []struct {
-					Name  string `json:"name"`
-					FldID int    `json:"fld_id"`
-				} `json:"folders"`
-			} `json:"result"`
-		}
-
-		err = json.NewDecoder(resp.Body).Decode(&result)
+		folders, err := f.listFolders(ctx, strconv.FormatInt(currentID, 10))
 		if err != nil {
 			return 0, err
 		}
 
-		if result.Status != 200 {
-			return 0, fmt.Errorf("error: %s", result.Msg)
-		}
-
-		found := false
-		for _, folder := range result.Result.Folders {
-			if folder.Name == part {
-				currentID = folder.FldID
-				found = true
-				break
-			}
-		}
-
-		if !found {
+		i := matchFolderName(folders, part)
+		if i < 0 {
 			return 0, fs.ErrorDirNotFound
 		}
+		currentID = folders[i].FldID
 	}
 
 	return currentID, nil
 }
 
 // GetAccountInfo fetches the account information including storage usage
-func (f *Fs) GetAccountInfo(ctx context.Context) (string, string, error) {
-	apiURL := fmt.Sprintf("%s/account/info?key=%s", f.endpoint, url.QueryEscape(f.opt.RcloneKey))
-
-	req, err := http.NewRequestWithContext(ctx, "GET", apiURL, nil)
+func (f *Fs) GetAccountInfo(ctx context.Context) (*api.AccountInfoResult, error) {
+	var result api.AccountInfoResponse
+	err := f.pacer.Call(func() (bool, error) {
+		err := f.callAPI(ctx, "/account/info", nil, &result)
+		return shouldRetry(ctx, err)
+	})
 	if err != nil {
-		return "", "", fmt.Errorf("failed to create request: %w", err)
+		return nil, err
 	}
 
-	resp, err := f.client.Do(req)
-	if err != nil {
-		return "", "", fserrors.FsError(err)
+	if result.Status != 200 {
+		return nil, fmt.Errorf("error: %s", result.Msg)
 	}
-	defer func() {
-		if err := resp.Body.Close(); err != nil {
-			fs.Fatalf(nil, "Failed to close response body: %v", err)
-		}
-	}()
 
-	if resp.StatusCode != http.StatusOK {
-		return "", "", fmt.Errorf("received HTTP status %d", resp.StatusCode)
-	}
+	return &result.Result, nil
+}
 
-	var result api.AccountInfoResponse
-	err = json.NewDecoder(resp.Body).Decode(&result)
+// checkKey checks the key works by reading the account info and listing
+// the root folder. It returns a description of the storage plan.
+func (f *Fs) checkKey(ctx context.Context) (string, error) {
+	const hint = "check the Rclone key in My Account at https://filelu.com/account/ - a new key is generated each time Rclone is toggled off and on"
+
+	var info api.AccountInfoResponse
+	err := f.callAPI(ctx, "/account/info", nil, &info)
 	if err != nil {
-		return "", "", fmt.Errorf("error decoding response: %w", err)
+		return "", fmt.Errorf("couldn't read account info: %w", err)
+	}
+	if info.Status != 200 {
+		return "", fmt.Errorf("invalid key: %s: %s", info.Msg, hint)
 	}
 
-	if result.Status != 200 {
-		return "", "", fmt.Errorf("error: %s", result.Msg)
+	var list api.FolderListResponse
+	err = f.callAPI(ctx, "/folder/list", url.Values{"fld_id": {rootFolderID}}, &list)
+	if err != nil {
+		return "", fmt.Errorf("couldn't list root folder: %w", err)
+	}
+	if list.Status != 200 {
+		return "", fmt.Errorf("couldn't list root folder: %s: %s", list.Msg, hint)
 	}
 
-	return result.Result.Storage, result.Result.StorageUsed, nil
+	plan := fmt.Sprintf("account %s, storage %s, used %s", info.Result.UType, info.Result.Storage, info.Result.StorageUsed)
+	if info.Result.PremiumExpire != "" {
+		plan += fmt.Sprintf(", premium until %s", info.Result.PremiumExpire)
+	}
+	return plan, nil
 }
 
 // Features returns the optional features of this Fs
 func (f *Fs) Features() *fs.Features {
-	return &fs.Features{
-		About:                   f.About,
-		Command:                 f.Command,
-		DirMove:                 nil,
-		CanHaveEmptyDirectories: true,
-	}
+	return f.features
+}
+
+// DirCacheFlush resets the directory cache - used in testing as an
+// optional interface
+func (f *Fs) DirCacheFlush() {
+	f.dirCache.ResetRoot()
 }
 
 // DeleteFile sends an API request to remove a file from FileLu
 func (f *Fs) DeleteFile(ctx context.Context, filePath string) error {
 	fs.Debugf(f, "DeleteFile: Attempting to delete file at path %q", filePath)
+	defer f.forgetListing(path.Dir(f.apiPath(filePath)))
 
 	// Ensure filePath starts with a forward slash and remove any trailing slashes
-	filePath = "/" + strings.Trim(filePath, "/")
-
-	// Construct the API URL for deletion
-	apiURL := fmt.Sprintf("%s/file/remove?file_path=%s&restore=1&key=%s",
-		f.endpoint,
-		url.QueryEscape(filePath),
-		url.QueryEscape(f.opt.RcloneKey),
-	)
-
-	fs.Debugf(f, "DeleteFile: Sending DELETE request to %s", apiURL)
+	filePath = f.apiPath(filePath)
 
-	// Create HTTP request
-	req, err := http.NewRequestWithContext(ctx, "GET", apiURL, nil)
+	err := f.removeFile(ctx, url.Values{"file_path": {filePath}})
 	if err != nil {
-		return fmt.Errorf("failed to create delete request: %w", err)
+		return err
 	}
 
-	// Execute request
-	resp, err := f.client.Do(req)
-	if err != nil {
-		return fmt.Errorf("failed to send delete request: %w", err)
-	}
-	defer func() {
-		if err := resp.Body.Close(); err != nil {
-			fs.Fatalf(nil, "Failed to close response body: %v", err)
-		}
-	}()
+	fs.Infof(f, "Successfully deleted file: %s", filePath)
+	return nil
+}
+
+// deleteFileByCode sends an API request to remove the file with fileCode
+func (f *Fs) deleteFileByCode(ctx context.Context, fileCode string) error {
+	fs.Debugf(f, "deleteFileByCode: Attempting to delete file with code %q", fileCode)
 
-	// Read and log the full response body for debugging
-	body, err := io.ReadAll(resp.Body)
+	err := f.removeFile(ctx, url.Values{"file_code": {fileCode}})
 	if err != nil {
-		return fmt.Errorf("error reading response body: %w", err)
+		return err
 	}
-	fs.Debugf(f, "DeleteFile: Response body: %s", string(body))
 
-	// Parse response
+	fs.Infof(f, "Successfully deleted file with code: %s", fileCode)
+	return nil
+}
+
+// removeFile calls file/remove for the file identified by params
+func (f *Fs) removeFile(ctx context.Context, params url.Values) error {
+	params.Set("restore", "1")
 	var result struct {
 		Status int    `json:"status"`
 		Msg    string `json:"msg"`
 	}
-	err = json.NewDecoder(bytes.NewReader(body)).Decode(&result)
+	err := f.pacer.Call(func() (bool, error) {
+		err := f.callAPI(ctx, "/file/remove", params, &result)
+		return shouldRetry(ctx, err)
+	})
 	if err != nil {
-		return fmt.Errorf("error decoding delete response: %w", err)
+		return fmt.Errorf("failed to delete file: %w", err)
 	}
 
 	// Check API response status
 	if result.Status != 200 {
 		return fmt.Errorf("error while deleting file: %s", result.Msg)
 	}
-
-	fs.Infof(f, "Successfully deleted file: %s", filePath)
 	return nil
 }
 
-// Rename a file using file path
-func (f *Fs) renameFile(ctx context.Context, filePath, newName string) error {
-	// Ensure filePath starts with a forward slash
-	filePath = "/" + strings.Trim(filePath, "/")
+// deleteResult is returned by the delete command
+type deleteResult struct {
+	Deleted []string          `json:"deleted"` // files which were deleted
+	Failed  map[string]string `json:"failed"`  // why each file which wasn't deleted failed
+}
 
-	apiURL := fmt.Sprintf("%s/file/rename?file_path=%s&name=%s&key=%s",
-		f.endpoint,
-		url.QueryEscape(filePath),
-		url.QueryEscape(newName),
-		url.QueryEscape(f.opt.RcloneKey),
-	)
+// deleteFiles deletes each file in args, given either as a file code or
+// as a path relative to the root, carrying on past failures.
+//
+// If any fail the error returned lists what was and wasn't deleted.
+func (f *Fs) deleteFiles(ctx context.Context, args []string) (*deleteResult, error) {
+	result := &deleteResult{Deleted: []string{}, Failed: map[string]string{}}
+
+	// Delete the file codes first so they can be batched
+	var codes []string
+	for _, arg := range args {
+		if isFileCode(arg) {
+			codes = append(codes, arg)
+		}
+	}
+	codeErrs := f.deleteFileCodes(ctx, codes)
 
-	fs.Debugf(f, "renameFile: Sending rename request to %s", apiURL)
+	var failed []string
+	for _, arg := range args {
+		var err error
+		if isFileCode(arg) {
+			err = codeErrs[arg]
+		} else {
+			err = f.DeleteFile(ctx, path.Join(f.Root(), arg))
+			f.forgetObject(arg)
+		}
+		if err != nil {
+			fs.Errorf(f, "delete: failed to delete %q: %v", arg, err)
+			result.Failed[arg] = err.Error()
+			failed = append(failed, fmt.Sprintf("%s (%v)", arg, err))
+			continue
+		}
+		result.Deleted = append(result.Deleted, arg)
+	}
+	if len(failed) > 0 {
+		return result, fmt.Errorf("delete: failed to delete %d of %d files: %s; deleted: %s",
+			len(failed), len(args), strings.Join(failed, ", "), strings.Join(result.Deleted, ", "))
+	}
+	return result, nil
+}
 
-	req, err := http.NewRequestWithContext(ctx, "GET", apiURL, nil)
-	if err != nil {
-		return fmt.Errorf("failed to create rename request: %w", err)
+// deleteFileCodes deletes the files with codes, returning the errors for
+// the ones which couldn't be deleted by code.
+//
+// If the batch_delete option is set the codes are deleted that many at a
+// time. A batch which fails is retried one file at a time, which finds
+// out which files failed and works if FileLu doesn't support batches.
+func (f *Fs) deleteFileCodes(ctx context.Context, codes []string) map[string]error {
+	errs := make(map[string]error)
+	batchSize := f.opt.BatchDelete
+	if batchSize <= 1 {
+		batchSize = 1
+	}
+	for start := 0; start < len(codes); start += batchSize {
+		batch := codes[start:min(start+batchSize, len(codes))]
+		if len(batch) > 1 {
+			err := f.removeFile(ctx, url.Values{"file_code": {strings.Join(batch, ",")}})
+			if err == nil {
+				fs.Infof(f, "Successfully deleted %d files in a batch", len(batch))
+				continue
+			}
+			fs.Debugf(f, "Batch delete of %d files failed, deleting one at a time: %v", len(batch), err)
+		}
+		for _, code := range batch {
+			if err := f.deleteFileByCode(ctx, code); err != nil {
+				errs[code] = err
+			}
+		}
 	}
+	return errs
+}
 
-	resp, err := f.client.Do(req)
+// Purge deletes all the files and folders in dir, including dir itself
+// unless it is the account root.
+//
+// The files are deleted by file code so they can be batched, see the
+// batch_delete option.
+func (f *Fs) Purge(ctx context.Context, dir string) error {
+	if f.opt.RootIsDrop {
+		return errFiledrop
+	}
+	defer f.forgetListingTree(path.Join(f.Root(), dir))
+	var (
+		codes   []string
+		remotes = map[string]string{} // remote by code
+		dirs    = []string{dir}
+	)
+	err := f.ListR(ctx, dir, func(entries fs.DirEntries) error {
+		for _, entry := range entries {
+			switch x := entry.(type) {
+			case fs.Directory:
+				dirs = append(dirs, x.Remote())
+			case *Object:
+				if x.fileCode == "" {
+					if err := f.DeleteFile(ctx, path.Join(f.Root(), x.remote)); err != nil {
+						return fmt.Errorf("failed to delete %q: %w", x.remote, err)
+					}
+					f.forgetObject(x.remote)
+					continue
+				}
+				codes = append(codes, x.fileCode)
+				remotes[x.fileCode] = x.remote
+			}
+		}
+		return nil
+	})
 	if err != nil {
-		return fmt.Errorf("failed to send rename request: %w", err)
+		return fmt.Errorf("purge: %w", err)
 	}
-	defer func() {
-		if err := resp.Body.Close(); err != nil {
-			fs.Fatalf(nil, "Failed to close response body: %v", err)
+
+	errs := f.deleteFileCodes(ctx, codes)
+	for _, code := range codes {
+		if errs[code] == nil {
+			f.forgetObject(remotes[code])
 		}
-	}()
+	}
+	if len(errs) > 0 {
+		failed := make([]string, 0, len(errs))
+		for code, err := range errs {
+			failed = append(failed, fmt.Sprintf("%s (%v)", remotes[code], err))
+		}
+		sort.Strings(failed)
+		return fmt.Errorf("purge: failed to delete %d files: %s", len(failed), strings.Join(failed, ", "))
+	}
 
-	var result struct {
-		Status int    `json:"status"`
-		Result string `json:"result"`
-		Msg    string `json:"msg"`
+	// Remove the folders, deepest first
+	sort.Slice(dirs, func(i, j int) bool {
+		depthI, depthJ := strings.Count(dirs[i], "/"), strings.Count(dirs[j], "/")
+		if depthI != depthJ {
+			return depthI > depthJ
+		}
+		return dirs[i] > dirs[j]
+	})
+	for _, d := range dirs {
+		if path.Join(f.Root(), d) == "" {
+			continue
+		}
+		if err := f.Rmdir(ctx, d); err != nil {
+			return fmt.Errorf("purge: failed to remove folder %q: %w", d, err)
+		}
 	}
+	f.dirCache.FlushDir(path.Join(f.Root(), dir))
+	return nil
+}
 
-	err = json.NewDecoder(resp.Body).Decode(&result)
+// Rename a file using file path
+func (f *Fs) renameFile(ctx context.Context, filePath, newName string) error {
+	filePath = f.apiPath(filePath)
+	fs.Debugf(f, "renameFile: renaming %q to %q", filePath, newName)
+
+	var result api.DeleteResponse
+	err := f.pacer.Call(func() (bool, error) {
+		err := f.callAPI(ctx, "/file/rename", url.Values{"file_path": {filePath}, "name": {newName}}, &result)
+		return shouldRetry(ctx, err)
+	})
 	if err != nil {
-		return fmt.Errorf("error decoding rename response: %w", err)
+		return fmt.Errorf("failed to rename file: %w", err)
 	}
-
 	if result.Status != 200 {
 		return fmt.Errorf("error while renaming file: %s", result.Msg)
 	}
@@ -370,70 +1667,136 @@ func (f *Fs) renameFile(ctx context.Context, filePath, newName string) error {
 
 // renameFolder handles folder renaming using folder paths
 func (f *Fs) renameFolder(ctx context.Context, folderPath string, newName string) error {
-	// Ensure the folder path starts with a forward slash
-	folderPath = "/" + strings.Trim(folderPath, "/")
-
-	apiURL := fmt.Sprintf("%s/folder/rename?folder_path=%s&name=%s&key=%s",
-		f.endpoint,
-		url.QueryEscape(folderPath),
-		url.QueryEscape(newName),
-		url.QueryEscape(f.opt.RcloneKey),
-	)
-
-	fs.Debugf(f, "renameFolder: Sending rename request to %s", apiURL)
+	folderPath = f.apiPath(folderPath)
+	fs.Debugf(f, "renameFolder: renaming %q to %q", folderPath, newName)
 
-	req, err := http.NewRequestWithContext(ctx, "GET", apiURL, nil)
+	var result api.FolderResponse
+	err := f.pacer.Call(func() (bool, error) {
+		err := f.callAPI(ctx, "/folder/rename", url.Values{"folder_path": {folderPath}, "name": {newName}}, &result)
+		return shouldRetry(ctx, err)
+	})
 	if err != nil {
-		return fmt.Errorf("failed to create rename folder request: %w", err)
+		return fmt.Errorf("failed to rename folder: %w", err)
+	}
+	if result.Status != 200 {
+		return fmt.Errorf("error while renaming folder: %s", result.Msg)
 	}
 
-	resp, err := f.client.Do(req)
-	if err != nil {
-		return fmt.Errorf("failed to send rename folder request: %w", err)
+	fs.Infof(f, "Successfully renamed folder at path: %s to %s", folderPath, newName)
+	return nil
+}
+
+// renameFolderChecked renames the folder at folderPath to newName after
+// checking it exists and that no sibling folder is already called newName.
+//
+// It returns the new path of the folder.
+func (f *Fs) renameFolderChecked(ctx context.Context, folderPath string, newName string) (string, error) {
+	folderPath = strings.Trim(folderPath, "/")
+	if folderPath == "" {
+		return "", errors.New("can't rename the root folder")
+	}
+	if newName == "" || strings.Contains(newName, "/") {
+		return "", fmt.Errorf("invalid folder name %q", newName)
+	}
+	if _, err := f.dirCache.FindDir(ctx, folderPath, false); err != nil {
+		return "", err
 	}
-	defer func() {
-		if err := resp.Body.Close(); err != nil {
-			fs.Fatalf(nil, "Failed to close response body: %v", err)
-		}
-	}()
 
-	var result struct {
-		Status int    `json:"status"`
-		Result string `json:"result"`
-		Msg    string `json:"msg"`
+	parent, _ := dircache.SplitPath(folderPath)
+	parentID, err := f.dirCache.FindDir(ctx, parent, false)
+	if err != nil {
+		return "", err
+	}
+	_, found, err := f.FindLeaf(ctx, parentID, newName)
+	if err != nil {
+		return "", err
+	}
+	if found {
+		return "", fs.ErrorDirExists
 	}
 
-	err = json.NewDecoder(resp.Body).Decode(&result)
+	err = f.renameFolder(ctx, folderPath, newName)
 	if err != nil {
-		return fmt.Errorf("error decoding rename folder response: %w", err)
+		return "", err
 	}
+	f.dirCache.FlushDir(folderPath)
 
-	if result.Status != 200 {
-		return fmt.Errorf("error while renaming folder: %s", result.Msg)
+	return "/" + path.Join(parent, newName), nil
+}
+
+// editFolderResult is returned by the editfolder command
+type editFolderResult struct {
+	Path    string            `json:"path"`    // path of the folder from the account root
+	Applied map[string]string `json:"applied"` // settings changed
+	Ignored []string          `json:"ignored"` // settings which can't be changed
+}
+
+// editFolder applies the settings in opt to the folder at dir, relative
+// to the root, and returns which were applied and which were ignored.
+//
+// Only the name can be changed. The code option selects the folder so
+// isn't a setting.
+func (f *Fs) editFolder(ctx context.Context, dir string, opt map[string]string) (*editFolderResult, error) {
+	keys := make([]string, 0, len(opt))
+	for key := range opt {
+		if key != "code" {
+			keys = append(keys, key)
+		}
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("editfolder: nothing to change, give settings with -o key=value")
 	}
+	sort.Strings(keys)
 
-	fs.Infof(f, "Successfully renamed folder at path: %s to %s", folderPath, newName)
-	return nil
+	folderPath := path.Join(f.Root(), dir)
+	if _, err := f.dirCache.FindDir(ctx, folderPath, false); err != nil {
+		return nil, fmt.Errorf("editfolder: %w", err)
+	}
+	result := &editFolderResult{
+		Path:    "/" + folderPath,
+		Applied: map[string]string{},
+		Ignored: []string{},
+	}
+	for _, key := range keys {
+		switch key {
+		case "name":
+			newPath, err := f.renameFolderChecked(ctx, folderPath, opt[key])
+			if err != nil {
+				return result, fmt.Errorf("editfolder: failed to set name: %w", err)
+			}
+			result.Path = newPath
+			folderPath = strings.TrimPrefix(newPath, "/")
+			result.Applied[key] = opt[key]
+		default:
+			result.Ignored = append(result.Ignored, key)
+		}
+	}
+	return result, nil
 }
 
 // Command method to handle file and folder rename
 func (f *Fs) Command(ctx context.Context, name string, args []string, opt map[string]string) (interface{}, error) {
+	if f.opt.RootIsDrop {
+		return nil, errFiledrop
+	}
+	// Commands may change any folder
+	defer f.forgetListings()
 	switch name {
 	case "rename":
 		if len(args) != 1 {
 			return nil, fmt.Errorf("rename command requires new_name argument")
 		}
 
-		// For file operations, construct the full path using f.root and f.targetFile
+		// For file operations, construct the full path using the root and target file
 		var filePath string
-		if f.isFile {
-			filePath = path.Join(f.root, f.targetFile)
+		if targetFile, isFile := f.rootFile(); isFile {
+			filePath = path.Join(f.Root(), targetFile)
 		} else {
 			return nil, fmt.Errorf("please specify a file to rename")
 		}
 
 		// Ensure the path starts with a forward slash
-		filePath = "/" + strings.Trim(filePath, "/")
+		filePath = f.apiPath(filePath)
 
 		newName := args[0]
 		// Remove any directory path from new name
@@ -454,10 +1817,10 @@ func (f *Fs) Command(ctx context.Context, name string, args []string, opt map[st
 			return nil, fmt.Errorf("movefile command requires destination_folder_path argument")
 		}
 
-		// For file operations, construct the full source path using f.root and f.targetFile
+		// For file operations, construct the full source path using the root and target file
 		var sourcePath string
-		if f.isFile {
-			sourcePath = path.Join(f.root, f.targetFile)
+		if targetFile, isFile := f.rootFile(); isFile {
+			sourcePath = path.Join(f.Root(), targetFile)
 			fs.Debugf(f, "Command movefile: Source path constructed as %q", sourcePath)
 		} else {
 			return nil, fmt.Errorf("please specify a file to move")
@@ -475,279 +1838,740 @@ func (f *Fs) Command(ctx context.Context, name string, args []string, opt map[st
 
 	// Handle move folder case in Command method
 	case "movefolder":
-		if len(args) != 1 {
-			return nil, fmt.Errorf("movefolder command requires destination_folder_path argument")
-		}
-
-		if f.isFile {
-			return nil, fmt.Errorf("cannot move a file with movefolder command, use movefile instead")
+		var src, dst string
+		switch len(args) {
+		case 1:
+			if _, isFile := f.rootFile(); isFile {
+				return nil, fmt.Errorf("cannot move a file with movefolder command, use movefile instead")
+			}
+			dir, err := f.moveFolderArg(ctx, opt, "", args[0])
+			if err != nil {
+				return nil, fmt.Errorf("folder move failed: %w", err)
+			}
+			src, dst = f.Root(), dir
+		case 2:
+			var err error
+			src, err = f.moveFolderArg(ctx, opt, f.Root(), args[0])
+			if err == nil {
+				dst, err = f.moveFolderArg(ctx, opt, f.Root(), args[1])
+			}
+			if err != nil {
+				return nil, fmt.Errorf("folder move failed: %w", err)
+			}
+		default:
+			return nil, fmt.Errorf("movefolder command requires [source_folder_path] destination_folder_path arguments")
 		}
 
-		sourcePath := f.root
-		destinationPath := args[0]
-
-		fs.Debugf(f, "Command movefolder: Moving folder from %q to folder %q", sourcePath, destinationPath)
+		fs.Debugf(f, "Command movefolder: Moving folder from %q to folder %q", src, dst)
 
-		err := f.moveFolderToDestination(ctx, sourcePath, destinationPath)
+		result, err := f.moveFolder(ctx, src, dst)
 		if err != nil {
 			return nil, fmt.Errorf("folder move failed: %w", err)
 		}
 
-		return nil, nil
+		return result, nil
 
 	// Handle renamefolder case in Command method
 	case "renamefolder":
 		fs.Debugf(f, "renamefolder: Received arguments: %+v", args)
 
-		if len(args) != 1 {
-			return nil, fmt.Errorf("renamefolder command requires new_name argument")
+		var folderPath, newName string
+		switch len(args) {
+		case 1:
+			folderPath, newName = f.Root(), args[0]
+		case 2:
+			dir, err := f.commandFolder(ctx, opt, args[0])
+			if err != nil {
+				return nil, fmt.Errorf("folder rename failed: %w", err)
+			}
+			folderPath, newName = path.Join(f.Root(), dir), args[1]
+		default:
+			return nil, fmt.Errorf("renamefolder command requires [folder_path] new_name arguments")
 		}
 
-		folderPath := f.root
-		newName := args[0]
-
 		fs.Debugf(f, "renamefolder: Renaming folder at path %q to %q", folderPath, newName)
 
-		// Perform the folder rename operation
-		err := f.renameFolder(ctx, folderPath, newName)
+		newPath, err := f.renameFolderChecked(ctx, folderPath, newName)
 		if err != nil {
 			return nil, fmt.Errorf("folder rename failed: %w", err)
 		}
 
-		return nil, nil
-
-	default:
-		return nil, fs.ErrorCommandNotFound
-	}
-}
-
-// moveFolderToDestination moves a folder to a different location within FileLu
-func (f *Fs) moveFolderToDestination(ctx context.Context, folderPath string, destFolderPath string) error {
-	// Ensure paths start with forward slashes
-	folderPath = "/" + strings.Trim(folderPath, "/")
-	destFolderPath = "/" + strings.Trim(destFolderPath, "/")
-
-	apiURL := fmt.Sprintf("%s/folder/move?folder_path=%s&dest_folder_path=%s&key=%s",
-		f.endpoint,
-		url.QueryEscape(folderPath),
-		url.QueryEscape(destFolderPath),
-		url.QueryEscape(f.opt.RcloneKey),
-	)
+		return newPath, nil
 
-	fs.Debugf(f, "moveFolderToDestination: Sending move request to %s", apiURL)
+	case "editfolder":
+		dir := ""
+		switch len(args) {
+		case 0:
+		case 1:
+			var err error
+			dir, err = f.commandFolder(ctx, opt, args[0])
+			if err != nil {
+				return nil, fmt.Errorf("editfolder: %w", err)
+			}
+		default:
+			return nil, fmt.Errorf("editfolder command requires [folder_path] argument")
+		}
+		return f.editFolder(ctx, dir, opt)
 
-	req, err := http.NewRequestWithContext(ctx, "GET", apiURL, nil)
-	if err != nil {
-		return fmt.Errorf("failed to create move folder request: %w", err)
-	}
+	case "dedupe":
+		if len(args) != 0 {
+			return nil, fmt.Errorf("dedupe command takes no arguments")
+		}
+		mode := opt["mode"]
+		if mode == "" {
+			mode = "newest"
+		}
+		switch mode {
+		case "newest", "oldest", "list":
+		default:
+			return nil, fmt.Errorf("unknown dedupe mode %q", mode)
+		}
+		interactive := false
+		if value, ok := opt["interactive"]; ok {
+			var err error
+			interactive, err = strconv.ParseBool(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid interactive value %q: %w", value, err)
+			}
+		}
+		return f.dedupe(ctx, mode, interactive)
 
-	resp, err := f.client.Do(req)
-	if err != nil {
-		return fmt.Errorf("failed to send move folder request: %w", err)
-	}
-	defer func() {
-		if err := resp.Body.Close(); err != nil {
-			fs.Fatalf(nil, "Failed to close response body: %v", err)
+	case "delete":
+		if len(args) == 0 {
+			return nil, fmt.Errorf("delete command requires at least one file_code or path argument")
 		}
-	}()
+		return f.deleteFiles(ctx, args)
 
-	var result struct {
-		Status      int    `json:"status"`
-		Msg         string `json:"msg"`
-		SourceFldID string `json:"source_fld_id"`
-		DestFldID   string `json:"dest_fld_id"`
-	}
+	case "copyfolder":
+		if len(args) != 2 {
+			return nil, fmt.Errorf("copyfolder command requires source_path and destination_path arguments")
+		}
+		srcDir, err := f.commandFolder(ctx, opt, args[0])
+		if err != nil {
+			return nil, fmt.Errorf("copyfolder: %w", err)
+		}
+		return f.copyFolder(ctx, srcDir, strings.Trim(args[1], "/"))
+
+	case "importlink":
+		switch len(args) {
+		case 1:
+			return f.importLink(ctx, "", args[0])
+		case 2:
+			dir, err := f.commandFolder(ctx, opt, args[0])
+			if err != nil {
+				return nil, fmt.Errorf("importlink: %w", err)
+			}
+			return f.importLink(ctx, dir, args[1])
+		}
+		return nil, fmt.Errorf("importlink command requires [folder_path] link arguments")
 
-	err = json.NewDecoder(resp.Body).Decode(&result)
-	if err != nil {
-		return fmt.Errorf("error decoding move folder response: %w", err)
-	}
+	case "foldertree":
+		if len(args) != 0 {
+			return nil, fmt.Errorf("foldertree command takes no arguments")
+		}
+		depth := -1
+		if value, ok := opt["depth"]; ok {
+			var err error
+			depth, err = strconv.Atoi(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid depth value %q: %w", value, err)
+			}
+		}
+		return f.folderTree(ctx, depth)
 
-	if result.Status != 200 {
-		return fmt.Errorf("error while moving folder: %s", result.Msg)
-	}
+	case "mkdirtree":
+		if len(args) == 0 {
+			return nil, fmt.Errorf("mkdirtree command requires at least one folder_path argument")
+		}
+		return f.mkdirTree(ctx, args)
 
-	fs.Infof(f, "Successfully moved folder from %s to %s", folderPath, destFolderPath)
-	return nil
-}
+	case "exportmanifest", "importmanifest":
+		if len(args) != 1 {
+			return nil, fmt.Errorf("%s command requires local_path argument", name)
+		}
+		if name == "exportmanifest" {
+			return f.exportManifest(ctx, args[0])
+		}
+		return f.importManifest(ctx, args[0])
+
+	case "verify":
+		var folderPath, localPath string
+		switch len(args) {
+		case 1:
+			folderPath, localPath = "", args[0]
+		case 2:
+			folderPath, localPath = args[0], args[1]
+		default:
+			return nil, fmt.Errorf("verify command requires [folder_path] local_path arguments")
+		}
+		dir, err := f.commandFolder(ctx, opt, folderPath)
+		if err != nil {
+			return nil, fmt.Errorf("verify: %w", err)
+		}
+		return f.verify(ctx, dir, localPath)
 
-// moveFileToDestination moves a file to a different folder using file paths
-func (f *Fs) moveFileToDestination(ctx context.Context, filePath string, destinationFolderPath string) error {
-	// Ensure paths start with forward slashes
-	filePath = "/" + strings.Trim(filePath, "/")
-	destinationFolderPath = "/" + strings.Trim(destinationFolderPath, "/")
-
-	apiURL := fmt.Sprintf("%s/file/set_folder?file_path=%s&destination_folder_path=%s&key=%s",
-		f.endpoint,
-		url.QueryEscape(filePath),
-		url.QueryEscape(destinationFolderPath),
-		url.QueryEscape(f.opt.RcloneKey),
-	)
+	case "download":
+		if len(args) != 2 {
+			return nil, fmt.Errorf("download command requires file_code local_path arguments")
+		}
+		return f.download(ctx, args[0], args[1])
 
-	fs.Debugf(f, "moveFileToDestination: Sending move request to %s", apiURL)
+	case "fileinfo":
+		if len(args) != 1 {
+			return nil, fmt.Errorf("fileinfo command requires path_or_file_code argument")
+		}
+		return f.fileInfo(ctx, args[0])
 
-	req, err := http.NewRequestWithContext(ctx, "GET", apiURL, nil)
-	if err != nil {
-		return fmt.Errorf("failed to create move request: %w", err)
-	}
+	case "directlink":
+		if len(args) != 1 {
+			return nil, fmt.Errorf("directlink command requires path_or_file_code argument")
+		}
+		return f.refreshDirectLink(ctx, args[0])
+
+	case "downloadfolder":
+		var folderPath, localPath string
+		switch len(args) {
+		case 1:
+			folderPath, localPath = "", args[0]
+		case 2:
+			folderPath, localPath = args[0], args[1]
+		default:
+			return nil, fmt.Errorf("downloadfolder command requires [folder_path] local_path arguments")
+		}
+		dir, err := f.commandFolder(ctx, opt, folderPath)
+		if err != nil {
+			return nil, fmt.Errorf("downloadfolder: %w", err)
+		}
+		return f.downloadFolder(ctx, dir, localPath)
 
-	resp, err := f.client.Do(req)
-	if err != nil {
-		return fmt.Errorf("failed to send move request: %w", err)
-	}
-	defer func() {
-		if err := resp.Body.Close(); err != nil {
-			fs.Fatalf(nil, "Failed to close response body: %v", err)
+	case "setfolderid":
+		if len(args) != 1 {
+			return nil, fmt.Errorf("setfolderid command requires folder_id argument")
 		}
-	}()
+		return f.setFolderID(ctx, args[0])
 
-	var result struct {
-		Status int    `json:"status"`
-		Msg    string `json:"msg"`
+	default:
+		return nil, fs.ErrorCommandNotFound
 	}
+}
 
-	err = json.NewDecoder(resp.Body).Decode(&result)
+// dedupeGroup describes a set of files sharing the same content
+type dedupeGroup struct {
+	Hash    string   `json:"hash"`
+	Kept    string   `json:"kept"`
+	Removed []string `json:"removed"`
+}
+
+// dedupe finds files with the same server side hash and removes all
+// but one of them, chosen by mode or interactively.
+func (f *Fs) dedupe(ctx context.Context, mode string, interactive bool) ([]dedupeGroup, error) {
+	byHash := make(map[string][]*Object)
+	err := f.ListR(ctx, "", func(entries fs.DirEntries) error {
+		for _, entry := range entries {
+			o, ok := entry.(*Object)
+			if !ok {
+				continue
+			}
+			// With hash_on_list the listing has already given the hash
+			if o.hash == "" && o.fileCode != "" && !f.opt.HashOnList {
+				info, err := f.readFileInfo(ctx, o.fileCode)
+				if err != nil {
+					return fmt.Errorf("failed to read hash of %q: %w", o.remote, err)
+				}
+				o.hash = info.Hash
+			}
+			if o.hash == "" {
+				continue
+			}
+			byHash[o.hash] = append(byHash[o.hash], o)
+		}
+		return nil
+	})
 	if err != nil {
-		return fmt.Errorf("error decoding move response: %w", err)
+		return nil, fmt.Errorf("dedupe: failed to list files: %w", err)
 	}
 
-	if result.Status != 200 {
-		return fmt.Errorf("error while moving file: %s", result.Msg)
+	hashes := make([]string, 0, len(byHash))
+	for hashValue, objs := range byHash {
+		if len(objs) > 1 {
+			hashes = append(hashes, hashValue)
+		}
 	}
+	sort.Strings(hashes)
 
-	fs.Infof(f, "Successfully moved file from %s to folder %s", filePath, destinationFolderPath)
-	return nil
-}
+	groups := make([]dedupeGroup, 0, len(hashes))
+	for _, hashValue := range hashes {
+		objs := byHash[hashValue]
+		// Sort oldest first, breaking ties on the path so the result is stable
+		sort.SliceStable(objs, func(i, j int) bool {
+			if !objs[i].modTime.Equal(objs[j].modTime) {
+				return objs[i].modTime.Before(objs[j].modTime)
+			}
+			return objs[i].remote < objs[j].remote
+		})
 
-// About provides usage statistics for the remote
-func (f *Fs) About(ctx context.Context) (*fs.Usage, error) {
-	storage, storageUsed, err := f.GetAccountInfo(ctx)
-	if err != nil {
-		return nil, err
+		keep := len(objs) - 1
+		if mode == "oldest" {
+			keep = 0
+		}
+		if interactive && mode != "list" {
+			fmt.Printf("%s: %d files with identical content\n", hashValue, len(objs))
+			for i, o := range objs {
+				fmt.Printf("  %d: %s (%s)\n", i+1, o.remote, o.modTime.Format(uploadedTimeFormat))
+			}
+			keep = config.ChooseNumber("Enter the number of the file to keep", 1, len(objs)) - 1
+		}
+
+		group := dedupeGroup{
+			Hash:    hashValue,
+			Kept:    objs[keep].remote,
+			Removed: []string{},
+		}
+		for i, o := range objs {
+			if i == keep {
+				continue
+			}
+			if mode == "list" {
+				fs.Logf(o, "Duplicate of %q", group.Kept)
+			} else if err := o.Remove(ctx); err != nil {
+				return groups, fmt.Errorf("dedupe: failed to remove %q: %w", o.remote, err)
+			}
+			group.Removed = append(group.Removed, o.remote)
+		}
+		groups = append(groups, group)
 	}
+	return groups, nil
+}
 
-	totalStorage, err := parseStorageToBytes(storage)
+// verifyResult is returned by the verify command
+type verifyResult struct {
+	Matched       int      `json:"matched"`        // number of files with matching hashes
+	Mismatched    []string `json:"mismatched"`     // files whose hashes differ
+	MissingRemote []string `json:"missing_remote"` // local files not on the remote
+	MissingLocal  []string `json:"missing_local"`  // remote files not in the local directory
+}
+
+// verify compares the hashes of the files in the folder at dir,
+// relative to the root, with those of the files in localPath
+func (f *Fs) verify(ctx context.Context, dir string, localPath string) (*verifyResult, error) {
+	localHashes := map[string]string{}
+	err := filepath.WalkDir(localPath, func(filePath string, d os.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(localPath, filePath)
+		if err != nil {
+			return err
+		}
+		sum, err := localHash(filePath, f.hashType)
+		if err != nil {
+			return err
+		}
+		localHashes[filepath.ToSlash(rel)] = sum
+		return nil
+	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse total storage: %w", err)
+		return nil, fmt.Errorf("verify: failed to read local directory: %w", err)
 	}
 
-	usedStorage, err := parseStorageToBytes(storageUsed)
+	result := &verifyResult{
+		Mismatched:    []string{},
+		MissingRemote: []string{},
+		MissingLocal:  []string{},
+	}
+	seen := map[string]struct{}{}
+	err = f.ListR(ctx, dir, func(entries fs.DirEntries) error {
+		for _, entry := range entries {
+			o, ok := entry.(*Object)
+			if !ok {
+				continue
+			}
+			rel := strings.TrimPrefix(strings.TrimPrefix(o.remote, dir), "/")
+			seen[rel] = struct{}{}
+			localSum, ok := localHashes[rel]
+			if !ok {
+				result.MissingLocal = append(result.MissingLocal, rel)
+				continue
+			}
+			remoteSum := o.hash
+			if remoteSum == "" && o.fileCode != "" {
+				info, err := f.readFileInfo(ctx, o.fileCode)
+				if err != nil {
+					return fmt.Errorf("failed to read hash of %q: %w", o.remote, err)
+				}
+				remoteSum = info.Hash
+			}
+			if strings.EqualFold(remoteSum, localSum) {
+				result.Matched++
+			} else {
+				result.Mismatched = append(result.Mismatched, rel)
+			}
+		}
+		return nil
+	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse used storage: %w", err)
+		return nil, fmt.Errorf("verify: %w", err)
 	}
-
-	return &fs.Usage{
-		Total: fs.NewUsageValue(totalStorage), // Total bytes available
-		Used:  fs.NewUsageValue(usedStorage),  // Total bytes used
-		Free:  fs.NewUsageValue(totalStorage - usedStorage),
-	}, nil
+	for rel := range localHashes {
+		if _, ok := seen[rel]; !ok {
+			result.MissingRemote = append(result.MissingRemote, rel)
+		}
+	}
+	sort.Strings(result.Mismatched)
+	sort.Strings(result.MissingRemote)
+	sort.Strings(result.MissingLocal)
+	return result, nil
 }
 
-// Hashes returns an empty hash set, indicating no hash support
-func (f *Fs) Hashes() hash.Set {
-	return hash.NewHashSet() // Properly creates an empty hash set
+// localMD5 returns the MD5 hash of the whole local file at filePath
+func localMD5(filePath string) (string, error) {
+	return localHash(filePath, hash.MD5)
 }
 
-// Mkdir creates a new folder on FileLu
-func (f *Fs) Mkdir(ctx context.Context, dir string) error {
-	fs.Debugf(f, "Mkdir: Starting directory creation for dir=%q, root=%q", dir, f.root)
-
-	// If dir is empty, assume root directory
-	if dir == "" {
-		dir = f.root
-		if dir == "" {
-			return fmt.Errorf("directory name cannot be empty")
+// localHash returns the hash of type t of the whole local file at
+// filePath
+func localHash(filePath string, t hash.Type) (string, error) {
+	in, err := os.Open(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		if err := in.Close(); err != nil {
+			fs.Logf(nil, "Failed to close file: %v", err)
 		}
+	}()
+	sums, err := hash.StreamTypes(in, hash.NewHashSet(t))
+	if err != nil {
+		return "", fmt.Errorf("failed to hash %q: %w", filePath, err)
 	}
+	return sums[t], nil
+}
 
-	// Resolve parent folder ID
-	parentID := 0
-	parentDir := path.Dir(dir) // Get the parent directory path
-	if parentDir != "." && parentDir != "/" {
-		var err error
-		parentID, err = f.resolveFolderPath(ctx, parentDir)
-		if err != nil {
-			return fmt.Errorf("failed to resolve parent folder path: %w", err)
+// downloadFolderResult is returned by the downloadfolder command
+type downloadFolderResult struct {
+	Archive string `json:"archive"` // path of the archive if one was downloaded
+	Files   int    `json:"files"`   // number of files downloaded
+	Bytes   int64  `json:"bytes"`   // number of bytes downloaded
+}
+
+// downloadFolder downloads the folder at dir, relative to the root, into
+// the local directory localPath.
+//
+// It asks FileLu for an archive of the folder first and falls back to
+// downloading the files one by one if that isn't available.
+func (f *Fs) downloadFolder(ctx context.Context, dir string, localPath string) (*downloadFolderResult, error) {
+	if err := os.MkdirAll(localPath, 0777); err != nil {
+		return nil, fmt.Errorf("failed to create local directory: %w", err)
+	}
+
+	result, err := f.downloadFolderArchive(ctx, dir, localPath)
+	if err == nil {
+		return result, nil
+	}
+	fs.Debugf(f, "downloadfolder: archive not available, downloading files one by one: %v", err)
+
+	result = &downloadFolderResult{}
+	err = f.ListR(ctx, dir, func(entries fs.DirEntries) error {
+		for _, entry := range entries {
+			rel := strings.TrimPrefix(strings.TrimPrefix(entry.Remote(), dir), "/")
+			target := filepath.Join(localPath, filepath.FromSlash(rel))
+			switch x := entry.(type) {
+			case fs.Directory:
+				if err := os.MkdirAll(target, 0777); err != nil {
+					return fmt.Errorf("failed to create local directory: %w", err)
+				}
+			case fs.Object:
+				n, err := downloadObject(ctx, x, target)
+				if err != nil {
+					return err
+				}
+				result.Files++
+				result.Bytes += n
+			}
 		}
+		return nil
+	})
+	if err != nil {
+		return result, fmt.Errorf("downloadfolder: %w", err)
 	}
+	return result, nil
+}
 
-	// Create the directory
-	apiURL := fmt.Sprintf("%s/folder/create?parent_id=%d&name=%s&key=%s",
-		f.endpoint,
-		parentID,
-		url.QueryEscape(path.Base(dir)), // Use the base name of the path
-		url.QueryEscape(f.opt.RcloneKey),
-	)
-
-	req, err := http.NewRequestWithContext(ctx, "GET", apiURL, nil)
+// downloadFolderArchive downloads a server side archive of the folder at
+// dir into localPath
+func (f *Fs) downloadFolderArchive(ctx context.Context, dir string, localPath string) (*downloadFolderResult, error) {
+	folderPath := f.apiPath(path.Join(f.Root(), dir))
+	var result api.FolderZipResponse
+	err := f.callAPI(ctx, "/folder/zip", url.Values{"folder_path": {folderPath}}, &result)
 	if err != nil {
-		return fmt.Errorf("failed to create request: %w", err)
+		return nil, err
+	}
+	if result.Status != 200 || result.Result.URL == "" {
+		return nil, fmt.Errorf("error: %s", result.Msg)
 	}
 
+	req, err := http.NewRequestWithContext(ctx, "GET", result.Result.URL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create archive request: %w", err)
+	}
 	resp, err := f.client.Do(req)
 	if err != nil {
-		return fmt.Errorf("failed to create folder: %w", err)
+		return nil, fmt.Errorf("failed to download archive: %w", err)
 	}
 	defer func() {
 		if err := resp.Body.Close(); err != nil {
-			fs.Fatalf(nil, "Failed to close response body: %v", err)
+			fs.Logf(nil, "Failed to close response body: %v", err)
 		}
 	}()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to download archive: HTTP %d", resp.StatusCode)
+	}
 
-	var result struct {
-		Status int    `json:"status"`
-		Msg    string `json:"msg"`
-		Result struct {
-			FldID string `json:"fld_id"`
-		} `json:"result"`
+	name := path.Base(folderPath)
+	if name == "/" {
+		name = "root"
+	}
+	archivePath := filepath.Join(localPath, name+".zip")
+	n, err := writeLocalFile(archivePath, resp.Body)
+	if err != nil {
+		return nil, err
 	}
+	return &downloadFolderResult{Archive: archivePath, Files: 1, Bytes: n}, nil
+}
 
-	err = json.NewDecoder(resp.Body).Decode(&result)
+// downloadObject downloads o into the local file at target
+//
+// The download is accounted as a transfer so it shows in the stats and
+// is subject to --bwlimit.
+func downloadObject(ctx context.Context, o fs.Object, target string) (n int64, err error) {
+	tr := accounting.Stats(ctx).NewTransfer(o, nil)
+	defer func() {
+		tr.Done(ctx, err)
+	}()
+	in, err := o.Open(ctx)
 	if err != nil {
-		return fmt.Errorf("error decoding response: %w", err)
+		return 0, fmt.Errorf("failed to open %q: %w", o.Remote(), err)
 	}
+	acc := tr.Account(ctx, in)
+	defer func() {
+		if err := acc.Close(); err != nil {
+			fs.Logf(o, "Failed to close reader: %v", err)
+		}
+	}()
+	return writeLocalFile(target, acc)
+}
+
+// writeLocalFile streams in to a new local file at target
+func writeLocalFile(target string, in io.Reader) (int64, error) {
+	out, err := os.Create(target)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create local file: %w", err)
+	}
+	n, err := io.Copy(out, in)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return n, fmt.Errorf("failed to write local file %q: %w", target, err)
+	}
+	return n, nil
+}
 
+// moveFolderToDestination moves a folder to a different location within FileLu
+func (f *Fs) moveFolderToDestination(ctx context.Context, folderPath string, destFolderPath string) error {
+	folderPath = f.apiPath(folderPath)
+	destFolderPath = f.apiPath(destFolderPath)
+	fs.Debugf(f, "moveFolderToDestination: moving %q to %q", folderPath, destFolderPath)
+
+	var result api.FolderResponse
+	err := f.pacer.Call(func() (bool, error) {
+		err := f.callAPI(ctx, "/folder/move", url.Values{"folder_path": {folderPath}, "dest_folder_path": {destFolderPath}}, &result)
+		return shouldRetry(ctx, err)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to move folder: %w", err)
+	}
 	if result.Status != 200 {
-		return fmt.Errorf("error: %s", result.Msg)
+		return fmt.Errorf("error while moving folder: %s", result.Msg)
+	}
+
+	fs.Infof(f, "Successfully moved folder from %s to %s", folderPath, destFolderPath)
+	return nil
+}
+
+// moveFileToDestination moves a file to a different folder using file paths
+func (f *Fs) moveFileToDestination(ctx context.Context, filePath string, destinationFolderPath string) error {
+	filePath = f.apiPath(filePath)
+	destinationFolderPath = f.apiPath(destinationFolderPath)
+	fs.Debugf(f, "moveFileToDestination: moving %q to %q", filePath, destinationFolderPath)
+
+	if err := f.setFileFolderByPath(ctx, filePath, destinationFolderPath); err != nil {
+		return err
+	}
+
+	fs.Infof(f, "Successfully moved file from %s to folder %s", filePath, destinationFolderPath)
+	return nil
+}
+
+// About provides usage statistics for the remote
+//
+// If the storage figures can't be parsed the corresponding fields are
+// left unset rather than failing, so `df` on a mount keeps working.
+func (f *Fs) About(ctx context.Context) (*fs.Usage, error) {
+	f.usageMu.Lock()
+	defer f.usageMu.Unlock()
+	if f.usage != nil && time.Now().Before(f.usageExpires) {
+		usage := *f.usage
+		return &usage, nil
+	}
+
+	info, err := f.GetAccountInfo(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	usage := &fs.Usage{}
+	totalStorage, totalErr := parseStorageToBytes(info.Storage, storageBareUnit)
+	if totalErr != nil {
+		fs.Debugf(f, "About: failed to parse total storage: %v", totalErr)
+	} else {
+		usage.Total = fs.NewUsageValue(totalStorage) // Total bytes available
+	}
+	usedStorage, usedErr := parseStorageToBytes(info.StorageUsed, storageUsedBareUnit)
+	if usedErr != nil {
+		fs.Debugf(f, "About: failed to parse used storage: %v", usedErr)
+	} else {
+		usage.Used = fs.NewUsageValue(usedStorage) // Total bytes used
+	}
+	if totalErr == nil && usedErr == nil {
+		usage.Free = fs.NewUsageValue(totalStorage - usedStorage)
+	}
+	if files, err := info.TotalFiles.Int64(); err == nil {
+		usage.Objects = fs.NewUsageValue(files) // Number of files
+	}
+	f.usage = usage
+	f.usageExpires = time.Now().Add(usageTTL)
+	return usage, nil
+}
+
+// Hashes returns the supported hash sets.
+func (f *Fs) Hashes() hash.Set {
+	if f.opt.DisableChecksum {
+		return hash.NewHashSet()
+	}
+	return hash.NewHashSet(f.hashType)
+}
+
+// serverHashTypes are the types of hash FileLu may report, told apart
+// by their length
+var serverHashTypes = []hash.Type{hash.MD5, hash.SHA1, hash.SHA256}
+
+// hashTypeOf returns the type of the hex hash sum, or hash.None if it
+// isn't one FileLu reports
+func hashTypeOf(sum string) hash.Type {
+	if _, err := hex.DecodeString(sum); err != nil {
+		return hash.None
+	}
+	for _, t := range serverHashTypes {
+		if hash.Width(t, false) == len(sum) {
+			return t
+		}
+	}
+	return hash.None
+}
+
+// start does the work NewFs does with the account once the Fs is made
+func (f *Fs) start(ctx context.Context) {
+	f.hashType = hash.MD5
+	if !f.opt.DisableChecksum && !f.opt.RootIsDrop && !f.opt.AssumeRootExists {
+		f.probeHashType(ctx)
 	}
+}
+
+// probeHashType sets the type of hash the account reports from the
+// hashes of the files in the account root.
+//
+// It stays MD5 if there are no files to look at or the probe fails.
+func (f *Fs) probeHashType(ctx context.Context) {
+	var result api.FolderListResponse
+	err := f.pacer.Call(func() (bool, error) {
+		err := f.callAPI(ctx, "/folder/list", url.Values{"fld_id": {rootFolderID}}, &result)
+		return shouldRetry(ctx, err)
+	})
+	if err != nil || result.Status != 200 {
+		fs.Debugf(f, "Couldn't probe hash type, assuming %v: %v %s", f.hashType, err, result.Msg)
+		return
+	}
+	for _, file := range result.Result.Files {
+		if t := hashTypeOf(file.Hash); t != hash.None {
+			f.hashType = t
+			fs.Debugf(f, "FileLu reports %v hashes", t)
+			return
+		}
+	}
+}
+
+// Mkdir creates a new folder on FileLu
+func (f *Fs) Mkdir(ctx context.Context, dir string) error {
+	fs.Debugf(f, "Mkdir: Starting directory creation for dir=%q, root=%q", dir, f.Root())
+	defer f.forgetListing(path.Join(f.Root(), dir))
 
-	fs.Infof(f, "Successfully created folder %q with ID %q", dir, result.Result.FldID)
+	if f.opt.RootIsDrop {
+		// The filedrop itself always exists
+		if dir == "" {
+			return nil
+		}
+		return errFiledrop
+	}
+
+	// Make the folder at its path from the account root, which always
+	// exists
+	dir = path.Join(f.Root(), dir)
+	if dir == "" {
+		return nil
+	}
+
+	if f.opt.StrictMkdir {
+		// Only the folder itself may be made
+		parent, _ := dircache.SplitPath(dir)
+		if _, err := f.dirCache.FindDir(ctx, parent, false); err != nil {
+			return err
+		}
+	}
+
+	// The dir cache finds the folder if it exists so it isn't made twice
+	id, err := f.dirCache.FindDir(ctx, dir, true)
+	if err != nil {
+		return fmt.Errorf("failed to create folder: %w", err)
+	}
+	fs.Debugf(f, "Mkdir: folder %q has ID %q", dir, id)
 	return nil
 }
 
 // Remove deletes the object from FileLu
 func (f *Fs) Remove(ctx context.Context, dir string) error {
+	if f.opt.RootIsDrop {
+		return errFiledrop
+	}
+	defer f.forgetListingTree(path.Join(f.Root(), dir))
 	// Check if the path is a file or directory and remove accordingly
 	fldID, err := f.getFolderID(ctx, dir)
 	if err != nil {
 		return fmt.Errorf("failed to get folder ID for %q: %w", dir, err)
 	}
-
-	// Delete folder
-	apiURL := fmt.Sprintf("%s/folder/delete?fld_id=%d&key=%s", f.endpoint, fldID, url.QueryEscape(f.opt.RcloneKey))
-	req, err := http.NewRequestWithContext(ctx, "GET", apiURL, nil)
-	if err != nil {
-		return fmt.Errorf("failed to create delete request: %w", err)
+	if fldID == 0 {
+		return errAccountRoot
 	}
 
-	resp, err := f.client.Do(req)
+	// Delete folder
+	var result api.FolderResponse
+	err = f.pacer.Call(func() (bool, error) {
+		err := f.callAPI(ctx, "/folder/delete", url.Values{"fld_id": {strconv.FormatInt(fldID, 10)}}, &result)
+		return shouldRetry(ctx, err)
+	})
 	if err != nil {
 		return fmt.Errorf("failed to delete folder: %w", err)
 	}
-	defer func() {
-		if err := resp.Body.Close(); err != nil {
-			fs.Fatalf(nil, "Failed to close response body: %v", err)
-		}
-	}()
-
-	var result struct {
-		Status int    `json:"status"`
-		Msg    string `json:"msg"`
-	}
-	err = json.NewDecoder(resp.Body).Decode(&result)
-	if err != nil {
-		return fmt.Errorf("error decoding response: %w", err)
-	}
 
 	if result.Status != 200 {
 		return fmt.Errorf("error: %s", result.Msg)
@@ -758,103 +2582,186 @@ func (f *Fs) Remove(ctx context.Context, dir string) error {
 }
 
 // Precision returns the precision of the remote
+//
+// FileLu only reports when a file was uploaded and can't be given
+// another time, so modification times aren't supported.
 func (f *Fs) Precision() time.Duration {
-	return time.Second
+	return fs.ModTimeNotSupported
 }
 
 // List lists the objects and directories in a remote directory
 func (f *Fs) List(ctx context.Context, dir string) (fs.DirEntries, error) {
-	fs.Debugf(f, "List: Starting for directory %q with root %q", dir, f.root)
+	fs.Debugf(f, "List: Starting for directory %q with root %q", dir, f.Root())
+
+	if f.opt.RootIsDrop {
+		return nil, errFiledrop
+	}
 
 	// If we're targeting a specific file, we should only list that file
-	if f.isFile {
-		fs.Debugf(f, "List: Single file mode, targeting file %q", f.targetFile)
-		obj, err := f.NewObject(ctx, f.targetFile)
+	if targetFile, isFile := f.rootFile(); isFile {
+		fs.Debugf(f, "List: Single file mode, targeting file %q", targetFile)
+		obj, err := f.NewObject(ctx, targetFile)
 		if err != nil {
 			return nil, err
 		}
 		return []fs.DirEntry{obj}, nil
 	}
-
-	// Construct the full path for directory listing
-	fullPath := path.Join(f.root, dir)
-	if fullPath != "" {
-		fullPath = "/" + strings.Trim(fullPath, "/")
+	entries, err := f.listDirectory(ctx, dir)
+	if errors.Is(err, fs.ErrorDirNotFound) && dir == "" && f.rootFileCode() != "" {
+		// The root is the code of a single file
+		obj, err := f.fileCodeObject(ctx, f.rootFileCode())
+		if err != nil {
+			return nil, err
+		}
+		return []fs.DirEntry{obj}, nil
 	}
+	return entries, err
+}
 
-	apiURL := fmt.Sprintf("%s/folder/list?folder_path=%s&key=%s",
-		f.endpoint,
-		url.QueryEscape(fullPath),
-		url.QueryEscape(f.opt.RcloneKey),
-	)
-
-	fs.Debugf(f, "List: Fetching folder contents from URL: %s", apiURL)
+// processingTime is how long after an upload FileLu may still list the
+// file as empty, see listedSize
+const processingTime = time.Hour
 
-	req, err := http.NewRequestWithContext(ctx, "GET", apiURL, nil)
+// listedSize returns the size of file, which the listing reports.
+//
+// FileLu lists files it is still processing after an upload as empty,
+// so a size of 0 for a file uploaded in the last processingTime is
+// checked with file/info. Only if that says the file is still
+// processing is the direct link, which has the real size, fetched. The
+// link is kept for opening the file.
+func (f *Fs) listedSize(ctx context.Context, file api.FolderListFile) int64 {
+	if file.Size != 0 || file.FileCode == "" {
+		return file.Size
+	}
+	uploaded, err := parseUploadedTime(file.Uploaded)
+	if err != nil || time.Since(uploaded) > processingTime {
+		return 0
+	}
+	info, err := f.readFileInfo(ctx, file.FileCode)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
+		fs.Debugf(f, "Can't check empty file %q: %v", file.Name, err)
+		return 0
 	}
-
-	resp, err := f.client.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("failed to list directory: %w", err)
+	if size, err := strconv.ParseInt(info.Size, 10, 64); err == nil && size != 0 {
+		fs.Debugf(f, "Listing says %q is empty but its info has %d bytes", file.Name, size)
+		return size
 	}
-	defer func() {
-		if err := resp.Body.Close(); err != nil {
-			fs.Fatalf(nil, "Failed to close response body: %v", err)
-		}
-	}()
-
-	body, err := io.ReadAll(resp.Body)
+	if !isPendingUpload(info.FileStatus) {
+		return 0
+	}
+	_, size, err := f.cachedDirectLink(ctx, file.FileCode, "")
 	if err != nil {
-		return nil, fmt.Errorf("error reading response body: %w", err)
+		fs.Debugf(f, "Can't check empty file %q: %v", file.Name, err)
+		return 0
+	}
+	if size != 0 {
+		fs.Debugf(f, "Listing says %q is empty but its direct link has %d bytes", file.Name, size)
 	}
-	fs.Debugf(f, "List: Response body: %s", string(body))
+	return size
+}
 
-	var result api.FolderListResponse
-	err = json.NewDecoder(bytes.NewReader(body)).Decode(&result)
-	if err != nil {
-		return nil, fmt.Errorf("error decoding response: %w", err)
+// listDirectory lists the files and folders in the folder at dir,
+// relative to the root, sorted by the list_order option
+func (f *Fs) listDirectory(ctx context.Context, dir string) (fs.DirEntries, error) {
+	// Construct the full path for directory listing
+	fullPath := path.Join(f.Root(), dir)
+	if fullPath != "" {
+		fullPath = f.apiPath(fullPath)
 	}
 
-	if result.Status != 200 {
-		return nil, fmt.Errorf("API error: %s", result.Msg)
+	result, err := f.folderListing(ctx, fullPath)
+	if err != nil {
+		return nil, err
 	}
 
 	entries := make([]fs.DirEntry, 0)
 
 	// Add files
-	for _, file := range result.Result.Files {
+	for _, file := range result.Files {
 		remote := path.Join(dir, file.Name)
 		filePath := path.Join(fullPath, file.Name)
 
-		// Get file size using the file info API
-		size, err := f.getFileSize(ctx, filePath)
-		if err != nil {
-			fs.Debugf(f, "Error getting file size for %q: %v", filePath, err)
-			size = 0 // Set default size to 0 if there's an error
+		var modTime time.Time
+		if !f.opt.NoModTime {
+			var err error
+			modTime, err = parseUploadedTime(file.Uploaded)
+			if err != nil {
+				fs.Debugf(f, "Error parsing upload time for %q: %v", filePath, err)
+				modTime = unknownModTime
+			}
 		}
 
 		obj := &Object{
-			fs:      f,
-			remote:  remote,
-			size:    size,
-			modTime: time.Now(), // Consider parsing file.Uploaded if available
+			fs:       f,
+			remote:   remote,
+			modTime:  modTime,
+			fileCode: file.FileCode,
+		}
+		if f.opt.HashOnList {
+			obj.hash = file.Hash
+		}
+		if f.opt.SizeMethod == sizeMethodListing {
+			obj.setSize(f.listedSize(ctx, file))
+		} else if _, err := obj.fetchSize(ctx); err != nil {
+			fs.Debugf(f, "Error getting file size for %q: %v", filePath, err)
+			obj.setSize(0)
 		}
 		entries = append(entries, obj)
 	}
 
 	// Add folders if not in single-file mode
-	if !f.isFile {
-		for _, folder := range result.Result.Folders {
-			remote := path.Join(dir, folder.Name)
-			entries = append(entries, fs.NewDir(remote, time.Now()))
+	if _, isFile := f.rootFile(); !isFile {
+		// Finding the root flushes the folder cache so it must be found
+		// before the folder IDs are remembered
+		// Remember the IDs so using a folder doesn't list its parents
+		if f.dirCache.FindRoot(ctx, false) == nil {
+			f.cacheFolders(path.Join(f.Root(), dir), result.Folders)
+		}
+		for _, folder := range result.Folders {
+			remote := path.Join(dir, f.folderLeaf(folder))
+			id := strconv.FormatInt(folder.FldID, 10)
+			entries = append(entries, fs.NewDir(remote, unknownModTime).SetID(id).SetSize(folderSize(folder.Size)))
 		}
 	}
 
+	if err := sortEntries(entries, f.opt.ListOrder); err != nil {
+		return nil, err
+	}
 	return entries, nil
 }
 
+// ListR lists the objects and directories of the Fs starting
+// from dir recursively into out.
+func (f *Fs) ListR(ctx context.Context, dir string, callback fs.ListRCallback) error {
+	list := walk.NewListRHelper(callback)
+	err := f.listR(ctx, dir, list, 1)
+	if err != nil {
+		return err
+	}
+	return list.Flush()
+}
+
+// listR adds the entries of dir, which is depth levels down, and all its
+// subdirectories to list, stopping at the list_recurse_limit
+func (f *Fs) listR(ctx context.Context, dir string, list *walk.ListRHelper, depth int) error {
+	limit := f.opt.ListRecurseLimit
+	entries, err := f.List(ctx, dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if err := list.Add(entry); err != nil {
+			return err
+		}
+		if d, ok := entry.(fs.Directory); ok && (limit <= 0 || depth < limit) {
+			if err := f.listR(ctx, d.Remote(), list, depth+1); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
 // ConvertSizeStringToInt64 parses a string size to int64, returning 0 if the parsing fails.
 func ConvertSizeStringToInt64(sizeStr string) int64 {
 	size, err := strconv.ParseInt(sizeStr, 10, 64)
@@ -868,45 +2775,17 @@ func ConvertSizeStringToInt64(sizeStr string) int64 {
 
 // getFileSize to get the file size of objects on the remote
 func (f *Fs) getFileSize(ctx context.Context, filePath string) (int64, error) {
-	// Ensure filePath starts with a forward slash
-	filePath = "/" + strings.Trim(filePath, "/")
-
-	apiURL := fmt.Sprintf("%s/file/info?file_path=%s&key=%s",
-		f.endpoint,
-		url.QueryEscape(filePath),
-		url.QueryEscape(f.opt.RcloneKey),
-	)
-
-	fs.Debugf(f, "getFileSize: Fetching file info from %s", apiURL)
-
-	req, err := http.NewRequestWithContext(ctx, "GET", apiURL, nil)
-	if err != nil {
-		return 0, fmt.Errorf("failed to create request: %w", err)
-	}
+	filePath = f.apiPath(filePath)
+	fs.Debugf(f, "getFileSize: Fetching file info of %q", filePath)
 
-	resp, err := f.client.Do(req)
+	var result api.FileInfoResponse
+	err := f.pacer.Call(func() (bool, error) {
+		err := f.callAPI(ctx, "/file/info", url.Values{"file_path": {filePath}}, &result)
+		return shouldRetry(ctx, err)
+	})
 	if err != nil {
 		return 0, fmt.Errorf("failed to fetch file info: %w", err)
 	}
-	defer func() {
-		if err := resp.Body.Close(); err != nil {
-			fs.Fatalf(nil, "Failed to close response body: %v", err)
-		}
-	}()
-
-	var result struct {
-		Status int    `json:"status"`
-		Msg    string `json:"msg"`
-		Result []struct {
-			Size string `json:"size"` // Size is still a string here
-		} `json:"result"`
-	}
-
-	err = json.NewDecoder(resp.Body).Decode(&result)
-	if err != nil {
-		return 0, fmt.Errorf("error decoding response: %w", err)
-	}
-
 	if result.Status != 200 || len(result.Result) == 0 {
 		return 0, fmt.Errorf("error fetching file info: %s", result.Msg)
 	}
@@ -920,27 +2799,19 @@ func (f *Fs) getFileSize(ctx context.Context, filePath string) (int64, error) {
 	return fileSize, nil
 }
 
-// getFolderID resolves and returns the folder ID for a given directory name or path
-func (f *Fs) getFolderID(ctx context.Context, dir string) (int, error) {
-	// If the directory is empty, return the root directory ID
-	if dir == "" {
-		rootID, err := strconv.Atoi(f.root)
-		if err != nil {
-			return 0, fmt.Errorf("invalid root directory ID: %w", err)
-		}
-		return rootID, nil
-	}
-
+// getFolderID resolves and returns the folder ID for a given directory
+// path relative to the root, or a folder ID. The account root is 0.
+func (f *Fs) getFolderID(ctx context.Context, dir string) (int64, error) {
 	// If the directory is a valid numeric ID, return it directly
-	if folderID, err := strconv.Atoi(dir); err == nil {
+	if folderID, err := strconv.ParseInt(dir, 10, 64); err == nil {
 		return folderID, nil
 	}
 
 	fs.Debugf(f, "getFolderID: Resolving folder ID for directory=%q", dir)
 
 	// Fallback: Resolve folder ID based on folder name/path
-	parts := strings.Split(dir, "/")
-	currentID := 0 // Start from the root directory
+	parts := strings.Split(path.Join(f.Root(), dir), "/")
+	currentID := int64(0) // Start from the root directory
 
 	for _, part := range parts {
 		if part == "" {
@@ -948,54 +2819,16 @@ func (f *Fs) getFolderID(ctx context.Context, dir string) (int, error) {
 		}
 
 		// Fetch folders in the current directory
-		apiURL := fmt.Sprintf("%s/folder/list?fld_id=%d&key=%s", f.endpoint, currentID, url.QueryEscape(f.opt.RcloneKey))
-		req, err := http.NewRequestWithContext(ctx, "GET", apiURL, nil)
+		folders, err := f.listFolders(ctx, strconv.FormatInt(currentID, 10))
 		if err != nil {
-			return 0, fmt.Errorf("failed to create request: %w", err)
-		}
-
-		resp, err := f.client.Do(req)
-		if err != nil {
-			return 0, fmt.Errorf("failed to list directory: %w", err)
-		}
-		defer func() {
-			if err := resp.Body.Close(); err != nil {
-				fs.Fatalf(nil, "Failed to close response body: %v", err)
-			}
-		}()
-
-		var result struct {
-			Status int    `json:"status"`
-			Msg    string `json:"msg"`
-			Result struct {
-				Folders []struct {
-					Name  string `json:"name"`
-					FldID int    `json:"fld_id"`
-				} `json:"folders"`
-			} `json:"result"`
-		}
-
-		err = json.NewDecoder(resp.Body).Decode(&result)
-		if err != nil {
-			return 0, fmt.Errorf("error decoding response: %w", err)
-		}
-
-		if result.Status != 200 {
-			return 0, fmt.Errorf("error: %s", result.Msg)
-		}
-
-		found := false
-		for _, folder := range result.Result.Folders {
-			if folder.Name == part {
-				currentID = folder.FldID
-				found = true
-				break
-			}
+			return 0, err
 		}
 
-		if !found {
+		i := matchFolderName(folders, part)
+		if i < 0 {
 			return 0, fs.ErrorDirNotFound
 		}
+		currentID = folders[i].FldID
 	}
 
 	fs.Debugf(f, "getFolderID: Resolved folder ID=%d for directory=%q", currentID, dir)
@@ -1004,145 +2837,149 @@ func (f *Fs) getFolderID(ctx context.Context, dir string) (int, error) {
 
 func (f *Fs) getDirectLink(ctx context.Context, filePath string) (string, int64, error) {
 	// Ensure filePath starts with a forward slash
-	filePath = "/" + strings.Trim(filePath, "/")
-
-	// Construct the API URL with file_path parameter
-	apiURL := fmt.Sprintf("%s/file/direct_link?file_path=%s&key=%s",
-		f.endpoint,
-		url.QueryEscape(filePath),
-		url.QueryEscape(f.opt.RcloneKey),
-	)
-
+	filePath = f.apiPath(filePath)
 	fs.Debugf(f, "getDirectLink: fetching direct link for file path %q", filePath)
+	urls, size, err := f.directLink(ctx, url.Values{"file_path": {filePath}})
+	if err != nil {
+		return "", 0, err
+	}
+	return urls[0], size, nil
+}
 
-	req, err := http.NewRequestWithContext(ctx, "GET", apiURL, nil)
+// getDirectLinkByCode fetches the download URL and size of the file
+// with fileCode
+func (f *Fs) getDirectLinkByCode(ctx context.Context, fileCode string) (string, int64, error) {
+	fs.Debugf(f, "getDirectLink: fetching direct link for file code %q", fileCode)
+	urls, size, err := f.directLink(ctx, url.Values{"file_code": {fileCode}})
 	if err != nil {
-		return "", 0, fmt.Errorf("failed to create request: %w", err)
+		return "", 0, err
 	}
+	return urls[0], size, nil
+}
 
-	resp, err := f.client.Do(req)
+// cachedDirectLink returns the download URLs of the file with fileCode,
+// or at filePath if fileCode is empty, reusing ones fetched recently.
+//
+// Opening a file many times, for example when seeking in a mount, then
+// only needs one file/direct_link call.
+func (f *Fs) cachedDirectLink(ctx context.Context, fileCode, filePath string) ([]string, int64, error) {
+	key := directLinkKey(fileCode, filePath)
+	f.linkCacheMu.Lock()
+	info, ok := f.linkCache[key]
+	f.linkCacheMu.Unlock()
+	if ok && time.Now().Before(info.expires) {
+		return info.urls, info.size, nil
+	}
+
+	params := url.Values{"file_code": {fileCode}}
+	if fileCode == "" {
+		params = url.Values{"file_path": {f.apiPath(filePath)}}
+	}
+	var err error
+	info.urls, info.size, err = f.directLink(ctx, params)
 	if err != nil {
-		return "", 0, fmt.Errorf("failed to fetch direct link: %w", err)
+		return nil, 0, err
 	}
-	defer func() {
-		if err := resp.Body.Close(); err != nil {
-			fs.Fatalf(nil, "Failed to close response body: %v", err)
-		}
-	}()
+	info.expires = time.Now().Add(directLinkTTL)
+	f.linkCacheMu.Lock()
+	if f.linkCache == nil {
+		f.linkCache = make(map[string]directLinkInfo)
+	}
+	f.linkCache[key] = info
+	f.linkCacheMu.Unlock()
+	return info.urls, info.size, nil
+}
 
-	var result struct {
-		Status int    `json:"status"`
-		Msg    string `json:"msg"`
-		Result struct {
-			URL  string `json:"url"`
-			Size int64  `json:"size"`
-		} `json:"result"`
+// forgetDirectLink removes the direct link of the file with fileCode, or
+// at filePath if fileCode is empty, from the link cache
+func (f *Fs) forgetDirectLink(fileCode, filePath string) {
+	f.linkCacheMu.Lock()
+	delete(f.linkCache, directLinkKey(fileCode, filePath))
+	f.linkCacheMu.Unlock()
+}
+
+// directLinkKey returns the link cache key for a file code or path
+func directLinkKey(fileCode, filePath string) string {
+	if fileCode != "" {
+		return "code:" + fileCode
 	}
+	return "path:" + "/" + strings.Trim(filePath, "/")
+}
 
-	err = json.NewDecoder(resp.Body).Decode(&result)
+// directLink asks file/direct_link for the download URLs and size of the
+// file selected by params.
+//
+// FileLu's own choice of URL comes first, followed by any mirrors.
+func (f *Fs) directLink(ctx context.Context, params url.Values) ([]string, int64, error) {
+	var result api.DirectLinkResponse
+	err := f.callAPI(ctx, "/file/direct_link", params, &result)
 	if err != nil {
-		return "", 0, fmt.Errorf("error decoding response: %w", err)
+		return nil, 0, fmt.Errorf("failed to fetch direct link: %w", err)
 	}
 
 	if result.Status != 200 {
-		return "", 0, fmt.Errorf("error: %s", result.Msg)
+		return nil, 0, fmt.Errorf("error: %s", result.Msg)
 	}
 
-	fs.Debugf(f, "getDirectLink: obtained URL %q with size %d", result.Result.URL, result.Result.Size)
-	return result.Result.URL, result.Result.Size, nil
+	urls := mirrorURLs(result.Result.URL, result.Result.URLs)
+	if len(urls) == 0 {
+		return nil, 0, errors.New("no download URL returned")
+	}
+	fs.Debugf(f, "getDirectLink: obtained URLs %q with size %d", urls, result.Result.Size)
+	return urls, result.Result.Size, nil
 }
 
 // NewObject creates a new Object for the given remote path
 func (f *Fs) NewObject(ctx context.Context, remote string) (fs.Object, error) {
 	fs.Debugf(f, "NewObject: called with remote=%q", remote)
 
-	// Determine the proper remote path
-	var filePath string
-	if f.isFile {
-		// If we're in single file mode, use the target file path
-		filePath = path.Join(f.root, f.targetFile)
-	} else {
-		// Otherwise use the provided remote path
-		filePath = path.Join(f.root, remote)
-	}
-	filePath = "/" + strings.Trim(filePath, "/")
-
-	fs.Debugf(f, "NewObject: Using file path %q", filePath)
-
-	// Use the FileLu API to fetch file info
-	apiURL := fmt.Sprintf("%s/file/info?file_path=%s&key=%s",
-		f.endpoint,
-		url.QueryEscape(filePath),
-		url.QueryEscape(f.opt.RcloneKey),
-	)
-
-	fs.Debugf(f, "NewObject: Fetching file info from %s", apiURL)
-
-	req, err := http.NewRequestWithContext(ctx, "GET", apiURL, nil)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
+	// Nothing in a filedrop can be seen, so uploads always create new files
+	if f.opt.RootIsDrop {
+		return nil, fs.ErrorObjectNotFound
 	}
 
-	resp, err := f.client.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("failed to fetch file info: %w", err)
-	}
-	defer func() {
-		if err := resp.Body.Close(); err != nil {
-			fs.Fatalf(nil, "Failed to close response body: %v", err)
+	targetFile, isFile := f.rootFile()
+	if !isFile {
+		if o, ok := f.cachedObject(remote); ok {
+			return o, nil
 		}
-	}()
-
-	// Read and log the response body for debugging
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, fmt.Errorf("error reading response body: %w", err)
 	}
-	fs.Debugf(f, "NewObject: Response body: %s", string(body))
 
-	// Parse response
-	var result struct {
-		Status int    `json:"status"`
-		Msg    string `json:"msg"`
-		Result []struct {
-			Size     string `json:"size"` // API returns size as string
-			Name     string `json:"name"`
-			FileCode string `json:"filecode"`
-			Hash     string `json:"hash"`
-			Status   int    `json:"status"`
-		} `json:"result"`
+	// Use the correct remote path for the object
+	if isFile {
+		remote = targetFile
 	}
 
-	err = json.NewDecoder(bytes.NewReader(body)).Decode(&result)
-	if err != nil {
-		return nil, fmt.Errorf("error decoding response: %w", err)
+	// Find the file by listing its parent so the object knows its file
+	// code, which Open, Remove and Hash all rely on
+	dir := path.Dir(remote)
+	if dir == "." {
+		dir = ""
 	}
-
-	if result.Status != 200 || len(result.Result) == 0 {
+	entries, err := f.listDirectory(ctx, dir)
+	if errors.Is(err, fs.ErrorDirNotFound) && dir == "" && f.rootFileCode() != "" {
+		// The root is the code of a single file
+		o, err := f.fileCodeObject(ctx, f.rootFileCode())
+		if err != nil {
+			return nil, err
+		}
+		if o.remote != remote {
+			return nil, fs.ErrorObjectNotFound
+		}
+		return o, nil
+	}
+	if errors.Is(err, fs.ErrorDirNotFound) {
 		return nil, fs.ErrorObjectNotFound
 	}
-
-	// Get the first matching file
-	fileInfo := result.Result[0]
-	size, err := strconv.ParseInt(fileInfo.Size, 10, 64)
 	if err != nil {
-		fs.Debugf(f, "Error parsing file size %q: %v", fileInfo.Size, err)
-		size = 0 // Set default size to 0 if parsing fails
+		return nil, err
 	}
-	fs.Debugf(f, "File %q size parsed: %d from string: %q", filePath, size, fileInfo.Size)
-
-	// Use the correct remote path for the object
-	returnedRemote := remote
-	if f.isFile {
-		returnedRemote = f.targetFile
+	for _, entry := range entries {
+		if o, ok := entry.(*Object); ok && o.remote == remote {
+			return o, nil
+		}
 	}
-
-	return &Object{
-		fs:      f,
-		remote:  returnedRemote,
-		size:    size,
-		modTime: time.Now(), // Consider parsing upload time if available in API response
-	}, nil
+	return nil, fs.ErrorObjectNotFound
 }
 
 // Helper function to handle duplicate files
@@ -1168,80 +3005,114 @@ func (f *Fs) handleDuplicate(ctx context.Context, remote string) error {
 			if err != nil {
 				return fmt.Errorf("failed to remove existing file: %w", err)
 			}
-			break
-		}
-	}
-	return nil
-}
-
-// getUploadServer gets the upload server URL with proper key authentication
-func (f *Fs) getUploadServer(ctx context.Context) (string, string, error) {
-	// Step 1: Get upload server
-	apiURL := fmt.Sprintf("%s/upload/server?key=%s", f.endpoint, url.QueryEscape(f.opt.RcloneKey))
-
-	req, err := http.NewRequestWithContext(ctx, "GET", apiURL, nil)
-	if err != nil {
-		return "", "", fmt.Errorf("failed to create request: %w", err)
-	}
-
-	resp, err := f.client.Do(req)
-	if err != nil {
-		return "", "", fmt.Errorf("failed to get upload server: %w", err)
-	}
-	defer func() {
-		if err := resp.Body.Close(); err != nil {
-			fs.Fatalf(nil, "Failed to close response body: %v", err)
+			break
 		}
-	}()
-
-	var result struct {
-		Status int    `json:"status"`
-		SessID string `json:"sess_id"`
-		Result string `json:"result"`
-		Msg    string `json:"msg"`
 	}
+	return nil
+}
 
-	err = json.NewDecoder(resp.Body).Decode(&result)
+// getUploadServer gets the upload server URL with proper key authentication
+func (f *Fs) getUploadServer(ctx context.Context) (string, string, error) {
+	var result struct {
+		Status    int             `json:"status"`
+		SessID    string          `json:"sess_id"`
+		Result    json.RawMessage `json:"result"`
+		URL       string          `json:"url"`
+		UploadURL string          `json:"upload_url"`
+		Msg       string          `json:"msg"`
+	}
+	err := f.pacer.Call(func() (bool, error) {
+		err := f.callAPI(ctx, "/upload/server", nil, &result)
+		return shouldRetry(ctx, err)
+	})
 	if err != nil {
-		return "", "", fmt.Errorf("error decoding response: %w", err)
+		return "", "", fmt.Errorf("failed to get upload server: %w", err)
 	}
 
 	if result.Status != 200 {
 		return "", "", fmt.Errorf("error: %s", result.Msg)
 	}
 
-	fs.Debugf(f, "Got upload server URL=%s and session ID=%s", result.Result, result.SessID)
-	return result.Result, result.SessID, nil
+	// The URL is normally the result but may be under another field
+	uploadURL := uploadServerURL(result.Result)
+	for _, other := range []string{result.UploadURL, result.URL} {
+		if uploadURL == "" {
+			uploadURL = other
+		}
+	}
+	if err := checkUploadURL(uploadURL); err != nil {
+		return "", "", err
+	}
+
+	fs.Debugf(f, "Got upload server URL=%s and session ID=%s", uploadURL, result.SessID)
+	return uploadURL, result.SessID, nil
+}
+
+// getUploadSession returns an upload server URL and session ID which no
+// other upload is using.
+//
+// A session an earlier upload has finished with is reused if there is
+// one, otherwise a new one is read from upload/server. Pass it to
+// releaseUploadSession after a successful upload so it can be reused.
+func (f *Fs) getUploadSession(ctx context.Context) (string, string, error) {
+	f.sessionMu.Lock()
+	for len(f.sessions) > 0 {
+		last := len(f.sessions) - 1
+		session := f.sessions[last]
+		f.sessions = f.sessions[:last]
+		if time.Now().Before(session.expires) {
+			f.sessionMu.Unlock()
+			fs.Debugf(f, "Reusing upload server URL=%s and session ID=%s", session.url, session.id)
+			return session.url, session.id, nil
+		}
+	}
+	f.sessionMu.Unlock()
+	return f.getUploadServer(ctx)
+}
+
+// releaseUploadSession makes the upload session from getUploadSession
+// available to the next upload.
+//
+// Only call it once an upload with the session has worked, so a session
+// which has gone bad is dropped.
+func (f *Fs) releaseUploadSession(uploadURL, sessID string) {
+	f.sessionMu.Lock()
+	f.sessions = append(f.sessions, uploadSession{url: uploadURL, id: sessID, expires: time.Now().Add(sessionTTL)})
+	f.sessionMu.Unlock()
 }
 
 // Put uploads a file to the storage backend.
 func (f *Fs) Put(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) (fs.Object, error) {
 	fs.Debugf(f, "Put: Starting upload for %q", src.Remote())
+	f.forgetObject(src.Remote())
+	defer f.forgetParentListing(src.Remote())
 
 	// Create temporary file and get its path
-	tempPath, err := createTempFileFromReader(in)
+	tempPath, localSum, err := createTempFileFromReader(f.opt.TempDir, src.Remote(), in, f.hashType)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create temp file: %w", err)
 	}
-	// Error handling for os.Remove
+	fs.Debugf(f, "Put: staging %q in %q", src.Remote(), tempPath)
 	defer func() {
 		if err := os.Remove(tempPath); err != nil {
 			fs.Logf(nil, "Failed to remove temporary file %q: %v", tempPath, err)
 		}
 	}()
-	// Open the temporary file for reading
-	tempFile, err := os.Open(tempPath)
+
+	// Look for a file already using the name
+	existing, err := f.existingObject(ctx, src.Remote())
 	if err != nil {
-		return nil, fmt.Errorf("failed to open temp file: %w", err)
+		return nil, err
 	}
-	// Checking error for tempFile.Close
-	defer func() {
-		if err := tempFile.Close(); err != nil {
-			fs.Logf(nil, "Failed to close temporary file: %v", err)
-		}
-	}()
+
+	// Don't upload content which is already there
+	if existing != nil && f.isIdentical(ctx, existing, localSum) {
+		fs.Debugf(existing, "Put: skipping upload as content is identical")
+		return existing, nil
+	}
+
 	// Get upload server details
-	uploadURL, sessID, err := f.getUploadServer(ctx)
+	uploadURL, sessID, err := f.getUploadSession(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("failed to retrieve upload server: %w", err)
 	}
@@ -1250,215 +3121,692 @@ func (f *Fs) Put(ctx context.Context, in io.Reader, src fs.ObjectInfo, options .
 	fileName := path.Base(src.Remote())
 	fs.Debugf(f, "Put: Using filename %q for upload", fileName)
 
+	if f.opt.RootIsDrop {
+		obj, err := f.putFiledrop(ctx, uploadURL, sessID, fileName, tempPath, src)
+		if err == nil {
+			f.releaseUploadSession(uploadURL, sessID)
+		}
+		return obj, err
+	}
+
+	// Decide what to do with the file already using the name
+	remote, existing, err := f.overwriteTarget(ctx, src.Remote(), existing)
+	if err != nil {
+		return nil, err
+	}
+
 	// Upload the file to root first
-	fileCode, err := f.uploadFile(ctx, uploadURL, sessID, fileName, tempFile)
+	fileCode, err := f.uploadVerified(ctx, uploadURL, sessID, remote, tempPath, localSum)
 	if err != nil {
-		return nil, fmt.Errorf("failed to upload file: %w", err)
+		return nil, err
 	}
+	f.releaseUploadSession(uploadURL, sessID)
 	fs.Debugf(f, "Put: File uploaded successfully with code: %s", fileCode)
 
-	// If we have a destination path, move the file there
-	if f.root != "" {
-		sourcePath := "/" + fileName
-		destinationPath := "/" + strings.Trim(f.root, "/")
+	// Move the file into its directory
+	err = f.placeUpload(ctx, fileCode, remote)
+	if err != nil {
+		return nil, f.discardUpload(ctx, fileCode, err)
+	}
 
-		fs.Debugf(f, "Put: Moving file from %q to folder %q", sourcePath, destinationPath)
-		err = f.moveFileToFolder(ctx, sourcePath, destinationPath)
-		if err != nil {
-			return nil, fmt.Errorf("failed to move file to destination folder: %w", err)
+	// Now the new content is in place remove the file it replaces
+	if existing != nil && existing.fileCode != fileCode {
+		if err := existing.Remove(ctx); err != nil {
+			return nil, fmt.Errorf("failed to remove replaced file: %w", err)
 		}
+		f.forgetDirectLink(existing.fileCode, "")
 	}
 
 	// Create and return the object
 	return &Object{
-		fs:      f,
-		remote:  src.Remote(),
-		size:    src.Size(),
-		modTime: src.ModTime(ctx),
+		fs:       f,
+		remote:   remote,
+		size:     src.Size(),
+		hasSize:  true,
+		modTime:  src.ModTime(ctx),
+		fileCode: fileCode,
 	}, nil
 }
 
-// createTempFileFromReader writes the content of the 'in' reader into a temporary file
-func createTempFileFromReader(in io.Reader) (string, error) {
-	// Create a temporary file
-	tempFile, err := os.CreateTemp("", "upload-*.tmp")
+// existingObject returns the file at remote or nil if there isn't one
+func (f *Fs) existingObject(ctx context.Context, remote string) (*Object, error) {
+	obj, err := f.NewObject(ctx, remote)
+	if errors.Is(err, fs.ErrorObjectNotFound) {
+		return nil, nil
+	}
 	if err != nil {
-		return "", fmt.Errorf("failed to create temp file: %w", err)
+		return nil, fmt.Errorf("failed to look for existing file: %w", err)
 	}
+	return obj.(*Object), nil
+}
 
-	// Defer the closing of the temp file to ensure it gets closed after copying
-	defer func() {
-		err = tempFile.Close()
+// overwriteTarget applies the overwrite option to existing, the file
+// already at remote if any.
+//
+// It returns the remote to upload to, which is a free name if renaming,
+// and the file to remove once the upload is in place if replacing.
+func (f *Fs) overwriteTarget(ctx context.Context, remote string, existing *Object) (string, *Object, error) {
+	if existing == nil {
+		return remote, nil, nil
+	}
+	switch f.opt.Overwrite {
+	case overwriteError:
+		return "", nil, errExists(remote)
+	case overwriteRename:
+		remote, err := f.freeRemote(ctx, remote)
+		return remote, nil, err
+	}
+	return remote, existing, nil
+}
+
+// errExists is returned for a file at remote which the overwrite option
+// says to keep
+func errExists(remote string) error {
+	return fserrors.NoRetryError(fmt.Errorf("not uploading %q as it exists and overwrite is %q", remote, overwriteError))
+}
+
+// freeRemote returns remote with " (n)" added before its extension, using
+// the lowest n which doesn't name anything in its directory
+func (f *Fs) freeRemote(ctx context.Context, remote string) (string, error) {
+	dir := path.Dir(remote)
+	if dir == "." {
+		dir = ""
+	}
+	entries, err := f.List(ctx, dir)
+	if err != nil {
+		return "", fmt.Errorf("failed to list %q for a free name: %w", dir, err)
+	}
+	taken := make(map[string]bool, len(entries))
+	for _, entry := range entries {
+		taken[entry.Remote()] = true
+	}
+	ext := path.Ext(remote)
+	base := strings.TrimSuffix(remote, ext)
+	for n := 1; ; n++ {
+		candidate := fmt.Sprintf("%s (%d)%s", base, n, ext)
+		if !taken[candidate] {
+			return candidate, nil
+		}
+	}
+}
+
+// uploadVerified uploads the file at tempPath, whose hash is localSum,
+// to the account root for remote and returns its file code.
+//
+// If upload_retries is set the hash of the uploaded file is checked and
+// a corrupted upload is deleted and tried again.
+func (f *Fs) uploadVerified(ctx context.Context, uploadURL, sessID, remote string, tempPath string, localSum string) (string, error) {
+	verify := f.opt.UploadRetries > 0 && !f.opt.DisableChecksum && localSum != ""
+	for try := 0; ; try++ {
+		fileCode, err := f.uploadFile(ctx, uploadURL, sessID, remote, tempPath)
 		if err != nil {
-			fs.Logf(nil, "Failed to close temporary file: %v", err)
+			return "", fmt.Errorf("failed to upload file: %w", err)
 		}
-	}()
+		if !verify {
+			return fileCode, nil
+		}
+		remoteSum, err := (&Object{fs: f, fileCode: fileCode}).Hash(ctx, f.hashType)
+		if err != nil {
+			return "", fmt.Errorf("failed to read hash of upload: %w", err)
+		}
+		if strings.EqualFold(remoteSum, localSum) {
+			return fileCode, nil
+		}
+		err = fmt.Errorf("corrupted on upload: %s hash differ (%s vs %s)", f.hashType, localSum, remoteSum)
+		if deleteErr := f.deleteFileByCode(ctx, fileCode); deleteErr != nil {
+			return "", fmt.Errorf("failed to delete bad upload: %v: %w", deleteErr, err)
+		}
+		if try >= f.opt.UploadRetries {
+			return "", fmt.Errorf("failed to upload file after %d tries: %w", try+1, err)
+		}
+		fs.Logf(f, "Put: %q %v - uploading again (%d/%d)", remote, err, try+1, f.opt.UploadRetries)
+	}
+}
 
-	// Copy the data to the temp file
-	_, err = io.Copy(tempFile, in)
-	if err != nil {
-		// Attempt to remove the file if copy operation fails
-		defer func() {
-			if err := os.Remove(tempFile.Name()); err != nil {
-				fs.Logf(nil, "Failed to remove temp file %q: %v", tempFile.Name(), err)
-			}
-		}()
+// isIdentical returns true if the server hash of o matches localSum,
+// the hash of the whole upload, or false otherwise or if checksums are
+// disabled.
+//
+// Put and Update both use it to skip uploading content which is already
+// in the file it would replace, keeping that file whatever the overwrite
+// option says.
+func (f *Fs) isIdentical(ctx context.Context, o *Object, localSum string) bool {
+	if f.opt.DisableChecksum || localSum == "" {
+		return false
+	}
+	remoteSum, err := o.Hash(ctx, f.hashType)
+	if err != nil || remoteSum == "" {
+		fs.Debugf(o, "Can't read server hash to compare with upload: %v", err)
+		return false
+	}
+	return strings.EqualFold(remoteSum, localSum)
+}
 
-		return "", fmt.Errorf("failed to copy data to temp file: %w", err)
+// placeUpload moves the file with fileCode, which has just been uploaded
+// to the account root, into the directory of remote, creating it if
+// needed. A file for the account root goes in the default_folder_id
+// folder if set.
+//
+// The file is moved by its code, not its name, so another file of the
+// same name in the account root is left alone.
+func (f *Fs) placeUpload(ctx context.Context, fileCode string, remote string) error {
+	dir := path.Dir(path.Join(f.Root(), remote))
+	if dir == "." || dir == "/" {
+		if f.opt.DefaultFolderID == 0 {
+			return nil
+		}
+		var err error
+		dir, err = f.defaultFolderPath(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to find default folder: %w", err)
+		}
+	} else if _, err := f.dirCache.FindDir(ctx, dir, true); err != nil {
+		return fmt.Errorf("failed to find destination folder: %w", err)
+	}
+	defer f.forgetListing(dir)
+	fs.Debugf(f, "Moving uploaded file %q to folder %q", fileCode, dir)
+	err := f.pacer.Call(func() (bool, error) {
+		err := f.setFileFolder(ctx, fileCode, dir)
+		return shouldRetry(ctx, err)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to move file to destination folder: %w", err)
 	}
+	return nil
+}
 
-	return tempFile.Name(), nil
+// discardUpload deletes the file with fileCode, which was uploaded or
+// cloned to the account root but couldn't be moved into place, so it
+// isn't left behind. It returns err, logging rather than returning any failure to
+// delete so the original error isn't hidden.
+func (f *Fs) discardUpload(ctx context.Context, fileCode string, err error) error {
+	if deleteErr := f.deleteFileByCode(ctx, fileCode); deleteErr != nil {
+		fs.Errorf(f, "Failed to remove %q which couldn't be put in place: %v", fileCode, deleteErr)
+	}
+	return err
 }
 
-// moveFileToFolder moves a file to a different folder using file paths
-func (f *Fs) moveFileToFolder(ctx context.Context, filePath string, destinationPath string) error {
-	// Ensure paths start with forward slashes
-	filePath = "/" + strings.Trim(filePath, "/")
-	destinationPath = "/" + strings.Trim(destinationPath, "/")
-
-	apiURL := fmt.Sprintf("%s/file/set_folder?file_path=%s&destination_folder_path=%s&key=%s",
-		f.endpoint,
-		url.QueryEscape(filePath),
-		url.QueryEscape(destinationPath),
-		url.QueryEscape(f.opt.RcloneKey),
-	)
+// putFiledrop uploads the file at tempPath into the filedrop the root
+// points to
+func (f *Fs) putFiledrop(ctx context.Context, uploadURL, sessionID, fileName string, tempPath string, src fs.ObjectInfo) (fs.Object, error) {
+	if strings.Contains(src.Remote(), "/") {
+		return nil, fmt.Errorf("can't upload %q: a filedrop has no subdirectories: %w", src.Remote(), errFiledrop)
+	}
+	fields := f.uploadFields(ctx)
+	fields.Set("sess_id", sessionID)
+	fields.Set("fld_code", f.Root())
+	fileCode, err := f.uploadMultipart(ctx, uploadURL, fields, fileName, tempPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to upload file to filedrop: %w", err)
+	}
+	fs.Debugf(f, "Put: File uploaded to filedrop %q with code: %s", f.Root(), fileCode)
+	return &Object{
+		fs:       f,
+		remote:   src.Remote(),
+		size:     src.Size(),
+		hasSize:  true,
+		modTime:  src.ModTime(ctx),
+		fileCode: fileCode,
+	}, nil
+}
 
-	fs.Debugf(f, "moveFileToFolder: Sending move request to %s", apiURL)
+// createTempFileFromReader writes the content of the 'in' reader into a
+// temporary file in dir, or the system temporary directory if it is
+// empty, and returns its path and its hash of type t. The file is named
+// after remote, the file it is staging the upload of.
+//
+// The content is hashed as it is written so the file needn't be read
+// again to check the upload. The hash is empty if t is hash.None.
+//
+// The file is fully written and closed before the path is returned so it
+// can be reopened by name straight away (by uploadFile or localMD5) on
+// every platform. The caller is responsible for removing the file.
+func createTempFileFromReader(dir string, remote string, in io.Reader, t hash.Type) (string, string, error) {
+	hasher, err := hash.NewMultiHasherTypes(hash.NewHashSet(t))
+	if err != nil {
+		return "", "", err
+	}
+	tempFile, err := createTempFile(dir, tempFilePattern("upload", remote))
+	if err != nil {
+		return "", "", err
+	}
+	tempPath := tempFile.Name()
 
-	req, err := http.NewRequestWithContext(ctx, "GET", apiURL, nil)
+	_, err = io.Copy(io.MultiWriter(tempFile, hasher), in)
+	closeErr := tempFile.Close()
+	if err == nil && closeErr != nil {
+		err = fmt.Errorf("failed to close temp file: %w", closeErr)
+	} else if err != nil {
+		err = fmt.Errorf("failed to copy data to temp file: %w", err)
+	}
 	if err != nil {
-		return fmt.Errorf("failed to create move request: %w", err)
+		// The handle is closed at this point so the file can be removed
+		if removeErr := os.Remove(tempPath); removeErr != nil {
+			fs.Logf(nil, "Failed to remove temp file %q: %v", tempPath, removeErr)
+		}
+		return "", "", err
 	}
 
-	resp, err := f.client.Do(req)
+	return tempPath, hasher.Sums()[t], nil
+}
+
+// createTempFile makes a new temporary file named by pattern in dir, or
+// the system temporary directory if it is empty, which only the user can
+// read and write
+func createTempFile(dir, pattern string) (*os.File, error) {
+	file, err := os.CreateTemp(dir, pattern)
 	if err != nil {
-		return fmt.Errorf("failed to send move request: %w", err)
+		return nil, fmt.Errorf("failed to create temp file: %w", err)
 	}
-	defer func() {
-		if err := resp.Body.Close(); err != nil {
-			fs.Fatalf(nil, "Failed to close response body: %v", err)
+	if err := file.Chmod(0600); err != nil {
+		_ = file.Close()
+		if removeErr := os.Remove(file.Name()); removeErr != nil {
+			fs.Logf(nil, "Failed to remove temp file %q: %v", file.Name(), removeErr)
 		}
-	}()
+		return nil, fmt.Errorf("failed to set permissions of temp file: %w", err)
+	}
+	return file, nil
+}
 
-	var result struct {
-		Status int    `json:"status"`
-		Msg    string `json:"msg"`
+// moveFileToFolder moves a file to a different folder using file paths
+func (f *Fs) moveFileToFolder(ctx context.Context, filePath string, destinationPath string) error {
+	filePath = f.apiPath(filePath)
+	destinationPath = f.apiPath(destinationPath)
+	fs.Debugf(f, "moveFileToFolder: moving %q to %q", filePath, destinationPath)
+
+	if err := f.setFileFolderByPath(ctx, filePath, destinationPath); err != nil {
+		return err
 	}
 
-	err = json.NewDecoder(resp.Body).Decode(&result)
+	fs.Debugf(f, "moveFileToFolder: Successfully moved file %q to folder %q", filePath, destinationPath)
+	return nil
+}
+
+// setFileFolderByPath moves the file at filePath into the folder at
+// folderPath, both from the account root
+func (f *Fs) setFileFolderByPath(ctx context.Context, filePath string, folderPath string) error {
+	var result api.DeleteResponse
+	err := f.pacer.Call(func() (bool, error) {
+		err := f.callAPI(ctx, "/file/set_folder", url.Values{
+			"file_path":               {filePath},
+			"destination_folder_path": {folderPath},
+		}, &result)
+		return shouldRetry(ctx, err)
+	})
 	if err != nil {
-		return fmt.Errorf("error decoding move response: %w", err)
+		return fmt.Errorf("failed to move file: %w", err)
 	}
-
 	if result.Status != 200 {
 		return fmt.Errorf("error while moving file: %s", result.Msg)
 	}
-
-	fs.Debugf(f, "moveFileToFolder: Successfully moved file %q to folder %q", filePath, destinationPath)
 	return nil
 }
 
-// getFileHash fetches the hash of the uploaded file using its file_code
+// Copy src to this remote using server-side copy operations.
 //
-//nolint:unused
-func (f *Fs) getFileHash(ctx context.Context, fileCode string) (string, error) {
-	apiURL := fmt.Sprintf("%s/file/info?file_code=%s&key=%s", f.endpoint, url.QueryEscape(fileCode), url.QueryEscape(f.opt.RcloneKey))
+// This is stored with the remote path given.
+//
+// It returns the destination Object and a possible error.
+//
+// The source may be in another FileLu account, in which case the file is
+// cloned into this account if FileLu allows it.
+//
+// If it isn't possible then return fs.ErrorCantCopy
+func (f *Fs) Copy(ctx context.Context, src fs.Object, remote string) (fs.Object, error) {
+	srcObj, ok := src.(*Object)
+	if !ok || f.opt.RootIsDrop || srcObj.fs.opt.RootIsDrop {
+		fs.Debugf(src, "Can't copy - not same remote type")
+		return nil, fs.ErrorCantCopy
+	}
+	defer f.forgetParentListing(remote)
+	srcCode := srcObj.openFileCode()
+	if srcCode == "" {
+		var err error
+		srcCode, err = srcObj.fs.findFileCode(ctx, srcObj.remote)
+		if err != nil {
+			return nil, fmt.Errorf("copy: %w", err)
+		}
+	}
 
-	fmt.Printf("DEBUG: Making API call to get file hash for fileCode: %s\n", fileCode)
-	req, err := http.NewRequestWithContext(ctx, "GET", apiURL, nil)
+	// FileLu allows duplicate names so deal with a file already at remote
+	existing, err := f.existingObject(ctx, remote)
 	if err != nil {
-		return "", fmt.Errorf("failed to create request: %w", err)
+		return nil, fmt.Errorf("copy: %w", err)
+	}
+	if existing != nil && existing.fileCode == srcCode {
+		fs.Debugf(src, "Copy: source and destination are the same file")
+		return existing, nil
+	}
+	remote, existing, err = f.overwriteTarget(ctx, remote, existing)
+	if err != nil {
+		return nil, err
 	}
 
-	resp, err := f.client.Do(req)
+	var fileCode string
+	err = f.pacer.Call(func() (bool, error) {
+		var err error
+		fileCode, err = f.cloneFile(ctx, srcCode)
+		return shouldRetry(ctx, err)
+	})
+	if err != nil && !srcObj.fs.sameAccount(f) {
+		// Only files shared by the other account can be cloned
+		fs.Debugf(src, "Can't copy from another account - %v", err)
+		return nil, fs.ErrorCantCopy
+	}
 	if err != nil {
-		return "", fserrors.FsError(err)
+		return nil, fmt.Errorf("copy: %w", err)
 	}
-	defer func() {
-		if err := resp.Body.Close(); err != nil {
-			fs.Fatalf(nil, "Failed to close response body: %v", err)
+
+	// The clone is made in the account root, so put it in place
+	dstPath := path.Join(f.Root(), remote)
+	dstDir, dstLeaf := path.Dir(dstPath), path.Base(dstPath)
+	if dstDir != "." && dstDir != "/" {
+		if _, err := f.dirCache.FindDir(ctx, dstDir, true); err != nil {
+			return nil, f.discardUpload(ctx, fileCode, fmt.Errorf("copy: failed to find destination folder: %w", err))
 		}
-	}()
+		err := f.pacer.Call(func() (bool, error) {
+			err := f.setFileFolder(ctx, fileCode, "/"+dstDir)
+			return shouldRetry(ctx, err)
+		})
+		if err != nil {
+			return nil, f.discardUpload(ctx, fileCode, fmt.Errorf("copy: %w", err))
+		}
+	}
+	if dstLeaf != path.Base(srcObj.remote) {
+		err := f.pacer.Call(func() (bool, error) {
+			err := f.renameFileByCode(ctx, fileCode, dstLeaf)
+			return shouldRetry(ctx, err)
+		})
+		if err != nil {
+			return nil, f.discardUpload(ctx, fileCode, fmt.Errorf("copy: %w", err))
+		}
+	}
 
-	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("received HTTP status %d", resp.StatusCode)
+	// Now the copy is in place remove the file it replaces
+	if existing != nil {
+		if err := existing.Remove(ctx); err != nil {
+			return nil, fmt.Errorf("copy: failed to remove replaced file: %w", err)
+		}
+		f.forgetDirectLink(existing.fileCode, "")
 	}
 
-	var result struct {
-		Status int    `json:"status"`
-		Msg    string `json:"msg"`
-		Result []struct {
-			Hash string `json:"hash"` // Assuming hash exists
-		} `json:"result"`
+	return &Object{
+		fs:       f,
+		remote:   remote,
+		size:     srcObj.size,
+		hasSize:  srcObj.hasSize,
+		modTime:  srcObj.modTime,
+		hash:     srcObj.hash,
+		fileCode: fileCode,
+	}, nil
+}
+
+// sameAccount returns whether f and other use the same FileLu account
+func (f *Fs) sameAccount(other *Fs) bool {
+	return f.opt.RcloneKey == other.opt.RcloneKey
+}
+
+// cloneFile makes a copy of the file with fileCode in the account root
+// and returns the file code of the copy
+func (f *Fs) cloneFile(ctx context.Context, fileCode string) (string, error) {
+	var result api.CloneResponse
+	err := f.callAPI(ctx, "/file/clone", url.Values{"file_code": {fileCode}}, &result)
+	if err != nil {
+		return "", fmt.Errorf("failed to clone file: %w", err)
+	}
+	if result.Status != 200 || result.Result.FileCode == "" {
+		return "", fmt.Errorf("error while cloning file: %s", result.Msg)
 	}
+	return result.Result.FileCode, nil
+}
 
-	err = json.NewDecoder(resp.Body).Decode(&result)
+// importLinkResult is returned by the importlink command
+type importLinkResult struct {
+	FileCode string `json:"file_code"` // file code of the file in the account
+	Folder   string `json:"folder"`    // folder it was saved in
+}
+
+// fileCodeFromLink returns the file code of a public FileLu link such as
+// https://filelu.com/abcdefghijkl/name.html, or of a bare file code
+func fileCodeFromLink(link string) (string, error) {
+	link = strings.TrimSpace(link)
+	if isFileCode(link) {
+		return link, nil
+	}
+	u, err := url.Parse(link)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", fmt.Errorf("%q is not a FileLu link or file code", link)
+	}
+	host := strings.ToLower(u.Hostname())
+	if host != "filelu.com" && !strings.HasSuffix(host, ".filelu.com") {
+		return "", fmt.Errorf("%q is not a FileLu link", link)
+	}
+	for _, part := range strings.Split(u.Path, "/") {
+		if isFileCode(part) {
+			return part, nil
+		}
+	}
+	return "", fmt.Errorf("no file code found in %q", link)
+}
+
+// importLink saves the public file at link into the folder at dir,
+// relative to the root, without downloading it
+func (f *Fs) importLink(ctx context.Context, dir, link string) (*importLinkResult, error) {
+	srcCode, err := fileCodeFromLink(link)
+	if err != nil {
+		return nil, fmt.Errorf("importlink: %w", err)
+	}
+	fileCode, err := f.cloneFile(ctx, srcCode)
 	if err != nil {
-		return "", fmt.Errorf("error decoding response: %w", err)
+		return nil, fmt.Errorf("importlink: %w", err)
+	}
+
+	// The clone is made in the account root, so put it in place
+	dstDir := path.Join(f.Root(), dir)
+	if dstDir != "" {
+		if _, err := f.dirCache.FindDir(ctx, dstDir, true); err != nil {
+			return nil, fmt.Errorf("importlink: failed to find destination folder: %w", err)
+		}
+		if err := f.setFileFolder(ctx, fileCode, "/"+dstDir); err != nil {
+			return nil, fmt.Errorf("importlink: %w", err)
+		}
 	}
+	return &importLinkResult{FileCode: fileCode, Folder: "/" + dstDir}, nil
+}
 
+// setFileFolder moves the file with fileCode into the folder at
+// folderPath
+func (f *Fs) setFileFolder(ctx context.Context, fileCode string, folderPath string) error {
+	var result api.DeleteResponse
+	err := f.callAPI(ctx, "/file/set_folder", url.Values{
+		"file_code":               {fileCode},
+		"destination_folder_path": {f.apiPath(folderPath)},
+	}, &result)
+	if err != nil {
+		return fmt.Errorf("failed to move file: %w", err)
+	}
 	if result.Status != 200 {
-		return "", fmt.Errorf("error: %s", result.Msg)
+		return fmt.Errorf("error while moving file: %s", result.Msg)
 	}
+	return nil
+}
 
-	if len(result.Result) > 0 {
-		if result.Result[0].Hash != "" {
-			return result.Result[0].Hash, nil
-		}
+// renameFileByCode renames the file with fileCode to newName
+func (f *Fs) renameFileByCode(ctx context.Context, fileCode string, newName string) error {
+	var result api.DeleteResponse
+	err := f.callAPI(ctx, "/file/rename", url.Values{
+		"file_code": {fileCode},
+		"name":      {newName},
+	}, &result)
+	if err != nil {
+		return fmt.Errorf("failed to rename file: %w", err)
+	}
+	if result.Status != 200 {
+		return fmt.Errorf("error while renaming file: %s", result.Msg)
 	}
+	return nil
+}
 
-	fmt.Println("DEBUG: Hash not found in API response.")
-	return "", nil
+// copyFolderResult is returned by the copyfolder command
+type copyFolderResult struct {
+	Files  int      `json:"files"`  // number of files copied
+	Bytes  int64    `json:"bytes"`  // number of bytes copied
+	Failed []string `json:"failed"` // files which couldn't be copied
 }
 
-// Move the objects and directories
-func (f *Fs) Move(ctx context.Context, src fs.Object, remote string) (fs.Object, error) {
-	fs.Debugf(f, "Move: starting directory move for %q to %q", src.Remote(), remote)
+// copyFolder copies the folder at srcDir to dstDir, both relative to the
+// root, server side.
+//
+// Each file is accounted as a server-side copy so progress shows in the
+// stats.
+func (f *Fs) copyFolder(ctx context.Context, srcDir, dstDir string) (*copyFolderResult, error) {
+	result := &copyFolderResult{Failed: []string{}}
+	err := f.ListR(ctx, srcDir, func(entries fs.DirEntries) error {
+		for _, entry := range entries {
+			rel := strings.TrimPrefix(strings.TrimPrefix(entry.Remote(), srcDir), "/")
+			dstRemote := path.Join(dstDir, rel)
+			switch x := entry.(type) {
+			case fs.Directory:
+				// Make sure empty folders are copied too
+				if _, err := f.dirCache.FindDir(ctx, path.Join(f.Root(), dstRemote), true); err != nil {
+					return fmt.Errorf("failed to create folder %q: %w", dstRemote, err)
+				}
+			case fs.Object:
+				tr := accounting.Stats(ctx).NewTransfer(x, f)
+				in := tr.Account(ctx, nil)
+				in.ServerSideTransferStart()
+				dst, err := f.Copy(ctx, x, dstRemote)
+				if err == nil {
+					in.ServerSideCopyEnd(dst.Size())
+					result.Files++
+					result.Bytes += dst.Size()
+				} else {
+					fs.Errorf(x, "copyfolder: failed to copy: %v", err)
+					result.Failed = append(result.Failed, rel)
+				}
+				_ = in.Close()
+				tr.Done(ctx, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return result, fmt.Errorf("copyfolder: %w", err)
+	}
+	return result, nil
+}
 
-	// Check if the source is a directory
-	if srcDir, ok := src.(fs.Directory); ok {
-		// Recursively move all contents
-		err := f.moveDirectoryContents(ctx, srcDir.Remote(), remote)
+// Move src to this remote using server-side move operations.
+//
+// This is stored with the remote path given.
+//
+// It returns the destination Object and a possible error.
+//
+// A file in another FileLu account is moved by cloning it into this
+// account then removing the original.
+//
+// If it isn't possible then return fs.ErrorCantMove
+func (f *Fs) Move(ctx context.Context, src fs.Object, remote string) (fs.Object, error) {
+	srcObj, ok := src.(*Object)
+	if !ok || f.opt.RootIsDrop || srcObj.fs.opt.RootIsDrop {
+		fs.Debugf(src, "Can't move - not same remote type")
+		return nil, fs.ErrorCantMove
+	}
+	defer f.forgetParentListing(remote)
+	defer srcObj.fs.forgetParentListing(srcObj.remote)
+	if !srcObj.fs.sameAccount(f) {
+		dst, err := f.Copy(ctx, src, remote)
+		if errors.Is(err, fs.ErrorCantCopy) {
+			return nil, fs.ErrorCantMove
+		}
 		if err != nil {
-			return nil, fmt.Errorf("failed to move directory contents: %w", err)
+			return nil, err
 		}
-		fs.Debugf(f, "Move: successfully moved directory %q to %q", src.Remote(), remote)
-		return src, nil
+		if err := src.Remove(ctx); err != nil {
+			return nil, fmt.Errorf("move: failed to remove original: %w", err)
+		}
+		return dst, nil
 	}
 
-	// Fall back to single file move
-	return f.MoveTo(ctx, src, remote)
-}
+	// Use the file code where known as once moved the file may share its
+	// old name with a file in the destination folder
+	fileCode := srcObj.openFileCode()
 
-// Updated recursive directory mover
-func (f *Fs) moveDirectoryContents(ctx context.Context, dir string, dest string) error {
-	// List all contents of the directory
-	entries, err := f.List(ctx, dir)
+	// FileLu allows duplicate names so deal with a file already at remote
+	existing, err := f.existingObject(ctx, remote)
+	if err != nil {
+		return nil, fmt.Errorf("move: %w", err)
+	}
+	if existing != nil && fileCode == "" {
+		// Only the file code tells the two apart once moved
+		fileCode, err = srcObj.fs.findFileCode(ctx, srcObj.remote)
+		if err != nil {
+			return nil, fmt.Errorf("move: %w", err)
+		}
+	}
+	if existing != nil && existing.fileCode == fileCode {
+		fs.Debugf(src, "Move: source and destination are the same file")
+		return existing, nil
+	}
+	remote, existing, err = f.overwriteTarget(ctx, remote, existing)
 	if err != nil {
-		return fmt.Errorf("failed to list directory contents: %w", err)
+		return nil, err
 	}
 
-	for _, entry := range entries {
-		switch obj := entry.(type) {
-		case fs.Directory:
-			// Recursively move subdirectory
-			subDirDest := path.Join(dest, obj.Remote())
-			err = f.moveDirectoryContents(ctx, obj.Remote(), subDirDest)
-			if err != nil {
-				return err
-			}
-		case fs.Object:
-			// Move file using MoveTo
-			_, err = f.MoveTo(ctx, obj, path.Join(dest, obj.Remote()))
-			if err != nil {
-				return err
-			}
-		default:
-			return fmt.Errorf("unexpected entry type: %T", entry)
+	srcPath := path.Join(srcObj.fs.Root(), srcObj.remote)
+	dstPath := path.Join(f.Root(), remote)
+	srcDir, srcLeaf := path.Dir(srcPath), path.Base(srcPath)
+	dstDir, dstLeaf := path.Dir(dstPath), path.Base(dstPath)
+	if dstDir == "." {
+		dstDir = ""
+	}
+	if srcDir == "." {
+		srcDir = ""
+	}
+
+	if srcDir != dstDir {
+		// Make sure the destination folder exists
+		if _, err := f.dirCache.FindDir(ctx, dstDir, true); err != nil {
+			return nil, fmt.Errorf("move: failed to find destination folder: %w", err)
+		}
+		if fileCode != "" {
+			err = f.setFileFolder(ctx, fileCode, dstDir)
+		} else {
+			err = f.moveFileToFolder(ctx, srcPath, dstDir)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("move: %w", err)
+		}
+	}
+	if srcLeaf != dstLeaf {
+		if fileCode != "" {
+			err = f.renameFileByCode(ctx, fileCode, dstLeaf)
+		} else {
+			err = f.renameFile(ctx, path.Join(dstDir, srcLeaf), dstLeaf)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("move: %w", err)
 		}
 	}
 
-	return nil
+	// Now the file is in place remove the one it replaces
+	if existing != nil {
+		if err := existing.Remove(ctx); err != nil {
+			return nil, fmt.Errorf("move: failed to remove replaced file: %w", err)
+		}
+		f.forgetDirectLink(existing.fileCode, "")
+	}
+
+	srcObj.fs.forgetObject(srcObj.remote)
+	return &Object{
+		fs:       f,
+		remote:   remote,
+		size:     srcObj.size,
+		hasSize:  srcObj.hasSize,
+		modTime:  srcObj.modTime,
+		hash:     srcObj.hash,
+		fileCode: fileCode,
+	}, nil
 }
 
 // Helper method to move a single file
@@ -1495,8 +3843,16 @@ func (f *Fs) moveSingleFile(ctx context.Context, src fs.Object, remote string) (
 }
 
 // MoveTo moves the file to the specified location
+//
+// If remote is a local path the file is downloaded there and removed
+// from FileLu. Otherwise src is uploaded into the folder at remote,
+// relative to the root, which is created if needed, and then removed.
 func (f *Fs) MoveTo(ctx context.Context, src fs.Object, remote string) (fs.Object, error) {
 	fs.Debugf(f, "MoveTo: Starting move for %q to %q", src.Remote(), remote)
+	defer f.forgetParentListing(remote)
+	if srcObj, ok := src.(*Object); ok {
+		defer srcObj.fs.forgetParentListing(srcObj.remote)
+	}
 
 	// Check if this is a remote-to-local move
 	if strings.HasPrefix(remote, "/") || strings.Contains(remote, ":\\") {
@@ -1508,29 +3864,8 @@ func (f *Fs) MoveTo(ctx context.Context, src fs.Object, remote string) (fs.Objec
 			return nil, fmt.Errorf("failed to create destination directory: %w", err)
 		}
 
-		// Open source file for reading
-		reader, err := src.Open(ctx)
-		if err != nil {
-			return nil, fmt.Errorf("failed to open source file: %w", err)
-		}
-		defer func() {
-			if err := reader.Close(); err != nil {
-				fs.Logf(nil, "Failed to close reader: %v", err)
-			}
-		}()
-
-		// Create destination file
-		dest, err := os.Create(remote)
-		if err != nil {
-			return nil, fmt.Errorf("failed to create destination file: %w", err)
-		}
-		defer func() {
-			if err := dest.Close(); err != nil {
-				fs.Logf(nil, "Failed to close destination file: %v", err)
-			}
-		}()
-		// Copy the content
-		_, err = io.Copy(dest, reader)
+		// Download the content
+		_, err = downloadObject(ctx, src, remote)
 		if err != nil {
 			return nil, fmt.Errorf("failed to copy file content: %w", err)
 		}
@@ -1554,8 +3889,19 @@ func (f *Fs) MoveTo(ctx context.Context, src fs.Object, remote string) (fs.Objec
 			fs.Logf(nil, "Failed to close reader: %v", err)
 		}
 	}()
+	tempPath, _, err := createTempFileFromReader(f.opt.TempDir, src.Remote(), reader, hash.None)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temp file: %w", err)
+	}
+	fs.Debugf(f, "MoveTo: staging %q in %q", src.Remote(), tempPath)
+	defer func() {
+		if err := os.Remove(tempPath); err != nil {
+			fs.Logf(nil, "Failed to remove temporary file %q: %v", tempPath, err)
+		}
+	}()
+
 	// Get upload server details
-	uploadURL, sessID, err := f.getUploadServer(ctx)
+	uploadURL, sessID, err := f.getUploadSession(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("failed to retrieve upload server: %w", err)
 	}
@@ -1565,20 +3911,17 @@ func (f *Fs) MoveTo(ctx context.Context, src fs.Object, remote string) (fs.Objec
 	fs.Debugf(f, "MoveTo: Using filename %q for upload", fileName)
 
 	// Upload file to root directory first
-	fileCode, err := f.uploadFile(ctx, uploadURL, sessID, fileName, reader)
+	dstRemote := path.Join(remote, fileName)
+	fileCode, err := f.uploadFile(ctx, uploadURL, sessID, dstRemote, tempPath)
 	if err != nil {
 		return nil, fmt.Errorf("failed to upload file: %w", err)
 	}
+	f.releaseUploadSession(uploadURL, sessID)
 	fs.Debugf(f, "MoveTo: File uploaded with code: %s", fileCode)
 
-	// Move the file to destination folder
-	sourcePath := "/" + fileName
-	destinationPath := "/" + strings.Trim(f.root, "/")
-
-	fs.Debugf(f, "MoveTo: Moving file from %q to folder %q", sourcePath, destinationPath)
-	err = f.moveFileToFolder(ctx, sourcePath, destinationPath)
-	if err != nil {
-		return nil, fmt.Errorf("failed to move file to destination folder: %w", err)
+	// Move the file into the destination folder, remote under the root
+	if err := f.placeUpload(ctx, fileCode, dstRemote); err != nil {
+		return nil, f.discardUpload(ctx, fileCode, err)
 	}
 
 	// Delete the source file after successful move
@@ -1589,10 +3932,12 @@ func (f *Fs) MoveTo(ctx context.Context, src fs.Object, remote string) (fs.Objec
 
 	// Create and return the destination object
 	return &Object{
-		fs:      f,
-		remote:  path.Join(remote, fileName),
-		size:    src.Size(),
-		modTime: src.ModTime(ctx),
+		fs:       f,
+		remote:   dstRemote,
+		size:     src.Size(),
+		hasSize:  true,
+		modTime:  src.ModTime(ctx),
+		fileCode: fileCode,
 	}, nil
 }
 
@@ -1607,27 +3952,7 @@ func (f *Fs) MoveToLocal(ctx context.Context, remote string, localPath string) e
 		return fmt.Errorf("failed to find object in FileLu: %w", err)
 	}
 
-	reader, err := obj.Open(ctx)
-	if err != nil {
-		return fmt.Errorf("failed to open file for download: %w", err)
-	}
-	defer func() {
-		if err := reader.Close(); err != nil {
-			fs.Logf(nil, "Failed to close reader: %v", err)
-		}
-	}()
-
-	outFile, err := os.Create(localPath)
-	if err != nil {
-		return fmt.Errorf("failed to create local file %q: %w", localPath, err)
-	}
-	defer func() {
-		if err := reader.Close(); err != nil {
-			fs.Logf(nil, "Failed to close reader: %v", err)
-		}
-	}()
-
-	_, err = io.Copy(outFile, reader)
+	_, err = downloadObject(ctx, obj, localPath)
 	if err != nil {
 		return fmt.Errorf("failed to copy data to local file: %w", err)
 	}
@@ -1655,103 +3980,50 @@ func DeleteLocalFile(localPath string) error {
 // Rmdir removes a directory
 func (f *Fs) Rmdir(ctx context.Context, dir string) error {
 	fs.Debugf(f, "Rmdir: Starting with dir=%q", dir)
+	defer f.forgetListingTree(path.Join(f.Root(), dir))
+
+	if f.opt.RootIsDrop {
+		return errFiledrop
+	}
 
 	// Construct the full folder path
-	fullPath := path.Join(f.root, dir)
-	if fullPath != "" {
-		fullPath = "/" + strings.Trim(fullPath, "/")
+	fullPath := path.Join(f.Root(), dir)
+	if fullPath == "" {
+		return errAccountRoot
 	}
+	fullPath = f.apiPath(fullPath)
 	fs.Debugf(f, "Rmdir: Using folder path %q", fullPath)
 
 	// First check if the folder is empty using folder/list
-	listURL := fmt.Sprintf("%s/folder/list?folder_path=%s&key=%s",
-		f.endpoint,
-		url.QueryEscape(fullPath),
-		url.QueryEscape(f.opt.RcloneKey),
-	)
-
-	req, err := http.NewRequestWithContext(ctx, "GET", listURL, nil)
-	if err != nil {
-		return fserrors.NoRetryError(fmt.Errorf("failed to create list request: %w", err))
+	files, folders, err := f.countFolder(ctx, fullPath)
+	if errors.Is(err, fs.ErrorDirNotFound) {
+		return err
 	}
-
-	resp, err := f.client.Do(req)
 	if err != nil {
 		return fserrors.NoRetryError(fmt.Errorf("failed to check directory contents: %w", err))
 	}
-	defer func() {
-		if err := resp.Body.Close(); err != nil {
-			fs.Logf(nil, "Failed to close response body: %v", err)
-		}
-	}()
-
-	// Read and log response for debugging
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return fserrors.NoRetryError(fmt.Errorf("error reading list response body: %w", err))
-	}
-	fs.Debugf(f, "Rmdir: List response: %s", string(body))
-
-	var listResult struct {
-		Status int    `json:"status"`
-		Msg    string `json:"msg"`
-		Result struct {
-			Files   []interface{} `json:"files"`
-			Folders []interface{} `json:"folders"`
-		} `json:"result"`
-	}
-	if err := json.NewDecoder(bytes.NewReader(body)).Decode(&listResult); err != nil {
-		return fserrors.NoRetryError(fmt.Errorf("error decoding list response: %w", err))
-	}
-
-	// Check if folder exists and is empty
-	if listResult.Status != 200 {
-		return fserrors.NoRetryError(fmt.Errorf("folder not found: %s", listResult.Msg))
-	}
-
-	if len(listResult.Result.Files) > 0 || len(listResult.Result.Folders) > 0 {
+	if files > 0 || folders > 0 {
 		return fserrors.NoRetryError(fmt.Errorf("directory is not empty"))
 	}
 
 	// Delete the folder using the new folder_path API
-	deleteURL := fmt.Sprintf("%s/folder/delete?folder_path=%s&key=%s",
-		f.endpoint,
-		url.QueryEscape(fullPath),
-		url.QueryEscape(f.opt.RcloneKey),
-	)
-
-	fs.Debugf(f, "Rmdir: Sending delete request to %s", deleteURL)
-
-	req, err = http.NewRequestWithContext(ctx, "GET", deleteURL, nil)
-	if err != nil {
-		return fserrors.NoRetryError(fmt.Errorf("failed to create delete request: %w", err))
-	}
-
-	resp, err = f.client.Do(req)
+	var result api.FolderResponse
+	err = f.callAPI(ctx, "/folder/delete", url.Values{"folder_path": {fullPath}}, &result)
 	if err != nil {
 		return fserrors.NoRetryError(fmt.Errorf("failed to delete directory: %w", err))
 	}
-	defer func() {
-		if err := resp.Body.Close(); err != nil {
-			fs.Logf(nil, "Failed to close response body: %v", err)
-		}
-	}()
-
-	// Read and log response for debugging
-	body, err = io.ReadAll(resp.Body)
-	if err != nil {
-		return fserrors.NoRetryError(fmt.Errorf("error reading delete response body: %w", err))
-	}
-	fs.Debugf(f, "Rmdir: Delete response: %s", string(body))
 
-	var result struct {
-		Status int    `json:"status"`
-		Msg    string `json:"msg"`
-	}
-	if err := json.NewDecoder(bytes.NewReader(body)).Decode(&result); err != nil {
-		return fserrors.NoRetryError(fmt.Errorf("error decoding delete response: %w", err))
+	if isFolderNotEmpty(result.Status, result.Msg) {
+		// It looked empty, so something was put in it since or the
+		// listing missed it. Say what is in it now.
+		files, folders, err := f.countFolder(ctx, fullPath)
+		if err != nil {
+			fs.Debugf(f, "Rmdir: failed to list %q again: %v", fullPath, err)
+		} else {
+			return fserrors.NoRetryError(fmt.Errorf("%w: it now holds %d files and %d folders: %s",
+				fs.ErrorDirectoryNotEmpty, files, folders, result.Msg))
+		}
 	}
-
 	if result.Status != 200 {
 		return fserrors.NoRetryError(fmt.Errorf("error deleting directory: %s", result.Msg))
 	}
@@ -1760,6 +4032,23 @@ func (f *Fs) Rmdir(ctx context.Context, dir string) error {
 	return nil
 }
 
+// countFolder lists the folder at fullPath, from the account root, and
+// returns the number of files and folders in it
+func (f *Fs) countFolder(ctx context.Context, fullPath string) (files, folders int, err error) {
+	var result api.FolderListResponse
+	err = f.callAPI(ctx, "/folder/list", url.Values{"folder_path": {fullPath}}, &result)
+	if err != nil {
+		return 0, 0, err
+	}
+	if isFolderGone(result.Status, result.Msg) {
+		return 0, 0, fs.ErrorDirNotFound
+	}
+	if result.Status != 200 {
+		return 0, 0, fmt.Errorf("failed to list folder: %s", result.Msg)
+	}
+	return len(result.Result.Files), len(result.Result.Folders), nil
+}
+
 // Name returns the remote name
 func (f *Fs) Name() string {
 	return f.name
@@ -1767,12 +4056,22 @@ func (f *Fs) Name() string {
 
 // Root returns the root path
 func (f *Fs) Root() string {
+	f.rootMu.RLock()
+	defer f.rootMu.RUnlock()
 	return f.root
 }
 
+// rootFile returns the file the remote points to and whether it points
+// to one rather than to a folder
+func (f *Fs) rootFile() (string, bool) {
+	f.rootMu.RLock()
+	defer f.rootMu.RUnlock()
+	return f.targetFile, f.isFile
+}
+
 // String converts this Fs to a string
 func (f *Fs) String() string {
-	return fmt.Sprintf("FileLu root '%s'", f.root)
+	return fmt.Sprintf("FileLu root '%s'", f.Root())
 }
 
 // Fs returns the parent Fs
@@ -1785,70 +4084,601 @@ func (o *Object) Remote() string {
 	return o.remote
 }
 
-// Size returns the size of the object
-func (o *Object) Size() int64 {
-	return o.size
+// ID returns the file code of the object if known, or "" if not
+func (o *Object) ID() string {
+	return o.fileCode
+}
+
+// Version reads file/info and returns the version of the object's
+// content, which changes if the file is changed outside rclone.
+//
+// If it has changed since it was last read the cached direct link for
+// the file is dropped, so the next Open fetches a new one, and the size,
+// modification time and hash of the object are updated.
+func (o *Object) Version(ctx context.Context) (string, error) {
+	fileCode := o.openFileCode()
+	if fileCode == "" {
+		var err error
+		fileCode, err = o.fs.findFileCode(ctx, o.remote)
+		if err != nil {
+			return "", fmt.Errorf("failed to find file code: %w", err)
+		}
+	}
+	info, err := o.fs.readFileInfo(ctx, fileCode)
+	if err != nil {
+		return "", err
+	}
+	version := fileVersion(info)
+	if o.version != "" && version != o.version {
+		fs.Debugf(o, "Changed outside rclone, dropping cached direct link")
+		o.fs.forgetDirectLink(fileCode, "")
+		o.fs.forgetDirectLink("", path.Join(o.fs.Root(), o.remote))
+		if size, err := strconv.ParseInt(info.Size, 10, 64); err == nil {
+			o.setSize(size)
+		}
+		if modTime, err := parseUploadedTime(info.Uploaded); err == nil && !o.fs.opt.NoModTime {
+			o.modTime = modTime
+		}
+		o.hash = ""
+		if o.fs.opt.HashOnList {
+			o.hash = info.Hash
+		}
+	}
+	o.version = version
+	return version, nil
+}
+
+// MimeType returns the content type of the object.
+//
+// This is the type file/info reports if it reports one, otherwise it is
+// worked out from the extension. It is looked up once and remembered.
+func (o *Object) MimeType(ctx context.Context) string {
+	if o.mimeType != "" {
+		return o.mimeType
+	}
+	if o.fileCode != "" {
+		info, err := o.fs.readFileInfo(ctx, o.fileCode)
+		if err != nil {
+			fs.Debugf(o, "MimeType: failed to read file info: %v", err)
+		} else if o.mimeType = info.MimeType; o.mimeType == "" {
+			o.mimeType = info.ContentType
+		}
+	}
+	if !strings.ContainsRune(o.mimeType, '/') {
+		o.mimeType = fs.MimeTypeFromName(o.remote)
+	}
+	return o.mimeType
+}
+
+// setSize records the size of the object as known
+func (o *Object) setSize(size int64) {
+	o.size = size
+	o.hasSize = true
+}
+
+// fetchSize discovers the size of the object using the configured
+// size_method, caching the result on the object
+func (o *Object) fetchSize(ctx context.Context) (int64, error) {
+	if o.hasSize {
+		return o.size, nil
+	}
+	filePath := path.Join(o.fs.Root(), o.remote)
+	method := o.fs.opt.SizeMethod
+	if method == sizeMethodHead && o.fs.opt.NoHeadObject {
+		method = sizeMethodInfo
+	}
+
+	var (
+		size int64
+		err  error
+	)
+	switch method {
+	case sizeMethodInfo:
+		size, err = o.fs.getFileSize(ctx, filePath)
+	case sizeMethodHead:
+		size, err = o.fs.headSize(ctx, filePath)
+	default:
+		dir := path.Dir(o.remote)
+		if dir == "." {
+			dir = ""
+		}
+		var entries fs.DirEntries
+		entries, err = o.fs.List(ctx, dir)
+		if err == nil {
+			err = fs.ErrorObjectNotFound
+			for _, entry := range entries {
+				if other, ok := entry.(*Object); ok && other.remote == o.remote {
+					size, err = other.size, nil
+					break
+				}
+			}
+		}
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to read size of %q: %w", o.remote, err)
+	}
+	o.setSize(size)
+	return size, nil
+}
+
+// headSize reads the size of the file at filePath with a HEAD request
+// on its direct link
+func (f *Fs) headSize(ctx context.Context, filePath string) (int64, error) {
+	directLink, _, err := f.getDirectLink(ctx, filePath)
+	if err != nil {
+		return 0, err
+	}
+	req, err := http.NewRequestWithContext(ctx, "HEAD", directLink, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create HEAD request: %w", err)
+	}
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("HEAD request failed: %w", err)
+	}
+	if err := resp.Body.Close(); err != nil {
+		fs.Logf(nil, "Failed to close response body: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("HEAD request failed: HTTP %d", resp.StatusCode)
+	}
+	if resp.ContentLength < 0 {
+		return 0, errors.New("HEAD response has no Content-Length")
+	}
+	return resp.ContentLength, nil
+}
+
+// Size returns the size of the object
+func (o *Object) Size() int64 {
+	return o.size
+}
+
+// ModTime returns the modification time of the object, or the Unix
+// epoch if no_modtime is set
+func (o *Object) ModTime(ctx context.Context) time.Time {
+	if o.fs.opt.NoModTime {
+		return unknownModTime
+	}
+	return o.modTime
+}
+
+// SetModTime sets the modification time of the object
+//
+// FileLu has no call to change it so this always fails.
+func (o *Object) SetModTime(ctx context.Context, modTime time.Time) error {
+	return fs.ErrorCantSetModTime
+}
+
+// Storable indicates whether the object is storable
+func (o *Object) Storable() bool {
+	return true
+}
+
+// Open opens the object for reading
+//
+// The reader returned can be seeked, which fetches the data from the new
+// position with a ranged request.
+func (o *Object) Open(ctx context.Context, options ...fs.OpenOption) (io.ReadCloser, error) {
+	if o.fs.opt.RootIsDrop {
+		return nil, errFiledrop
+	}
+	in, err := o.open(ctx, options)
+	if err != nil {
+		return nil, err
+	}
+	r := &rangeReader{ctx: ctx, o: o, in: in, length: -1}
+	for _, option := range options {
+		switch x := option.(type) {
+		case *fs.SeekOption:
+			r.offset = x.Offset
+		case *fs.RangeOption:
+			r.offset, r.length = x.Decode(o.size)
+		}
+	}
+	return r, nil
+}
+
+// open fetches the object with the options from its direct link
+func (o *Object) open(ctx context.Context, options []fs.OpenOption) (io.ReadCloser, error) {
+	fileCode := o.openFileCode()
+	filePath := path.Join(o.fs.Root(), o.remote)
+	var (
+		resp      *http.Response
+		refreshed bool // set once the direct link has been replaced
+	)
+	err := o.fs.pacer.Call(func() (bool, error) {
+		urls, size, err := o.fs.cachedDirectLink(ctx, fileCode, filePath)
+		if err != nil {
+			return shouldRetry(ctx, fmt.Errorf("failed to get direct link: %w", err))
+		}
+		if !o.hasSize {
+			o.setSize(size)
+		}
+		// Only fetch the part asked for, so seeking doesn't download
+		// the whole file each time
+		fs.FixRangeOption(options, o.size)
+		resp, err = o.downloadMirrors(ctx, orderMirrors(urls, o.fs.opt.MirrorSelection), options)
+		if err != nil {
+			return shouldRetry(ctx, err)
+		}
+		switch resp.StatusCode {
+		case http.StatusOK, http.StatusPartialContent:
+			return false, nil
+		}
+		_ = resp.Body.Close()
+		err = fmt.Errorf("failed to download file: %w", &statusError{StatusCode: resp.StatusCode})
+		switch resp.StatusCode {
+		case http.StatusForbidden, http.StatusNotFound, http.StatusGone:
+			// A remembered link may have expired early, so get a new one
+			o.fs.forgetDirectLink(fileCode, filePath)
+			if !refreshed {
+				refreshed = true
+				return true, err
+			}
+			return false, err
+		}
+		// The CDN may be overloaded, so back off and try again
+		return shouldRetry(ctx, err)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// rangeReader reads an object, fetching it again from the new position
+// when seeked
+type rangeReader struct {
+	ctx    context.Context
+	o      *Object
+	in     io.ReadCloser // current response body, nil if not open
+	offset int64         // position of the next byte read
+	length int64         // number of bytes left to read, or -1 for all
+}
+
+// Read reads from the object, fetching it from the current position if
+// needed
+func (r *rangeReader) Read(p []byte) (n int, err error) {
+	if r.length == 0 {
+		return 0, io.EOF
+	}
+	if r.in == nil {
+		end := int64(-1)
+		if r.length > 0 {
+			end = r.offset + r.length - 1
+		}
+		r.in, err = r.o.open(r.ctx, []fs.OpenOption{&fs.RangeOption{Start: r.offset, End: end}})
+		if err != nil {
+			return 0, err
+		}
+	}
+	if r.length > 0 && int64(len(p)) > r.length {
+		p = p[:r.length]
+	}
+	n, err = r.in.Read(p)
+	r.offset += int64(n)
+	if r.length > 0 {
+		r.length -= int64(n)
+	}
+	return n, err
+}
+
+// Seek sets the position of the next Read, which fetches the rest of the
+// object from there
+func (r *rangeReader) Seek(offset int64, whence int) (int64, error) {
+	return r.RangeSeek(r.ctx, offset, whence, -1)
+}
+
+// RangeSeek sets the position of the next Read and limits the data read
+// from there to length bytes, or to the end of the object if length < 0.
+//
+// It implements fs.RangeSeeker
+func (r *rangeReader) RangeSeek(ctx context.Context, offset int64, whence int, length int64) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.offset
+	case io.SeekEnd:
+		offset += r.o.size
+	default:
+		return 0, fmt.Errorf("invalid whence %d", whence)
+	}
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+	if offset == r.offset && length == r.length {
+		return offset, nil
+	}
+	if err := r.Close(); err != nil {
+		return 0, err
+	}
+	r.ctx = ctx
+	r.offset = offset
+	r.length = length
+	return offset, nil
+}
+
+// Close closes the current response body if any
+func (r *rangeReader) Close() error {
+	if r.in == nil {
+		return nil
+	}
+	err := r.in.Close()
+	r.in = nil
+	return err
+}
+
+// downloadMirrors sends a GET to each of urls in turn until one doesn't
+// fail with an error or a server error, returning the last response
+func (o *Object) downloadMirrors(ctx context.Context, urls []string, options []fs.OpenOption) (resp *http.Response, err error) {
+	for i, directLink := range urls {
+		resp, err = o.download(ctx, directLink, options)
+		if i == len(urls)-1 || ctx.Err() != nil {
+			break
+		}
+		if err == nil {
+			if resp.StatusCode < http.StatusInternalServerError {
+				break
+			}
+			_ = resp.Body.Close()
+			err = &statusError{StatusCode: resp.StatusCode}
+		}
+		fs.Debugf(o, "Download from mirror %d of %d failed, trying the next: %v", i+1, len(urls), err)
+	}
+	return resp, err
+}
+
+// download sends a GET for directLink with the headers from options
+func (o *Object) download(ctx context.Context, directLink string, options []fs.OpenOption) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", directLink, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create download request: %w", err)
+	}
+	fs.OpenOptionAddHTTPHeaders(req.Header, options)
+
+	client := *o.fs.client
+	client.CheckRedirect = checkDownloadRedirect
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download file: %w", err)
+	}
+	return resp, nil
+}
+
+// maxDownloadRedirects is how many redirects a direct link may go
+// through before the download gives up
+const maxDownloadRedirects = 10
+
+// errRedirectLoop is returned when a direct link redirects in a loop
+var errRedirectLoop = errors.New("direct link redirects in a loop")
+
+// checkDownloadRedirect is the CheckRedirect of the client used to follow
+// a direct link to the server holding the file.
+//
+// It makes sure headers such as Range reach the new location and stops
+// with errRedirectLoop if the link goes round in circles.
+func checkDownloadRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= maxDownloadRedirects {
+		return fmt.Errorf("stopped after %d redirects: %w", len(via), errRedirectLoop)
+	}
+	for _, prev := range via {
+		if prev.URL.String() == req.URL.String() {
+			return fmt.Errorf("redirected back to %s: %w", req.URL.Redacted(), errRedirectLoop)
+		}
+	}
+	for name, values := range via[0].Header {
+		if _, ok := req.Header[name]; !ok {
+			req.Header[name] = values
+		}
+	}
+	return nil
+}
+
+// fileCodeRe matches the parts of a remote in parentheses which may
+// hold a file code, as in "name (abcdefghijkl).txt"
+var fileCodeRe = regexp.MustCompile(`\((.*?)\)`)
+
+// fileCodeFromRemote returns the file code decorating the last element
+// of remote, as in "dir/(abcdefghijkl) name", or "" if there isn't one.
+func fileCodeFromRemote(remote string) string {
+	for _, match := range fileCodeRe.FindAllStringSubmatch(path.Base(remote), -1) {
+		if isFileCode(match[1]) {
+			return match[1]
+		}
+	}
+	return ""
+}
+
+// openFileCode returns the file code Open should download.
+//
+// The object's own code is used if known, then one decorating its remote.
+// The root is only used when it is itself a file code, as for normal
+// objects it is a folder.
+func (o *Object) openFileCode() string {
+	if o.fileCode != "" {
+		return o.fileCode
+	}
+	if fileCode := fileCodeFromRemote(o.remote); fileCode != "" {
+		return fileCode
+	}
+	return o.fs.rootFileCode()
 }
 
-// ModTime returns the modification time of the object
-func (o *Object) ModTime(ctx context.Context) time.Time {
-	return o.modTime
+// rootFileCode returns the root if it is a file code rather than a
+// folder, or "" otherwise
+func (f *Fs) rootFileCode() string {
+	if isFileCode(f.Root()) {
+		return f.Root()
+	}
+	return ""
 }
 
-// SetModTime sets the modification time of the object
-func (o *Object) SetModTime(ctx context.Context, modTime time.Time) error {
-	o.modTime = modTime
-	return nil
+// readFileInfo reads file/info for the file with fileCode, returning
+// fs.ErrorObjectNotFound if there isn't one
+func (f *Fs) readFileInfo(ctx context.Context, fileCode string) (api.FileInfo, error) {
+	var result api.FileInfoResponse
+	err := f.pacer.Call(func() (bool, error) {
+		err := f.callAPI(ctx, "/file/info", url.Values{"file_code": {fileCode}}, &result)
+		return shouldRetry(ctx, err)
+	})
+	if err != nil {
+		return api.FileInfo{}, fmt.Errorf("failed to read file info: %w", err)
+	}
+	if result.Status == 404 || (result.Status == 200 && len(result.Result) == 0) {
+		return api.FileInfo{}, fs.ErrorObjectNotFound
+	}
+	if result.Status != 200 {
+		return api.FileInfo{}, fmt.Errorf("error fetching file info: %s", result.Msg)
+	}
+	return result.Result[0], nil
 }
 
-// Storable indicates whether the object is storable
-func (o *Object) Storable() bool {
-	return true
+// fileCodeObject returns the file with fileCode as an object in the
+// root, reading its name, size and upload time from file/info
+func (f *Fs) fileCodeObject(ctx context.Context, fileCode string) (*Object, error) {
+	info, err := f.readFileInfo(ctx, fileCode)
+	if err != nil {
+		return nil, err
+	}
+	size, err := strconv.ParseInt(info.Size, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse file size: %w", err)
+	}
+	var modTime time.Time
+	if !f.opt.NoModTime {
+		modTime, err = parseUploadedTime(info.Uploaded)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse upload time: %w", err)
+		}
+	}
+	o := &Object{
+		fs:       f,
+		remote:   info.Name,
+		modTime:  modTime,
+		fileCode: fileCode,
+		version:  fileVersion(info),
+	}
+	if f.opt.HashOnList {
+		o.hash = info.Hash
+	}
+	o.setSize(size)
+	return o, nil
 }
 
-// Open opens the object for reading
-func (o *Object) Open(ctx context.Context, options ...fs.OpenOption) (io.ReadCloser, error) {
-	// Construct the full file path
-	filePath := path.Join(o.fs.root, o.remote)
-
-	directLink, size, err := o.fs.getDirectLink(ctx, filePath)
+// findFileCode returns the file code of the file at remote by listing
+// its parent directory, or fs.ErrorObjectNotFound if there isn't one
+func (f *Fs) findFileCode(ctx context.Context, remote string) (string, error) {
+	dir := path.Dir(remote)
+	if dir == "." {
+		dir = ""
+	}
+	entries, err := f.List(ctx, dir)
+	if errors.Is(err, fs.ErrorDirNotFound) {
+		return "", fs.ErrorObjectNotFound
+	}
 	if err != nil {
-		return nil, fmt.Errorf("failed to get direct link: %w", err)
+		return "", err
+	}
+	for _, entry := range entries {
+		if o, ok := entry.(*Object); ok && o.remote == remote && o.fileCode != "" {
+			return o.fileCode, nil
+		}
 	}
+	return "", fs.ErrorObjectNotFound
+}
 
-	o.size = size // Update the object size with the value from API
+// writerAt stages random access writes in a local temporary file and
+// uploads the result when it is closed
+type writerAt struct {
+	ctx    context.Context
+	fs     *Fs
+	remote string
+	file   *os.File
+}
 
-	req, err := http.NewRequestWithContext(ctx, "GET", directLink, nil)
+// OpenWriterAt opens remote for random access writes
+//
+// FileLu can't write to part of a file, so the writes are staged in a
+// local temporary file of the full size which is uploaded in one go on
+// Close, replacing any existing file. The size of file which can be
+// written is therefore limited by the free space in the temporary
+// directory and nothing is visible on the remote until Close returns.
+func (f *Fs) OpenWriterAt(ctx context.Context, remote string, size int64) (fs.WriterAtCloser, error) {
+	if f.opt.RootIsDrop {
+		return nil, errFiledrop
+	}
+	file, err := createTempFile(f.opt.TempDir, tempFilePattern("writerat", remote))
 	if err != nil {
-		return nil, fmt.Errorf("failed to create download request: %w", err)
+		return nil, fmt.Errorf("failed to create temp file: %w", err)
+	}
+	fs.Debugf(f, "OpenWriterAt: staging %q in %q", remote, file.Name())
+	if size > 0 {
+		if err := file.Truncate(size); err != nil {
+			_ = file.Close()
+			_ = os.Remove(file.Name())
+			return nil, fmt.Errorf("failed to size temp file: %w", err)
+		}
 	}
+	return &writerAt{
+		ctx:    ctx,
+		fs:     f,
+		remote: remote,
+		file:   file,
+	}, nil
+}
 
-	resp, err := o.fs.client.Do(req)
+// WriteAt writes len(p) bytes from p to the staged file at offset off
+func (w *writerAt) WriteAt(p []byte, off int64) (int, error) {
+	return w.file.WriteAt(p, off)
+}
+
+// Close uploads the staged file and removes it
+func (w *writerAt) Close() error {
+	tempPath := w.file.Name()
+	defer func() {
+		if err := os.Remove(tempPath); err != nil {
+			fs.Logf(nil, "Failed to remove temp file %q: %v", tempPath, err)
+		}
+	}()
+	info, err := w.file.Stat()
 	if err != nil {
-		return nil, fmt.Errorf("failed to download file: %w", err)
+		_ = w.file.Close()
+		return fmt.Errorf("failed to stat temp file: %w", err)
 	}
-
-	if resp.StatusCode != http.StatusOK {
-		defer func() {
-			if err := resp.Body.Close(); err != nil {
-				fs.Fatalf(nil, "Failed to close response body: %v", err)
-			}
-		}()
-		return nil, fmt.Errorf("failed to download file: HTTP %d", resp.StatusCode)
+	if _, err := w.file.Seek(0, io.SeekStart); err != nil {
+		_ = w.file.Close()
+		return fmt.Errorf("failed to rewind temp file: %w", err)
 	}
 
-	return resp.Body, nil
+	src := object.NewStaticObjectInfo(w.remote, time.Now(), info.Size(), true, nil, w.fs)
+	o := &Object{fs: w.fs, remote: w.remote}
+	err = o.Update(w.ctx, w.file, src)
+	if closeErr := w.file.Close(); err == nil && closeErr != nil {
+		err = fmt.Errorf("failed to close temp file: %w", closeErr)
+	}
+	return err
 }
 
 // Update updates the object with new data
+//
+// FileLu has no way of replacing the content of a file, so the new
+// content is uploaded as a new file and the old one removed. The file
+// code, and so the ID and any public links, change with every update.
 func (o *Object) Update(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) error {
 	fs.Debugf(o.fs, "Update: Starting update for %q", o.remote)
+	defer o.fs.forgetParentListing(o.remote)
+
+	if o.fs.opt.RootIsDrop {
+		return errFiledrop
+	}
+	o.fs.forgetObject(o.remote)
 
 	// Create temporary file and get its path
-	tempPath, err := createTempFileFromReader(in)
+	tempPath, localSum, err := createTempFileFromReader(o.fs.opt.TempDir, o.remote, in, o.fs.hashType)
 	if err != nil {
 		return fmt.Errorf("failed to create temp file: %w", err)
 	}
+	fs.Debugf(o.fs, "Update: staging %q in %q", o.remote, tempPath)
 
 	// Defer removal of the temporary file
 	defer func() {
@@ -1857,18 +4687,51 @@ func (o *Object) Update(ctx context.Context, in io.Reader, src fs.ObjectInfo, op
 		}
 	}()
 
-	// Open the temporary file for reading
-	tempFile, err := os.Open(tempPath)
-	if err != nil {
-		return fmt.Errorf("failed to open temp file: %w", err)
+	// Find the file being replaced so it can be removed once the new
+	// content is in place, rather than leaving a duplicate behind
+	oldFileCode := o.fileCode
+	if oldFileCode == "" {
+		oldFileCode, err = o.fs.findFileCode(ctx, o.remote)
+		if err != nil && !errors.Is(err, fs.ErrorObjectNotFound) {
+			return fmt.Errorf("failed to look for existing file: %w", err)
+		}
 	}
-	defer func() {
-		if err := tempFile.Close(); err != nil {
-			fs.Logf(nil, "Failed to close temporary file: %v", err)
+
+	// Don't upload content which is already there, as Put doesn't
+	if oldFileCode != "" {
+		old := o
+		if o.fileCode != oldFileCode {
+			old = &Object{fs: o.fs, remote: o.remote, fileCode: oldFileCode}
 		}
-	}()
+		if o.fs.isIdentical(ctx, old, localSum) {
+			fs.Debugf(o, "Update: skipping upload as content is identical")
+			o.fileCode = oldFileCode
+			return nil
+		}
+	}
+
+	if oldFileCode != "" {
+		switch o.fs.opt.Overwrite {
+		case overwriteError:
+			return errExists(o.remote)
+		case overwriteRename:
+			remote, err := o.fs.freeRemote(ctx, o.remote)
+			if err != nil {
+				return err
+			}
+			fs.Debugf(o.fs, "Update: keeping %q and uploading as %q", o.remote, remote)
+			o.remote = remote
+			oldFileCode = ""
+		}
+	}
+	if oldFileCode == "" {
+		fs.Debugf(o.fs, "Update: %q doesn't exist yet, creating it", o.remote)
+	} else {
+		fs.Debugf(o.fs, "Update: replacing %q with file code %q", o.remote, oldFileCode)
+	}
+
 	// Get upload server details
-	uploadURL, sessID, err := o.fs.getUploadServer(ctx)
+	uploadURL, sessID, err := o.fs.getUploadSession(ctx)
 	if err != nil {
 		return fmt.Errorf("failed to get upload server: %w", err)
 	}
@@ -1879,27 +4742,33 @@ func (o *Object) Update(ctx context.Context, in io.Reader, src fs.ObjectInfo, op
 	fs.Debugf(o.fs, "Update: Using filename %q for upload", fileName)
 
 	// Upload the file to root first
-	fileCode, err := o.fs.uploadFile(ctx, uploadURL, sessID, fileName, tempFile)
+	fileCode, err := o.fs.uploadVerified(ctx, uploadURL, sessID, o.remote, tempPath, localSum)
 	if err != nil {
-		return fmt.Errorf("failed to upload file: %w", err)
+		return err
 	}
+	o.fs.releaseUploadSession(uploadURL, sessID)
 	fs.Debugf(o.fs, "Update: File uploaded with file code %q", fileCode)
 
-	// If we have a destination path, move the file there
-	if o.fs.root != "" {
-		sourcePath := "/" + fileName
-		destinationPath := "/" + strings.Trim(o.fs.root, "/")
+	// Move the file into the object's directory
+	err = o.fs.placeUpload(ctx, fileCode, o.remote)
+	if err != nil {
+		return o.fs.discardUpload(ctx, fileCode, err)
+	}
 
-		fs.Debugf(o.fs, "Update: Moving file from %q to folder %q", sourcePath, destinationPath)
-		err = o.fs.moveFileToFolder(ctx, sourcePath, destinationPath)
-		if err != nil {
-			return fmt.Errorf("failed to move file to destination folder: %w", err)
+	// Now the new content is in place remove the old file
+	if oldFileCode != "" && oldFileCode != fileCode {
+		old := &Object{fs: o.fs, remote: o.remote, fileCode: oldFileCode}
+		if err := old.Remove(ctx); err != nil {
+			return fmt.Errorf("failed to remove replaced file: %w", err)
 		}
+		o.fs.forgetDirectLink(oldFileCode, "")
 	}
 
 	// Update the object metadata
-	o.size = src.Size()
+	o.setSize(src.Size())
 	o.modTime = src.ModTime(ctx)
+	o.fileCode = fileCode
+	o.hash = ""
 
 	fs.Debugf(o.fs, "Update: Finished update for %q", o.remote)
 	return nil
@@ -1908,63 +4777,91 @@ func (o *Object) Update(ctx context.Context, in io.Reader, src fs.ObjectInfo, op
 // Remove deletes the object from FileLu
 func (o *Object) Remove(ctx context.Context) error {
 	fs.Debugf(o.fs, "Remove: Deleting file %q", o.remote)
+	defer o.fs.forgetParentListing(o.remote)
+
+	if o.fs.opt.RootIsDrop {
+		return errFiledrop
+	}
 
 	// Construct full path
-	fullPath := path.Join(o.fs.root, o.remote)
+	fullPath := path.Join(o.fs.Root(), o.remote)
 	if fullPath != "" {
-		fullPath = "/" + strings.Trim(fullPath, "/")
+		fullPath = o.fs.apiPath(fullPath)
 	}
 
-	// Construct the API URL for deletion
-	apiURL := fmt.Sprintf("%s/file/remove?file_path=%s&restore=1&key=%s",
-		o.fs.endpoint,
-		url.QueryEscape(fullPath),
-		url.QueryEscape(o.fs.opt.RcloneKey),
-	)
-
-	fs.Debugf(o.fs, "Remove: Sending delete request to %s", apiURL)
-
-	// Create HTTP request
-	req, err := http.NewRequestWithContext(ctx, "GET", apiURL, nil)
-	if err != nil {
-		return fmt.Errorf("failed to create delete request: %w", err)
+	// Delete by file code when known as several files in a folder may
+	// share the same name
+	fileCode := o.openFileCode()
+	params := url.Values{"file_path": {fullPath}, "restore": {"1"}}
+	if fileCode != "" {
+		params = url.Values{"file_code": {fileCode}, "restore": {"1"}}
 	}
 
-	// Execute request
-	resp, err := o.fs.client.Do(req)
-	if err != nil {
-		return fmt.Errorf("failed to send delete request: %w", err)
+	var result struct {
+		Status int    `json:"status"`
+		Msg    string `json:"msg"`
 	}
-	defer func() {
-		if err := resp.Body.Close(); err != nil {
-			fs.Fatalf(nil, "Failed to close response body: %v", err)
+	ambiguous := false // set if an attempt may have deleted the file without us knowing
+	err := o.fs.pacer.Call(func() (bool, error) {
+		err := o.fs.callAPI(ctx, "/file/remove", params, &result)
+		if err != nil {
+			ambiguous = true
 		}
-	}()
+		return shouldRetry(ctx, err)
+	})
+	if err == nil && result.Status == 200 {
+		o.fs.forgetObject(o.remote)
+		fs.Infof(o.fs, "Successfully deleted file: %s", fullPath)
+		return nil
+	}
+	if err == nil {
+		err = fmt.Errorf("error while deleting file: %s", result.Msg)
+	} else {
+		err = fmt.Errorf("failed to delete file: %w", err)
+	}
+	if !ambiguous {
+		return err
+	}
 
-	// Read and log the full response body for debugging
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return fmt.Errorf("error reading response body: %w", err)
+	// The delete may have succeeded with the response lost, in which
+	// case a retry reports an error, so check whether the file is gone
+	exists, checkErr := o.exists(ctx)
+	if checkErr != nil {
+		fs.Debugf(o, "Remove: failed to check whether file still exists: %v", checkErr)
+		return err
+	}
+	if exists {
+		return err
 	}
-	fs.Debugf(o.fs, "Remove: Response body: %s", string(body))
+	fs.Debugf(o, "Remove: file is gone after ambiguous delete, treating as success: %v", err)
+	o.fs.forgetObject(o.remote)
+	return nil
+}
 
-	// Parse response
-	var result struct {
-		Status int    `json:"status"`
-		Msg    string `json:"msg"`
+// exists checks whether the object is still present in its parent
+// directory, matching on the file code if known
+func (o *Object) exists(ctx context.Context) (bool, error) {
+	dir := path.Dir(o.remote)
+	if dir == "." {
+		dir = ""
+	}
+	entries, err := o.fs.List(ctx, dir)
+	if errors.Is(err, fs.ErrorDirNotFound) {
+		return false, nil
 	}
-	err = json.NewDecoder(bytes.NewReader(body)).Decode(&result)
 	if err != nil {
-		return fmt.Errorf("error decoding delete response: %w", err)
+		return false, err
 	}
-
-	// Check API response status
-	if result.Status != 200 {
-		return fmt.Errorf("error while deleting file: %s", result.Msg)
+	for _, entry := range entries {
+		other, ok := entry.(*Object)
+		if !ok || other.remote != o.remote {
+			continue
+		}
+		if o.fileCode == "" || other.fileCode == o.fileCode {
+			return true, nil
+		}
 	}
-
-	fs.Infof(o.fs, "Successfully deleted file: %s", fullPath)
-	return nil
+	return false, nil
 }
 
 // readMetaData fetches metadata for the object
@@ -2016,162 +4913,58 @@ func (o *Object) readMetaData(ctx context.Context) error {
 	return nil
 }
 
-// FileEntry represents a file entry in the JSON response
-type FileEntry struct {
-	Hash string `json:"hash"`
-}
-
-// APIResponse represents the response from the API.
-type APIResponse struct {
-	Status int `json:"status"`
-	Result struct {
-		Files []FileEntry `json:"files"`
-	} `json:"result"`
-}
-
-// DuplicateFileError is a custom error type for duplicate files
-type DuplicateFileError struct {
-	Hash string
-}
-
-func (e *DuplicateFileError) Error() string {
-	return "Duplicate file skipped."
-}
-
-// IsDuplicateFileError checks if the given error indicates a duplicate file.
-func IsDuplicateFileError(err error) bool {
-	_, ok := err.(*DuplicateFileError)
-	return ok
-}
-
-// FetchRemoteFileHashes retrieves hashes of remote files in a folder
-func (f *Fs) FetchRemoteFileHashes(ctx context.Context, folderID int) (map[string]struct{}, error) {
-	apiURL := fmt.Sprintf("%s/folder/list?fld_id=%d&key=%s", f.endpoint, folderID, url.QueryEscape(f.opt.RcloneKey))
-	fs.Debugf(f, "Fetching remote hashes using URL: %s", apiURL) // Log the API URL for verification
-
-	req, err := http.NewRequestWithContext(ctx, "GET", apiURL, nil)
-	if err != nil {
-		return nil, err
-	}
-
-	resp, err := f.client.Do(req)
-	if err != nil {
-		return nil, err
-	}
-	defer func() {
-		if err := resp.Body.Close(); err != nil {
-			fs.Logf(nil, "Failed to close response body: %v", err.Error())
-		}
-	}()
-
-	// Log raw HTTP response for debugging
-	debugResp, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, fmt.Errorf("error reading response: %w", err)
-	}
-	fs.Debugf(f, "Raw API Response: %s", string(debugResp))
-
-	// Reset the reader for JSON decoding
-	resp.Body = io.NopCloser(bytes.NewBuffer(debugResp))
-	// Define the structure for the API response
-	type APIResponse struct {
-		Status int `json:"status"`
-		Result struct {
-			Files []struct {
-				Hash string `json:"hash"`
-			} `json:"files"`
-		} `json:"result"`
-	}
-
-	// Decode JSON response
-	var apiResponse APIResponse
-	err = json.NewDecoder(resp.Body).Decode(&apiResponse)
-	if err != nil {
-		return nil, fmt.Errorf("error decoding response: %w", err)
-	}
-
-	if apiResponse.Status != 200 {
-		return nil, fmt.Errorf("error: non-200 status %d", apiResponse.Status)
-	}
-
-	hashes := make(map[string]struct{})
-	for _, file := range apiResponse.Result.Files {
-		fs.Debugf(f, "Fetched remote hash: %s", file.Hash) // Log each hash fetched
-		hashes[file.Hash] = struct{}{}
-	}
-
-	fs.Debugf(f, "Total fetched remote hashes: %d", len(hashes))
-	return hashes, nil
+// uploadFile uploads the temporary file at tempPath, which must have been
+// staged with createTempFileFromReader, to the account root under the
+// name of remote, relative to the root, and returns the new file code.
+//
+// If upload_path_field is set the folder remote is going to is sent in
+// that field.
+func (f *Fs) uploadFile(ctx context.Context, uploadURL, sessionID, remote string, tempPath string) (string, error) {
+	fields := f.uploadFields(ctx)
+	fields.Set("sess_id", sessionID)
+	if f.opt.UploadPathField != "" {
+		fields.Set(f.opt.UploadPathField, path.Dir(path.Join("/", f.Root(), remote)))
+	}
+	return f.uploadMultipart(ctx, uploadURL, fields, path.Base(remote), tempPath)
 }
 
-// ComputeMD5 computes the MD5 hash of specified file parts
-func ComputeMD5(filePath string) (string, error) {
-	file, err := os.Open(filePath)
-	if err != nil {
-		return "", fmt.Errorf("failed to open file: %w", err)
-	}
-	defer func() {
-		if err := file.Close(); err != nil {
-			fs.Logf(nil, "Failed to close file: %v", err)
+// uploadFields returns the form fields every upload sends for the type
+// of account, reading the type from the account info the first time if
+// the account_type option doesn't set it.
+//
+// If the type can't be read the account is taken to be premium, as it
+// always was before the option, and the type is read again next time.
+//
+// The lock isn't held while the account info is read, so uploads
+// starting meanwhile may read it too rather than wait.
+func (f *Fs) uploadFields(ctx context.Context) url.Values {
+	f.accountTypeMu.Lock()
+	accountType := f.accountType
+	f.accountTypeMu.Unlock()
+	if accountType == "" {
+		accountType = f.opt.AccountType
+	}
+	if accountType == accountTypeAuto {
+		info, err := f.GetAccountInfo(ctx)
+		if err != nil {
+			fs.Debugf(f, "Can't read account type, assuming premium: %v", err)
+			accountType = accountTypePremium
+		} else {
+			if accountType = accountTypeOf(info.UType); accountType == "" {
+				fs.Logf(f, "Unknown account type %q, assuming premium - set account_type if uploads fail", info.UType)
+				accountType = accountTypePremium
+			}
+			f.accountTypeMu.Lock()
+			f.accountType = accountType
+			f.accountTypeMu.Unlock()
 		}
-	}()
-
-	const partSize = 1024
-	firstPart := make([]byte, partSize)
-	lastPart := make([]byte, partSize)
-
-	// Get file size
-	stat, err := file.Stat()
-	if err != nil {
-		return "", fmt.Errorf("failed to stat file: %w", err)
-	}
-	fileSize := stat.Size()
-
-	// Read first part
-	n, err := io.ReadFull(file, firstPart)
-	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
-		return "", fmt.Errorf("failed to read first part: %w", err)
-	}
-	firstPart = firstPart[:n] // Truncate to actual bytes read
-
-	// If file is smaller than partSize, use only the first part
-	if fileSize <= int64(partSize) {
-		buffer := firstPart
-		hash := md5.Sum(buffer)
-		return base64.RawStdEncoding.EncodeToString(hash[:]), nil
-	}
-
-	// Read last part
-	_, err = file.Seek(-int64(partSize), io.SeekEnd)
-	if err != nil {
-		return "", fmt.Errorf("failed to seek to last part: %w", err)
-	}
-
-	n, err = io.ReadFull(file, lastPart)
-	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
-		return "", fmt.Errorf("failed to read last part: %w", err)
 	}
-	lastPart = lastPart[:n] // Truncate to actual bytes read
-
-	// Combine parts and compute hash
-	buffer := append(firstPart, lastPart...)
-	hash := md5.Sum(buffer)
-	return base64.RawStdEncoding.EncodeToString(hash[:]), nil
+	return url.Values{"utype": {uploadUTypes[accountType]}}
 }
 
-// uploadFile to upload objects from local to remote
-func (f *Fs) uploadFile(ctx context.Context, uploadURL, sessionID, fileName string, fileContent io.Reader) (string, error) {
-	// Create temporary file and get its path
-	tempPath, err := createTempFileFromReader(fileContent)
-	if err != nil {
-		return "", fmt.Errorf("failed to create temp file: %w", err)
-	}
-	defer func() {
-		if err := os.Remove(tempPath); err != nil {
-			fs.Logf(nil, "Failed to remove temp file %q: %v", tempPath, err)
-		}
-	}()
-
+// uploadMultipart posts the file at tempPath to uploadURL as fileName
+// along with the form fields and returns the new file code
+func (f *Fs) uploadMultipart(ctx context.Context, uploadURL string, fields url.Values, fileName string, tempPath string) (string, error) {
 	// Open the temporary file for the multipart upload
 	file, err := os.Open(tempPath)
 	if err != nil {
@@ -2188,11 +4981,17 @@ func (f *Fs) uploadFile(ctx context.Context, uploadURL, sessionID, fileName stri
 	writer := multipart.NewWriter(&body)
 
 	// Add form fields
-	if err = writer.WriteField("sess_id", sessionID); err != nil {
-		return "", fmt.Errorf("failed to add sess_id field: %w", err)
-	}
-	if err = writer.WriteField("utype", "prem"); err != nil {
-		return "", fmt.Errorf("failed to add utype field: %w", err)
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		for _, value := range fields[key] {
+			if err = writer.WriteField(key, value); err != nil {
+				return "", fmt.Errorf("failed to add %s field: %w", key, err)
+			}
+		}
 	}
 
 	// Create the file part
@@ -2236,101 +5035,145 @@ func (f *Fs) uploadFile(ctx context.Context, uploadURL, sessionID, fileName stri
 		return "", fmt.Errorf("failed to parse response: %w", err)
 	}
 
-	if len(result) == 0 || result[0].FileStatus != "OK" {
+	if len(result) == 0 {
+		return "", errors.New("upload failed: empty response")
+	}
+	fileCode := result[0].FileCode
+
+	switch status := strings.ToLower(result[0].FileStatus); status {
+	case uploadStatusOK:
+	case uploadStatusDuplicate, uploadStatusExists:
+		// FileLu kept the file it already had, which is just as good
+		if fileCode == "" {
+			return "", fmt.Errorf("upload failed with status %q and no file code", result[0].FileStatus)
+		}
+		fs.Debugf(f, "uploadFile: File is a duplicate of existing file code: %s", fileCode)
+	case uploadStatusPending, uploadStatusProcessing, uploadStatusQueued:
+		if fileCode == "" {
+			return "", fmt.Errorf("upload failed with status %q and no file code", result[0].FileStatus)
+		}
+		if err := f.waitUploadReady(ctx, fileCode); err != nil {
+			return "", err
+		}
+	default:
+		if key := rejectedField(status, fields); key != "" {
+			// Sending the same fields again won't help
+			return "", fserrors.NoRetryError(fmt.Errorf("upload rejected the %s field %q: %s", key, fields.Get(key), result[0].FileStatus))
+		}
 		return "", fmt.Errorf("upload failed with status: %s", result[0].FileStatus)
 	}
 
-	fs.Debugf(f, "uploadFile: File uploaded successfully with file code: %s", result[0].FileCode)
-	return result[0].FileCode, nil
+	fs.Debugf(f, "uploadFile: File uploaded successfully with file code: %s", fileCode)
+	return fileCode, nil
 }
 
-// Hash returns the MD5 hash of an object
-func (o *Object) Hash(ctx context.Context, t hash.Type) (string, error) {
-	if t != hash.MD5 {
-		return "", hash.ErrUnsupported
-	}
-
-	var fileCode string
+// The values of file_status returned by an upload
+const (
+	uploadStatusOK         = "ok"         // the file was stored
+	uploadStatusDuplicate  = "duplicate"  // the file is already there, file_code is the existing file
+	uploadStatusExists     = "exists"     // as duplicate
+	uploadStatusPending    = "pending"    // the file is still being processed
+	uploadStatusProcessing = "processing" // as pending
+	uploadStatusQueued     = "queued"     // as pending
+)
 
-	// Function to check if the extracted code is a valid file code (non-numeric and 12 characters long)
-	isValidFileCode := func(code string) bool {
-		if len(code) != 12 {
-			return false
+// waitUploadReady polls file/info every upload_poll_interval until the
+// upload with fileCode, which was reported as pending, is ready, giving
+// up after upload_poll_timeout.
+//
+// The file is ready once file/info knows it and doesn't report it as
+// pending itself.
+func (f *Fs) waitUploadReady(ctx context.Context, fileCode string) error {
+	limit := time.Duration(f.opt.PollTimeout)
+	if limit <= 0 {
+		return nil
+	}
+	deadline := time.Now().Add(limit)
+	for try := 1; ; try++ {
+		var result api.FileInfoResponse
+		err := f.pacer.Call(func() (bool, error) {
+			err := f.callAPI(ctx, "/file/info", url.Values{"file_code": {fileCode}}, &result)
+			return shouldRetry(ctx, err)
+		})
+		if err != nil {
+			return fmt.Errorf("failed to check pending upload: %w", err)
 		}
-		// Check if the code contains any non-numeric character
-		for _, c := range code {
-			if c < '0' || c > '9' {
-				return true // Alphanumeric (contains at least one non-numeric character)
+		state := result.Msg
+		if result.Status == 200 && len(result.Result) > 0 {
+			state = result.Result[0].FileStatus
+			if !isPendingUpload(state) {
+				return nil
 			}
 		}
-		return false // It's purely numeric, not a file code
-	}
-
-	// Extract file code directly if available, otherwise from the remote path
-	if isFileCode(o.fs.root) {
-		fileCode = o.fs.root
-	} else {
-		// Attempt to extract file code from the remote path
-		remote := o.remote
-		// Find all substrings inside parentheses
-		matches := regexp.MustCompile(`\((.*?)\)`).FindAllStringSubmatch(remote, -1)
-
-		// Loop through all matched substrings and check for a valid file code
-		for _, match := range matches {
-			if len(match) > 1 {
-				extractedCode := match[1]
-				if isValidFileCode(extractedCode) {
-					fileCode = extractedCode
-					break // Found a valid file code, no need to continue
-				}
-			}
+		if !time.Now().Before(deadline) {
+			return fmt.Errorf("upload of file code %s still pending after %v: %s", fileCode, limit, state)
+		}
+		fs.Debugf(f, "uploadFile: waiting for pending upload %s (%d): %s", fileCode, try, state)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Duration(f.opt.PollInterval)):
 		}
 	}
+}
 
-	// If no valid file code was found, return an error
-	if fileCode == "" {
-		return "", fmt.Errorf("no valid file code found in the remote path")
+// isPendingUpload returns whether status, the file_status of an upload
+// or of file/info, says FileLu is still processing the file
+func isPendingUpload(status string) bool {
+	switch strings.ToLower(status) {
+	case uploadStatusPending, uploadStatusProcessing, uploadStatusQueued:
+		return true
 	}
+	return false
+}
 
-	// Use the file_code for API queries
-	apiURL := fmt.Sprintf("%s/file/info?file_code=%s&key=%s",
-		o.fs.endpoint, url.QueryEscape(fileCode), url.QueryEscape(o.fs.opt.RcloneKey))
-
-	req, err := http.NewRequestWithContext(ctx, "GET", apiURL, nil)
-	if err != nil {
-		return "", fmt.Errorf("failed to create hash request: %w", err)
+// Hash returns the hash of an object, of the type FileLu reports
+func (o *Object) Hash(ctx context.Context, t hash.Type) (string, error) {
+	if t != o.fs.hashType || o.fs.opt.DisableChecksum {
+		return "", hash.ErrUnsupported
 	}
 
-	resp, err := o.fs.client.Do(req)
-	if err != nil {
-		return "", fmt.Errorf("hash request failed: %w", err)
+	// The listing may already have told us the hash
+	if o.hash != "" {
+		return o.hash, nil
 	}
-	defer func() {
-		if err := resp.Body.Close(); err != nil {
-			fs.Fatalf(nil, "Failed to close response body: %v", err)
-		}
-	}()
 
-	var result struct {
-		Status int    `json:"status"`
-		Msg    string `json:"msg"`
-		Result []struct {
-			Hash string `json:"hash"` // Assuming the hash is here
-		} `json:"result"`
-	}
-	err = json.NewDecoder(resp.Body).Decode(&result)
-	if err != nil {
-		return "", fmt.Errorf("error decoding hash response: %w", err)
+	fileCode := o.openFileCode()
+	if fileCode == "" {
+		var err error
+		fileCode, err = o.fs.findFileCode(ctx, o.remote)
+		if err != nil {
+			return "", fmt.Errorf("failed to find file code: %w", err)
+		}
 	}
 
-	if result.Status != 200 || len(result.Result) == 0 {
-		return "", fmt.Errorf("error: unable to fetch hash: %s", result.Msg)
+	info, err := o.fs.readFileInfo(ctx, fileCode)
+	if err != nil {
+		return "", fmt.Errorf("unable to fetch hash: %w", err)
 	}
-
-	return result.Result[0].Hash, nil
+	return info.Hash, nil
 }
 
 // String returns a string representation of the object
 func (o *Object) String() string {
 	return o.remote
 }
+
+// Check the interfaces are satisfied
+var (
+	_ fs.Fs              = (*Fs)(nil)
+	_ fs.Copier          = (*Fs)(nil)
+	_ fs.Mover           = (*Fs)(nil)
+	_ fs.Purger          = (*Fs)(nil)
+	_ fs.ListRer         = (*Fs)(nil)
+	_ fs.Abouter         = (*Fs)(nil)
+	_ fs.Commander       = (*Fs)(nil)
+	_ fs.OpenWriterAter  = (*Fs)(nil)
+	_ fs.DirCacheFlusher = (*Fs)(nil)
+	_ dircache.DirCacher = (*Fs)(nil)
+	_ fs.Object          = (*Object)(nil)
+	_ fs.IDer            = (*Object)(nil)
+	_ fs.MimeTyper       = (*Object)(nil)
+	_ fs.RangeSeeker     = (*rangeReader)(nil)
+	_ io.ReadSeekCloser  = (*rangeReader)(nil)
+)
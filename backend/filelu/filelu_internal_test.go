@@ -0,0 +1,3547 @@
+package filelu
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/md5"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/rclone/rclone/backend/filelu/api"
+	_ "github.com/rclone/rclone/backend/memory"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/accounting"
+	"github.com/rclone/rclone/fs/config/configmap"
+	"github.com/rclone/rclone/fs/config/configstruct"
+	"github.com/rclone/rclone/fs/fserrors"
+	"github.com/rclone/rclone/fs/fshttp"
+	"github.com/rclone/rclone/fs/hash"
+	"github.com/rclone/rclone/fs/object"
+	"github.com/rclone/rclone/fs/operations"
+	fssync "github.com/rclone/rclone/fs/sync"
+	"github.com/rclone/rclone/lib/dircache"
+	"github.com/rclone/rclone/lib/pacer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestFs returns an Fs rooted at root which talks to handler
+func newTestFs(t *testing.T, root string, handler http.Handler) *Fs {
+	return newTestFsOpt(t, root, nil, handler)
+}
+
+// newTestFsOpt returns an Fs rooted at root which talks to handler with
+// the default options overridden by config
+func newTestFsOpt(t *testing.T, root string, config configmap.Simple, handler http.Handler) *Fs {
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+
+	ri, err := fs.Find("filelu")
+	require.NoError(t, err)
+	// Uploads needn't read the account type unless a test asks them to
+	m := configmap.Simple{"FileLu Rclone Key": "key", "account_type": accountTypePremium}
+	for k, v := range config {
+		m[k] = v
+	}
+	var opt Options
+	require.NoError(t, configstruct.Set(fs.ConfigMap(ri.Prefix, ri.Options, "", m), &opt))
+
+	f := &Fs{
+		name:     "TestFileLu",
+		root:     root,
+		opt:      opt,
+		endpoint: srv.URL,
+		client:   fshttp.NewClient(context.Background()),
+		pacer:    fs.NewPacer(context.Background(), pacer.NewDefault(pacer.MinSleep(time.Millisecond), pacer.MaxSleep(time.Millisecond))),
+		hashType: hash.MD5,
+	}
+	f.dirCache = dircache.New("", rootFolderID, f)
+	f.features = (&fs.Features{CanHaveEmptyDirectories: true}).Fill(context.Background(), f)
+	return f
+}
+
+// writeJSON writes v as the JSON response
+func writeJSON(t *testing.T, w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	require.NoError(t, json.NewEncoder(w).Encode(v))
+}
+
+func TestCreateTempFileFromReader(t *testing.T) {
+	content := strings.Repeat("hello world ", 500)
+
+	tempPath, streamed, err := createTempFileFromReader("", "", strings.NewReader(content), hash.MD5)
+	require.NoError(t, err)
+	defer func() {
+		assert.NoError(t, os.Remove(tempPath))
+	}()
+
+	// The file must be readable by name straight away
+	got, err := localMD5(tempPath)
+	require.NoError(t, err)
+	sum := md5.Sum([]byte(content))
+	assert.Equal(t, fmt.Sprintf("%x", sum), got)
+	assert.Equal(t, got, streamed)
+
+	// And removable, which fails on Windows if a handle is still open
+	info, err := os.Stat(tempPath)
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(content)), info.Size())
+}
+
+func TestCreateTempFileFromReaderHash(t *testing.T) {
+	content := make([]byte, 5<<20)
+	for i := range content {
+		content[i] = byte(i % 251)
+	}
+	for _, test := range []struct {
+		hashType hash.Type
+		want     string
+	}{
+		{hash.MD5, "4c28640dc8df1933aaea192100d50ae0"},
+		{hash.SHA1, "4419108489f30c94a14b667ce4e51fcc62ad8b19"},
+		{hash.None, ""},
+	} {
+		tempPath, sum, err := createTempFileFromReader("", "", bytes.NewReader(content), test.hashType)
+		require.NoError(t, err)
+		assert.Equal(t, test.want, sum, test.hashType.String())
+		assert.NoError(t, os.Remove(tempPath))
+	}
+}
+
+func TestCreateTempFileFromReaderError(t *testing.T) {
+	_, _, err := createTempFileFromReader("", "", io.MultiReader(strings.NewReader("partial"), errReader{}), hash.MD5)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to copy data to temp file")
+}
+
+func TestTempDir(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	f, _ := newMockFs(t, "", configmap.Simple{"temp_dir": dir})
+
+	// tempFiles returns the names and permissions of the files in dir
+	tempFiles := func() map[string]os.FileMode {
+		entries, err := os.ReadDir(dir)
+		require.NoError(t, err)
+		files := map[string]os.FileMode{}
+		for _, entry := range entries {
+			info, err := entry.Info()
+			require.NoError(t, err)
+			files[entry.Name()] = info.Mode().Perm()
+		}
+		return files
+	}
+
+	// Look at the temp file while the upload is being staged
+	var staged map[string]os.FileMode
+	in := io.MultiReader(strings.NewReader("secret "), readerFunc(func(p []byte) (int, error) {
+		staged = tempFiles()
+		return copy(p, "data"), io.EOF
+	}))
+	src := object.NewStaticObjectInfo("file.txt", time.Now(), -1, true, nil, nil)
+	_, err := f.Put(ctx, in, src)
+	require.NoError(t, err)
+	require.Len(t, staged, 1)
+	for name, perm := range staged {
+		assert.True(t, strings.HasPrefix(name, "upload-file.txt-"), name)
+		if runtime.GOOS != "windows" {
+			assert.Equal(t, os.FileMode(0600), perm)
+		}
+	}
+	assert.Empty(t, tempFiles())
+
+	// It is removed when the upload fails too
+	_, err = f.Put(ctx, io.MultiReader(strings.NewReader("partial"), errReader{}), src)
+	require.Error(t, err)
+	assert.Empty(t, tempFiles())
+
+	// and when writing with OpenWriterAt
+	w, err := f.OpenWriterAt(ctx, "written.txt", 4)
+	require.NoError(t, err)
+	assert.Len(t, tempFiles(), 1)
+	_, err = w.WriteAt([]byte("data"), 0)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	assert.Empty(t, tempFiles())
+
+	// A temp_dir which isn't there is an error
+	_, err = NewFs(ctx, "TestFileLu", "", configmap.Simple{
+		"FileLu Rclone Key": "key",
+		"size_method":       "listing",
+		"list_order":        "name",
+		"overwrite":         "replace",
+		"mirror_selection":  "first",
+		"temp_dir":          filepath.Join(dir, "missing"),
+	})
+	assert.ErrorContains(t, err, "temp_dir")
+}
+
+// readerFunc is an io.Reader calling itself to read
+type readerFunc func(p []byte) (int, error)
+
+func (fn readerFunc) Read(p []byte) (int, error) { return fn(p) }
+
+type errReader struct{}
+
+func (errReader) Read([]byte) (int, error) { return 0, io.ErrClosedPipe }
+
+func TestUploadFileFromTempPath(t *testing.T) {
+	const content = "uploaded content"
+	f := newTestFs(t, "", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		file, header, err := r.FormFile("file_0")
+		require.NoError(t, err)
+		data, err := io.ReadAll(file)
+		require.NoError(t, err)
+		assert.Equal(t, "file.txt", header.Filename)
+		assert.Equal(t, content, string(data))
+		assert.Equal(t, "sess", r.FormValue("sess_id"))
+		writeJSON(t, w, []map[string]string{{"file_code": "abcdefghijkl", "file_status": "OK"}})
+	}))
+
+	tempPath, _, err := createTempFileFromReader("", "", strings.NewReader(content), hash.None)
+	require.NoError(t, err)
+	defer func() {
+		assert.NoError(t, os.Remove(tempPath))
+	}()
+
+	fileCode, err := f.uploadFile(context.Background(), f.endpoint, "sess", "file.txt", tempPath)
+	require.NoError(t, err)
+	assert.Equal(t, "abcdefghijkl", fileCode)
+}
+
+func TestUploadPathField(t *testing.T) {
+	var form url.Values
+	f := newTestFs(t, "base", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, r.ParseMultipartForm(1<<20))
+		form = url.Values(r.MultipartForm.Value)
+		writeJSON(t, w, []map[string]string{{"file_code": "abcdefghijkl", "file_status": "OK"}})
+	}))
+
+	tempPath, _, err := createTempFileFromReader("", "", strings.NewReader("content"), hash.None)
+	require.NoError(t, err)
+	defer func() {
+		assert.NoError(t, os.Remove(tempPath))
+	}()
+
+	// Not sent unless asked for
+	_, err = f.uploadFile(context.Background(), f.endpoint, "sess", "dir/file.txt", tempPath)
+	require.NoError(t, err)
+	assert.NotContains(t, form, "fld_path")
+
+	// The folder is from the account root
+	f.opt.UploadPathField = "fld_path"
+	for remote, want := range map[string]string{
+		"dir/file.txt":     "/base/dir",
+		"dir/sub/file.txt": "/base/dir/sub",
+		"file.txt":         "/base",
+	} {
+		_, err = f.uploadFile(context.Background(), f.endpoint, "sess", remote, tempPath)
+		require.NoError(t, err)
+		assert.Equal(t, want, form.Get("fld_path"), remote)
+	}
+}
+
+func TestDedupe(t *testing.T) {
+	listings := map[string]interface{}{
+		"": map[string]interface{}{
+			"files": []map[string]interface{}{
+				{"name": "a.txt", "file_code": "aaaaaaaaaaaa", "hash": "h1", "uploaded": "2024-01-01 10:00:00"},
+				{"name": "b.txt", "file_code": "bbbbbbbbbbbb", "hash": "h1", "uploaded": "2024-01-03 10:00:00"},
+				{"name": "c.txt", "file_code": "cccccccccccc", "hash": "h2", "uploaded": "2024-01-01 10:00:00"},
+			},
+			"folders": []map[string]interface{}{{"name": "sub", "fld_id": 1}},
+		},
+		"/sub": map[string]interface{}{
+			"files": []map[string]interface{}{
+				{"name": "d.txt", "file_code": "dddddddddddd", "hash": "h1", "uploaded": "2024-01-02 10:00:00"},
+				{"name": "e.txt", "file_code": "eeeeeeeeeeee", "hash": "h2", "uploaded": "2024-01-05 10:00:00"},
+				{"name": "f.txt", "file_code": "ffffffffffff", "hash": "h3", "uploaded": "2024-01-05 10:00:00"},
+			},
+		},
+	}
+	for _, test := range []struct {
+		mode    string
+		removed []string
+	}{
+		{mode: "newest", removed: []string{"aaaaaaaaaaaa", "dddddddddddd", "cccccccccccc"}},
+		{mode: "oldest", removed: []string{"dddddddddddd", "bbbbbbbbbbbb", "eeeeeeeeeeee"}},
+		{mode: "list", removed: nil},
+	} {
+		t.Run(test.mode, func(t *testing.T) {
+			var removed []string
+			f := newTestFs(t, "", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch r.URL.Path {
+				case "/folder/list":
+					writeJSON(t, w, map[string]interface{}{"status": 200, "result": listings[r.FormValue("folder_path")]})
+				case "/file/info":
+					writeJSON(t, w, map[string]interface{}{"status": 200, "result": []map[string]string{{"size": "10"}}})
+				case "/file/remove":
+					removed = append(removed, r.FormValue("file_code"))
+					writeJSON(t, w, map[string]interface{}{"status": 200})
+				default:
+					t.Errorf("unexpected request %q", r.URL.Path)
+				}
+			}))
+
+			out, err := f.Command(context.Background(), "dedupe", nil, map[string]string{"mode": test.mode})
+			require.NoError(t, err)
+			assert.Equal(t, test.removed, removed)
+
+			groups := out.([]dedupeGroup)
+			require.Len(t, groups, 2)
+			assert.Equal(t, "h1", groups[0].Hash)
+			assert.Equal(t, "h2", groups[1].Hash)
+			switch test.mode {
+			case "newest":
+				assert.Equal(t, "b.txt", groups[0].Kept)
+				assert.Equal(t, "sub/e.txt", groups[1].Kept)
+			case "oldest":
+				assert.Equal(t, "a.txt", groups[0].Kept)
+				assert.Equal(t, "c.txt", groups[1].Kept)
+			case "list":
+				assert.Equal(t, []string{"a.txt", "sub/d.txt"}, groups[0].Removed)
+			}
+		})
+	}
+}
+
+func TestDedupeHashOnList(t *testing.T) {
+	ctx := context.Background()
+	for _, hashOnList := range []string{"true", "false"} {
+		t.Run(hashOnList, func(t *testing.T) {
+			f, m := newMockFs(t, "", configmap.Simple{"hash_on_list": hashOnList})
+			m.addFile("one.txt", "same")
+			m.addFile("dir/two.txt", "same")
+
+			out, err := f.Command(ctx, "dedupe", nil, map[string]string{"mode": "list"})
+			require.NoError(t, err)
+			groups := out.([]dedupeGroup)
+			require.Len(t, groups, 1)
+			assert.Equal(t, fmt.Sprintf("%x", md5.Sum([]byte("same"))), groups[0].Hash)
+
+			// The hashes only need reading if the listing didn't keep them
+			wantInfo := 0
+			if hashOnList == "false" {
+				wantInfo = 2
+			}
+			assert.Equal(t, wantInfo, m.callCount("/file/info"))
+		})
+	}
+}
+
+func TestAbout(t *testing.T) {
+	for _, test := range []struct {
+		name        string
+		storage     string
+		storageUsed string
+		total       int64
+		used        int64
+		free        int64
+	}{
+		{name: "units", storage: "10 GB", storageUsed: "512 MB", total: 10 << 30, used: 512 << 20, free: 10<<30 - 512<<20},
+		{name: "bare", storage: "10", storageUsed: "1073741824", total: 10 << 30, used: 1 << 30, free: 9 << 30},
+		{name: "bare small used", storage: "10", storageUsed: "500000", total: 10 << 30, used: 500000, free: 10<<30 - 500000},
+		{name: "unparsable used", storage: "10 GB", storageUsed: "n/a", total: 10 << 30, used: -1, free: -1},
+		{name: "unparsable both", storage: "unlimited", storageUsed: "n/a", total: -1, used: -1, free: -1},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			f := newTestFs(t, "", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				assert.Equal(t, "/account/info", r.URL.Path)
+				writeJSON(t, w, map[string]interface{}{
+					"status": 200,
+					"result": map[string]string{"storage": test.storage, "storage_used": test.storageUsed},
+				})
+			}))
+			usage, err := f.About(context.Background())
+			require.NoError(t, err)
+			check := func(want int64, got *int64) {
+				if want < 0 {
+					assert.Nil(t, got)
+				} else if assert.NotNil(t, got) {
+					assert.Equal(t, want, *got)
+				}
+			}
+			check(test.total, usage.Total)
+			check(test.used, usage.Used)
+			check(test.free, usage.Free)
+			assert.Nil(t, usage.Objects)
+		})
+	}
+}
+
+func TestAboutObjects(t *testing.T) {
+	ctx := context.Background()
+	var calls atomic.Int32
+	f := newTestFs(t, "", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/account/info", r.URL.Path)
+		calls.Add(1)
+		writeJSON(t, w, map[string]interface{}{
+			"status": 200,
+			"result": map[string]string{"storage": "10 GB", "storage_used": "1 GB", "total_files": "42"},
+		})
+	}))
+
+	usage, err := f.About(ctx)
+	require.NoError(t, err)
+	require.NotNil(t, usage.Objects)
+	assert.Equal(t, int64(42), *usage.Objects)
+
+	// The usage is reused until it expires
+	usage, err = f.About(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(42), *usage.Objects)
+	assert.Equal(t, int32(1), calls.Load())
+
+	f.usageExpires = time.Now()
+	_, err = f.About(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int32(2), calls.Load())
+}
+
+func TestListMissingArrays(t *testing.T) {
+	ctx := context.Background()
+	file := `{"name": "file.txt", "file_code": "aaaaaaaaaaaa", "size": 3}`
+	folder := `{"name": "dir", "fld_id": 2}`
+	for _, test := range []struct {
+		name        string
+		result      string
+		wantFiles   int
+		wantFolders int
+	}{
+		{"files only", `{"files": [` + file + `]}`, 1, 0},
+		{"folders only", `{"folders": [` + folder + `]}`, 0, 1},
+		{"neither", `{}`, 0, 0},
+		{"null arrays", `{"files": null, "folders": null}`, 0, 0},
+		{"empty result", `[]`, 0, 0},
+		{"no result", ``, 0, 0},
+	} {
+		body := `{"status": 200, "msg": "OK", "result": ` + test.result + `}`
+		if test.result == "" {
+			body = `{"status": 200, "msg": "OK"}`
+		}
+		var deleted bool
+		f := newTestFs(t, "", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/folder/list":
+				w.Header().Set("Content-Type", "application/json")
+				_, _ = io.WriteString(w, body)
+			case "/folder/delete":
+				deleted = true
+				writeJSON(t, w, map[string]interface{}{"status": 200})
+			default:
+				t.Errorf("unexpected request %q", r.URL.Path)
+			}
+		}))
+
+		entries, err := f.List(ctx, "dir")
+		require.NoError(t, err, test.name)
+		var files, folders int
+		for _, entry := range entries {
+			if _, ok := entry.(fs.Directory); ok {
+				folders++
+			} else {
+				files++
+			}
+		}
+		assert.Equal(t, test.wantFiles, files, test.name)
+		assert.Equal(t, test.wantFolders, folders, test.name)
+
+		subfolders, err := f.listFolders(ctx, "1")
+		require.NoError(t, err, test.name)
+		assert.Len(t, subfolders, test.wantFolders, test.name)
+
+		// Only a folder with neither files nor folders is empty
+		err = f.Rmdir(ctx, "dir")
+		if test.wantFiles+test.wantFolders == 0 {
+			require.NoError(t, err, test.name)
+			assert.True(t, deleted, test.name)
+		} else {
+			assert.ErrorContains(t, err, "not empty", test.name)
+			assert.False(t, deleted, test.name)
+		}
+	}
+}
+
+func TestListFolderSize(t *testing.T) {
+	ctx := context.Background()
+	f := newTestFs(t, "", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(t, w, map[string]interface{}{"status": 200, "result": map[string]interface{}{
+			"folders": []map[string]interface{}{
+				{"name": "number", "fld_id": 1, "size": 1234},
+				{"name": "string", "fld_id": 2, "size": "5678"},
+				{"name": "missing", "fld_id": 3},
+				{"name": "text", "fld_id": 4, "size": "1.2 GB"},
+			},
+		}})
+	}))
+	entries, err := f.List(ctx, "")
+	require.NoError(t, err)
+	sizes := map[string]int64{}
+	for _, entry := range entries {
+		sizes[entry.Remote()] = entry.Size()
+	}
+	assert.Equal(t, map[string]int64{"number": 1234, "string": 5678, "missing": -1, "text": -1}, sizes)
+}
+
+func TestCallAPIGzip(t *testing.T) {
+	ctx := context.Background()
+	names := make([]string, 1000)
+	for i := range names {
+		names[i] = fmt.Sprintf("folder %04d", i)
+	}
+	var acceptEncoding atomic.Value
+	f := newTestFs(t, "", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		acceptEncoding.Store(r.Header.Get("Accept-Encoding"))
+		folders := make([]map[string]interface{}, len(names))
+		for i, name := range names {
+			folders[i] = map[string]interface{}{"fld_id": i + 1, "name": name}
+		}
+		body := map[string]interface{}{"status": 200, "result": map[string]interface{}{"folders": folders}}
+		if !strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
+			writeJSON(t, w, body)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Encoding", "gzip")
+		gz := gzip.NewWriter(w)
+		require.NoError(t, json.NewEncoder(gz).Encode(body))
+		require.NoError(t, gz.Close())
+	}))
+
+	folders, err := f.listFolders(ctx, "0")
+	require.NoError(t, err)
+	assert.Equal(t, "gzip, deflate", acceptEncoding.Load())
+	require.Len(t, folders, len(names))
+	assert.Equal(t, names[999], folders[999].Name)
+
+	f.opt.NoGzip = true
+	folders, err = f.listFolders(ctx, "0")
+	require.NoError(t, err)
+	assert.Equal(t, "identity", acceptEncoding.Load())
+	assert.Len(t, folders, len(names))
+}
+
+func TestCallAPIDump(t *testing.T) {
+	f := newTestFs(t, "", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/folder/list", r.URL.Path)
+		assert.Equal(t, "/dir", r.FormValue("folder_path"))
+		assert.Equal(t, "key", r.FormValue("key"))
+		writeJSON(t, w, map[string]interface{}{"status": 200, "result": map[string]interface{}{}})
+	}))
+
+	// Capture the debug log with --dump headers enabled
+	ctx, ci := fs.AddConfig(context.Background())
+	ci.Dump = fs.DumpHeaders
+	f.client = fshttp.NewClient(ctx)
+	gci := fs.GetConfig(context.Background())
+	oldLogLevel, oldLogOutput := gci.LogLevel, fs.LogOutput
+	var (
+		mu  sync.Mutex
+		out strings.Builder
+	)
+	gci.LogLevel = fs.LogLevelDebug
+	fs.LogOutput = func(level fs.LogLevel, text string) {
+		mu.Lock()
+		defer mu.Unlock()
+		out.WriteString(text + "\n")
+	}
+	defer func() {
+		gci.LogLevel, fs.LogOutput = oldLogLevel, oldLogOutput
+	}()
+
+	var result struct {
+		Status int `json:"status"`
+	}
+	require.NoError(t, f.callAPI(ctx, "/folder/list", url.Values{"folder_path": {"/dir"}}, &result))
+	assert.Equal(t, 200, result.Status)
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Contains(t, out.String(), "HTTP REQUEST")
+	assert.Contains(t, out.String(), "GET /folder/list?")
+	assert.Contains(t, out.String(), "HTTP RESPONSE")
+}
+
+// updateTestHandler serves a single flat root folder holding files
+type updateTestHandler struct {
+	t       *testing.T
+	mu      sync.Mutex
+	srvURL  string
+	files   map[string]string // file code => name
+	content map[string]string // file code => uploaded content
+	nextID  int
+	removed []string
+}
+
+func (h *updateTestHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	switch r.URL.Path {
+	case "/folder/list":
+		files := []map[string]interface{}{}
+		for code, name := range h.files {
+			files = append(files, map[string]interface{}{"name": name, "file_code": code})
+		}
+		writeJSON(h.t, w, map[string]interface{}{"status": 200, "result": map[string]interface{}{"files": files}})
+	case "/file/info":
+		writeJSON(h.t, w, map[string]interface{}{"status": 200, "result": []map[string]string{{"size": "1"}}})
+	case "/upload/server":
+		writeJSON(h.t, w, map[string]interface{}{"status": 200, "sess_id": "sess", "result": h.srvURL + "/upload"})
+	case "/upload":
+		file, header, err := r.FormFile("file_0")
+		require.NoError(h.t, err)
+		data, err := io.ReadAll(file)
+		require.NoError(h.t, err)
+		h.nextID++
+		code := strings.Repeat(string(rune('m'+h.nextID)), 12)
+		h.files[code] = header.Filename
+		if h.content == nil {
+			h.content = map[string]string{}
+		}
+		h.content[code] = string(data)
+		writeJSON(h.t, w, []map[string]string{{"file_code": code, "file_status": "OK"}})
+	case "/file/remove":
+		code := r.FormValue("file_code")
+		h.removed = append(h.removed, code)
+		delete(h.files, code)
+		writeJSON(h.t, w, map[string]interface{}{"status": 200})
+	default:
+		h.t.Errorf("unexpected request %q", r.URL.Path)
+	}
+}
+
+func TestUpdateReplacesExisting(t *testing.T) {
+	ctx := context.Background()
+	for _, test := range []struct {
+		name     string
+		existing map[string]string
+		removed  []string
+	}{
+		{name: "existing", existing: map[string]string{"aaaaaaaaaaaa": "file.txt", "bbbbbbbbbbbb": "other.txt"}, removed: []string{"aaaaaaaaaaaa"}},
+		{name: "missing", existing: map[string]string{"bbbbbbbbbbbb": "other.txt"}, removed: nil},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			h := &updateTestHandler{t: t, files: test.existing}
+			f := newTestFs(t, "", h)
+			h.srvURL = f.endpoint
+
+			o := &Object{fs: f, remote: "file.txt"}
+			src := object.NewStaticObjectInfo("file.txt", time.Now(), 7, true, nil, nil)
+			require.NoError(t, o.Update(ctx, strings.NewReader("content"), src))
+
+			assert.Equal(t, test.removed, h.removed)
+			assert.Equal(t, map[string]string{"nnnnnnnnnnnn": "file.txt", "bbbbbbbbbbbb": "other.txt"}, h.files)
+			assert.Equal(t, "nnnnnnnnnnnn", o.fileCode)
+		})
+	}
+}
+
+func TestUpdateNested(t *testing.T) {
+	ctx := context.Background()
+	var (
+		srvURL   string
+		moves    []string
+		removed  []string
+		uploaded string
+	)
+	folders := map[string][]map[string]interface{}{
+		"0": {{"name": "top", "fld_id": 1}},
+		"1": {{"name": "sub", "fld_id": 2}},
+		"2": {{"name": "dir", "fld_id": 3}},
+	}
+	f := newTestFs(t, "top", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		switch r.URL.Path {
+		case "/folder/list":
+			writeJSON(t, w, map[string]interface{}{"status": 200, "result": map[string]interface{}{"folders": folders[q.Get("fld_id")]}})
+		case "/upload/server":
+			writeJSON(t, w, map[string]interface{}{"status": 200, "sess_id": "sess", "result": srvURL + "/upload"})
+		case "/upload":
+			file, header, err := r.FormFile("file_0")
+			require.NoError(t, err)
+			data, err := io.ReadAll(file)
+			require.NoError(t, err)
+			uploaded = header.Filename + "=" + string(data)
+			writeJSON(t, w, []map[string]string{{"file_code": "nnnnnnnnnnnn", "file_status": "OK"}})
+		case "/file/set_folder":
+			moves = append(moves, q.Get("file_code")+" -> "+q.Get("destination_folder_path"))
+			writeJSON(t, w, map[string]interface{}{"status": 200})
+		case "/file/remove":
+			removed = append(removed, q.Get("file_code"))
+			writeJSON(t, w, map[string]interface{}{"status": 200})
+		case "/file/info":
+			assert.Equal(t, "oooooooooooo", q.Get("file_code"))
+			writeJSON(t, w, map[string]interface{}{"status": 200, "result": []map[string]string{{
+				"file_code": "oooooooooooo", "name": "file.txt", "size": "3", "hash": fmt.Sprintf("%x", md5.Sum([]byte("old"))),
+			}}})
+		default:
+			t.Errorf("unexpected request %q", r.URL.Path)
+		}
+	}))
+	srvURL = f.endpoint
+
+	o := &Object{fs: f, remote: "sub/dir/file.txt", fileCode: "oooooooooooo"}
+	src := object.NewStaticObjectInfo(o.remote, time.Now(), 3, true, nil, nil)
+	require.NoError(t, o.Update(ctx, strings.NewReader("new"), src))
+
+	assert.Equal(t, "file.txt=new", uploaded)
+	assert.Equal(t, []string{"nnnnnnnnnnnn -> /top/sub/dir"}, moves)
+	assert.Equal(t, []string{"oooooooooooo"}, removed)
+	assert.Equal(t, "nnnnnnnnnnnn", o.fileCode)
+	assert.Equal(t, "sub/dir/file.txt", o.remote)
+}
+
+func TestPutSkipsIdentical(t *testing.T) {
+	ctx := context.Background()
+	head := strings.Repeat("a", 1024)
+	tail := strings.Repeat("z", 1024)
+	existing := head + strings.Repeat("m", 4096) + tail
+	existingSum := md5.Sum([]byte(existing))
+	var (
+		srvURL  string
+		uploads []string
+		removed []string
+	)
+	f := newTestFs(t, "", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/folder/list":
+			writeJSON(t, w, map[string]interface{}{"status": 200, "result": map[string]interface{}{
+				"files": []map[string]interface{}{{"name": "file.bin", "file_code": "eeeeeeeeeeee", "size": len(existing), "hash": fmt.Sprintf("%x", existingSum)}},
+			}})
+		case "/file/remove":
+			removed = append(removed, r.FormValue("file_code"))
+			writeJSON(t, w, map[string]interface{}{"status": 200})
+		case "/upload/server":
+			writeJSON(t, w, map[string]interface{}{"status": 200, "sess_id": "sess", "result": srvURL + "/upload"})
+		case "/upload":
+			file, _, err := r.FormFile("file_0")
+			require.NoError(t, err)
+			data, err := io.ReadAll(file)
+			require.NoError(t, err)
+			uploads = append(uploads, string(data))
+			writeJSON(t, w, []map[string]string{{"file_code": "nnnnnnnnnnnn", "file_status": "OK"}})
+		default:
+			t.Errorf("unexpected request %q", r.URL.Path)
+		}
+	}))
+	srvURL = f.endpoint
+
+	// Identical content isn't uploaded again
+	src := object.NewStaticObjectInfo("file.bin", time.Now(), int64(len(existing)), true, nil, nil)
+	obj, err := f.Put(ctx, strings.NewReader(existing), src)
+	require.NoError(t, err)
+	assert.Equal(t, "eeeeeeeeeeee", obj.(*Object).fileCode)
+	assert.Empty(t, uploads)
+
+	// Content with the same first and last KiB but a different middle is
+	changed := head + strings.Repeat("x", 4096) + tail
+	src = object.NewStaticObjectInfo("file.bin", time.Now(), int64(len(changed)), true, nil, nil)
+	obj, err = f.Put(ctx, strings.NewReader(changed), src)
+	require.NoError(t, err)
+	assert.Equal(t, "nnnnnnnnnnnn", obj.(*Object).fileCode)
+	assert.Equal(t, []string{changed}, uploads)
+	assert.Equal(t, []string{"eeeeeeeeeeee"}, removed)
+}
+
+func TestPutUpdateIdentical(t *testing.T) {
+	ctx := context.Background()
+	for _, test := range []struct {
+		name        string
+		config      configmap.Simple
+		content     string
+		wantUploads int
+	}{
+		{name: "identical", content: "same", wantUploads: 0},
+		{name: "changed", content: "diff", wantUploads: 1},
+		{name: "identical overwrite error", config: configmap.Simple{"overwrite": "error"}, content: "same", wantUploads: 0},
+		{name: "identical overwrite rename", config: configmap.Simple{"overwrite": "rename"}, content: "same", wantUploads: 0},
+		{name: "identical disable_checksum", config: configmap.Simple{"disable_checksum": "true"}, content: "same", wantUploads: 1},
+	} {
+		for _, method := range []string{"put", "update"} {
+			name := test.name + " " + method
+			f, m := newMockFs(t, "", test.config)
+			code := m.addFile("dir/file.txt", "same")
+			src := object.NewStaticObjectInfo("dir/file.txt", time.Now(), int64(len(test.content)), true, nil, nil)
+			var o fs.Object
+			var err error
+			if method == "put" {
+				o, err = f.Put(ctx, strings.NewReader(test.content), src)
+			} else {
+				o, err = f.NewObject(ctx, "dir/file.txt")
+				require.NoError(t, err, name)
+				err = o.Update(ctx, strings.NewReader(test.content), src)
+			}
+			require.NoError(t, err, name)
+			assert.Equal(t, test.wantUploads, m.callCount("/upload"), name)
+			assert.Equal(t, map[string]string{"dir/file.txt": test.content}, m.contents(), name)
+			if test.wantUploads == 0 {
+				assert.Equal(t, code, o.(*Object).fileCode, name)
+			} else {
+				assert.NotEqual(t, code, o.(*Object).fileCode, name)
+			}
+		}
+	}
+}
+
+func TestRenameFolderCommand(t *testing.T) {
+	ctx := context.Background()
+	var renamed []string
+	f := newTestFs(t, "", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/folder/list":
+			folders := map[string][]map[string]interface{}{
+				"0": {{"name": "a", "fld_id": 1}, {"name": "b", "fld_id": 2}},
+				"1": {{"name": "sub", "fld_id": 3}},
+			}[r.FormValue("fld_id")]
+			writeJSON(t, w, map[string]interface{}{"status": 200, "result": map[string]interface{}{"folders": folders}})
+		case "/folder/rename":
+			renamed = append(renamed, r.FormValue("folder_path")+"=>"+r.FormValue("name"))
+			writeJSON(t, w, map[string]interface{}{"status": 200})
+		default:
+			t.Errorf("unexpected request %q", r.URL.Path)
+		}
+	}))
+
+	out, err := f.Command(ctx, "renamefolder", []string{"a/sub", "renamed"}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "/a/renamed", out)
+	assert.Equal(t, []string{"/a/sub=>renamed"}, renamed)
+	_, ok := f.dirCache.Get("a/sub")
+	assert.False(t, ok, "renamed folder should be flushed from the dir cache")
+
+	_, err = f.Command(ctx, "renamefolder", []string{"a", "b"}, nil)
+	assert.ErrorIs(t, err, fs.ErrorDirExists)
+
+	_, err = f.Command(ctx, "renamefolder", []string{"missing", "c"}, nil)
+	assert.ErrorIs(t, err, fs.ErrorDirNotFound)
+
+	assert.Equal(t, []string{"/a/sub=>renamed"}, renamed)
+}
+
+func TestOpenWriterAt(t *testing.T) {
+	ctx := context.Background()
+	h := &updateTestHandler{t: t, files: map[string]string{}}
+	f := newTestFs(t, "", h)
+	h.srvURL = f.endpoint
+
+	w, err := f.OpenWriterAt(ctx, "file.txt", 11)
+	require.NoError(t, err)
+	tempPath := w.(*writerAt).file.Name()
+
+	// Write the chunks out of order
+	for _, chunk := range []struct {
+		off  int64
+		data string
+	}{
+		{6, "world"},
+		{0, "hello"},
+		{5, " "},
+	} {
+		n, err := w.WriteAt([]byte(chunk.data), chunk.off)
+		require.NoError(t, err)
+		assert.Equal(t, len(chunk.data), n)
+	}
+
+	// Nothing is uploaded until Close
+	assert.Empty(t, h.files)
+	require.NoError(t, w.Close())
+
+	assert.Equal(t, map[string]string{"nnnnnnnnnnnn": "file.txt"}, h.files)
+	assert.Equal(t, "hello world", h.content["nnnnnnnnnnnn"])
+	_, err = os.Stat(tempPath)
+	assert.True(t, os.IsNotExist(err), "staging file should be removed")
+}
+
+func TestDownloadFolderFallback(t *testing.T) {
+	ctx := context.Background()
+	tree := map[string]api.FolderListResponse{}
+	content := map[string]string{
+		"/photos/a.txt":     "aaa",
+		"/photos/sub/b.txt": "bbbbb",
+	}
+	var srvURL string
+	f := newTestFs(t, "", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/folder/zip":
+			http.NotFound(w, r)
+		case r.URL.Path == "/folder/list":
+			writeJSON(t, w, tree[r.URL.Query().Get("folder_path")])
+		case r.URL.Path == "/file/direct_link":
+			filePath := r.URL.Query().Get("file_path")
+			writeJSON(t, w, map[string]interface{}{"status": 200, "result": map[string]interface{}{
+				"url":  srvURL + "/dl" + filePath,
+				"size": len(content[filePath]),
+			}})
+		case strings.HasPrefix(r.URL.Path, "/dl/"):
+			data, ok := content[strings.TrimPrefix(r.URL.Path, "/dl")]
+			assert.True(t, ok, r.URL.Path)
+			_, _ = io.WriteString(w, data)
+		default:
+			t.Errorf("unexpected request %q", r.URL.Path)
+		}
+	}))
+	srvURL = f.endpoint
+	for folderPath, listing := range map[string]string{
+		"/photos":     `{"status":200,"result":{"files":[{"name":"a.txt","size":3}],"folders":[{"name":"sub","fld_id":2}]}}`,
+		"/photos/sub": `{"status":200,"result":{"files":[{"name":"b.txt","size":5}]}}`,
+	} {
+		var result api.FolderListResponse
+		require.NoError(t, json.Unmarshal([]byte(listing), &result))
+		tree[folderPath] = result
+	}
+
+	localPath := t.TempDir()
+	out, err := f.Command(ctx, "downloadfolder", []string{"photos", localPath}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, &downloadFolderResult{Files: 2, Bytes: 8}, out)
+
+	for remote, want := range map[string]string{"a.txt": "aaa", "sub/b.txt": "bbbbb"} {
+		got, err := os.ReadFile(filepath.Join(localPath, filepath.FromSlash(remote)))
+		require.NoError(t, err)
+		assert.Equal(t, want, string(got), remote)
+	}
+}
+
+func TestRemoveLostResponse(t *testing.T) {
+	ctx := context.Background()
+	for _, test := range []struct {
+		name    string
+		deletes bool // whether the first remove deletes the file
+		wantErr bool
+	}{
+		{name: "deleted", deletes: true},
+		{name: "not deleted", deletes: false, wantErr: true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			var (
+				mu      sync.Mutex
+				present = true
+				removes = 0
+			)
+			f := newTestFs(t, "", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				defer mu.Unlock()
+				switch r.URL.Path {
+				case "/folder/list":
+					var files []map[string]interface{}
+					if present {
+						files = append(files, map[string]interface{}{"name": "file.txt", "file_code": "aaaaaaaaaaaa"})
+					}
+					writeJSON(t, w, map[string]interface{}{"status": 200, "result": map[string]interface{}{"files": files}})
+				case "/file/remove":
+					assert.Equal(t, "aaaaaaaaaaaa", r.URL.Query().Get("file_code"))
+					removes++
+					if removes == 1 {
+						// Lose the response to the first attempt
+						if test.deletes {
+							present = false
+						}
+						w.WriteHeader(http.StatusInternalServerError)
+						return
+					}
+					if !present {
+						writeJSON(t, w, map[string]interface{}{"status": 404, "msg": "file not found"})
+						return
+					}
+					w.WriteHeader(http.StatusInternalServerError)
+				default:
+					t.Errorf("unexpected request %q", r.URL.Path)
+				}
+			}))
+
+			o := &Object{fs: f, remote: "file.txt", fileCode: "aaaaaaaaaaaa"}
+			err := o.Remove(ctx)
+			if test.wantErr {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+			assert.GreaterOrEqual(t, removes, 2, "remove should be retried")
+		})
+	}
+}
+
+func TestFileCodeFromRemote(t *testing.T) {
+	for in, want := range map[string]string{
+		"(abc123def456) file.txt":     "abc123def456",
+		"dir/(abc123def456) file.txt": "abc123def456",
+		"dir/file (abc123def456).txt": "abc123def456",
+		"(abc123def456) dir/file.txt": "",
+		"dir/(123456789012) file.txt": "",
+		"dir/(abc123) file.txt":       "",
+		"dir/file.txt":                "",
+	} {
+		assert.Equal(t, want, fileCodeFromRemote(in), in)
+	}
+}
+
+func TestRemoveDecorated(t *testing.T) {
+	ctx := context.Background()
+	f, m := newMockFs(t, "", nil)
+	for _, dir := range []string{"", "dir", "a/b/c"} {
+		code := m.addFile(path.Join(dir, "file.txt"), "hello")
+		m.addFile(path.Join(dir, "other.txt"), "other")
+		o := &Object{fs: f, remote: path.Join(dir, "("+code+") file.txt")}
+		require.NoError(t, o.Remove(ctx), dir)
+		contents := m.contents()
+		assert.NotContains(t, contents, path.Join(dir, "file.txt"), dir)
+		assert.Contains(t, contents, path.Join(dir, "other.txt"), dir)
+	}
+}
+
+func TestCheckKey(t *testing.T) {
+	ctx := context.Background()
+	for _, test := range []struct {
+		name    string
+		key     string
+		want    string
+		wantErr string
+	}{
+		{name: "valid", key: "key", want: "account prem, storage 1000 GB, used 1 GB, premium until 2030-01-01"},
+		{name: "invalid", key: "bad", wantErr: "invalid key: Invalid key"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			f := newTestFsOpt(t, "", configmap.Simple{"FileLu Rclone Key": test.key}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Query().Get("key") != "key" {
+					writeJSON(t, w, map[string]interface{}{"status": 403, "msg": "Invalid key"})
+					return
+				}
+				switch r.URL.Path {
+				case "/account/info":
+					writeJSON(t, w, map[string]interface{}{"status": 200, "result": map[string]string{
+						"utype": "prem", "storage": "1000 GB", "storage_used": "1 GB", "premium_expire": "2030-01-01",
+					}})
+				case "/folder/list":
+					assert.Equal(t, rootFolderID, r.URL.Query().Get("fld_id"))
+					writeJSON(t, w, map[string]interface{}{"status": 200, "result": map[string]interface{}{}})
+				default:
+					t.Errorf("unexpected request %q", r.URL.Path)
+				}
+			}))
+
+			plan, err := f.checkKey(ctx)
+			if test.wantErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), test.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.want, plan)
+		})
+	}
+}
+
+func TestConfigSkipCheck(t *testing.T) {
+	ri, err := fs.Find("filelu")
+	require.NoError(t, err)
+	out, err := ri.Config(context.Background(), "test", configmap.Simple{}, fs.ConfigIn{State: "check", Result: "false"})
+	require.NoError(t, err)
+	assert.Nil(t, out)
+}
+
+func TestFiledrop(t *testing.T) {
+	ctx := context.Background()
+	var srvURL string
+	uploaded := map[string]string{}
+	f := newTestFsOpt(t, "DROPCODE", configmap.Simple{"root_is_filedrop": "true"}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/upload/server":
+			writeJSON(t, w, map[string]interface{}{"status": 200, "sess_id": "sess", "result": srvURL + "/upload"})
+		case "/upload":
+			assert.Equal(t, "DROPCODE", r.FormValue("fld_code"))
+			file, header, err := r.FormFile("file_0")
+			require.NoError(t, err)
+			data, err := io.ReadAll(file)
+			require.NoError(t, err)
+			uploaded[header.Filename] = string(data)
+			writeJSON(t, w, []map[string]string{{"file_code": "dddddddddddd", "file_status": "OK"}})
+		default:
+			t.Errorf("unexpected request %q", r.URL.Path)
+		}
+	}))
+	srvURL = f.endpoint
+
+	require.NoError(t, f.Mkdir(ctx, ""))
+	_, err := f.NewObject(ctx, "file.txt")
+	assert.Equal(t, fs.ErrorObjectNotFound, err)
+
+	src := object.NewStaticObjectInfo("file.txt", time.Now(), 5, true, nil, nil)
+	o, err := f.Put(ctx, strings.NewReader("hello"), src)
+	require.NoError(t, err)
+	assert.Equal(t, "file.txt", o.Remote())
+	assert.Equal(t, map[string]string{"file.txt": "hello"}, uploaded)
+
+	src = object.NewStaticObjectInfo("dir/file.txt", time.Now(), 5, true, nil, nil)
+	_, err = f.Put(ctx, strings.NewReader("hello"), src)
+	assert.ErrorIs(t, err, fs.ErrorNotImplemented)
+
+	_, err = f.List(ctx, "")
+	assert.ErrorIs(t, err, fs.ErrorNotImplemented)
+	_, err = o.Open(ctx)
+	assert.ErrorIs(t, err, fs.ErrorNotImplemented)
+	assert.ErrorIs(t, o.Remove(ctx), fs.ErrorNotImplemented)
+	assert.ErrorIs(t, f.Rmdir(ctx, ""), fs.ErrorNotImplemented)
+}
+
+func TestFeatures(t *testing.T) {
+	f := newTestFs(t, "", http.NotFoundHandler())
+	features := f.Features()
+	var do interface{} = f
+	for _, test := range []struct {
+		name        string
+		advertised  bool
+		implemented bool
+	}{
+		{"About", features.About != nil, isType[fs.Abouter](do)},
+		{"Command", features.Command != nil, isType[fs.Commander](do)},
+		{"Copy", features.Copy != nil, isType[fs.Copier](do)},
+		{"DirMove", features.DirMove != nil, isType[fs.DirMover](do)},
+		{"ListR", features.ListR != nil, isType[fs.ListRer](do)},
+		{"Move", features.Move != nil, isType[fs.Mover](do)},
+		{"OpenWriterAt", features.OpenWriterAt != nil, isType[fs.OpenWriterAter](do)},
+		{"PublicLink", features.PublicLink != nil, isType[fs.PublicLinker](do)},
+		{"Purge", features.Purge != nil, isType[fs.Purger](do)},
+		{"PutStream", features.PutStream != nil, isType[fs.PutStreamer](do)},
+	} {
+		assert.Equal(t, test.implemented, test.advertised, test.name)
+	}
+	assert.True(t, features.CanHaveEmptyDirectories)
+}
+
+// isType reports whether x satisfies T
+func isType[T any](x interface{}) bool {
+	_, ok := x.(T)
+	return ok
+}
+
+func TestMove(t *testing.T) {
+	ctx := context.Background()
+	var requests []string
+	f := newTestFs(t, "", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		switch r.URL.Path {
+		case "/folder/list":
+			if q.Get("folder_path") == "/dst" {
+				// No file is there to be replaced
+				writeJSON(t, w, map[string]interface{}{"status": 200, "result": map[string]interface{}{}})
+				return
+			}
+			assert.Equal(t, rootFolderID, q.Get("fld_id"))
+			writeJSON(t, w, map[string]interface{}{"status": 200, "result": map[string]interface{}{
+				"folders": []map[string]interface{}{{"name": "dst", "fld_id": 5}},
+			}})
+			return
+		case "/file/set_folder":
+			requests = append(requests, "set_folder "+q.Get("file_code")+q.Get("file_path")+" "+q.Get("destination_folder_path"))
+		case "/file/rename":
+			requests = append(requests, "rename "+q.Get("file_code")+q.Get("file_path")+" "+q.Get("name"))
+		default:
+			t.Errorf("unexpected request %q", r.URL.Path)
+		}
+		writeJSON(t, w, map[string]interface{}{"status": 200})
+	}))
+
+	src := &Object{fs: f, remote: "src/file.txt", size: 3, hasSize: true, fileCode: "aaaaaaaaaaaa"}
+	dst, err := f.Move(ctx, src, "dst/new.txt")
+	require.NoError(t, err)
+	assert.Equal(t, "dst/new.txt", dst.Remote())
+	assert.Equal(t, int64(3), dst.Size())
+	assert.Equal(t, []string{
+		"set_folder aaaaaaaaaaaa /dst",
+		"rename aaaaaaaaaaaa new.txt",
+	}, requests)
+
+	// Without a file code the file is found by path
+	requests = nil
+	src = &Object{fs: f, remote: "src/file.txt", size: 3, hasSize: true}
+	_, err = f.Move(ctx, src, "dst/new.txt")
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"set_folder /src/file.txt /dst",
+		"rename /dst/file.txt new.txt",
+	}, requests)
+
+	// Objects from other backends can't be moved server side
+	_, err = f.Move(ctx, object.NewMemoryObject("file.txt", time.Now(), nil), "x.txt")
+	assert.Equal(t, fs.ErrorCantMove, err)
+}
+
+func TestDownloadBwLimit(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping timing test in short mode")
+	}
+	ctx := context.Background()
+	const size = 32 * 1024
+	data := strings.Repeat("x", size)
+	var srvURL string
+	f := newTestFs(t, "", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/file/direct_link":
+			writeJSON(t, w, map[string]interface{}{"status": 200, "result": map[string]interface{}{"url": srvURL + "/download", "size": size}})
+		case "/download":
+			_, _ = io.WriteString(w, data)
+		default:
+			t.Errorf("unexpected request %q", r.URL.Path)
+		}
+	}))
+	srvURL = f.endpoint
+
+	// Limit to the file size per second so the download takes about
+	// a second
+	accounting.TokenBucket.SetBwLimit(fs.BwPair{Tx: size, Rx: size})
+	defer accounting.TokenBucket.SetBwLimit(fs.BwPair{Tx: -1, Rx: -1})
+
+	o := &Object{fs: f, remote: "file.txt", size: size, hasSize: true}
+	target := filepath.Join(t.TempDir(), "file.txt")
+	start := time.Now()
+	n, err := downloadObject(ctx, o, target)
+	require.NoError(t, err)
+	assert.Equal(t, int64(size), n)
+	assert.GreaterOrEqual(t, time.Since(start), 500*time.Millisecond, "download should be throttled")
+}
+
+func TestVerify(t *testing.T) {
+	ctx := context.Background()
+	md5Hex := func(s string) string {
+		sum := md5.Sum([]byte(s))
+		return fmt.Sprintf("%x", sum)
+	}
+	f := newTestFs(t, "", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Files listed without a hash have it read from file/info
+		if r.URL.Path == "/file/info" {
+			assert.Equal(t, "unhashedfile", r.URL.Query().Get("file_code"))
+			writeJSON(t, w, map[string]interface{}{"status": 200, "result": []map[string]string{
+				{"file_code": "unhashedfile", "name": "unhashed.txt", "hash": md5Hex("unhashed")},
+			}})
+			return
+		}
+		assert.Equal(t, "/folder/list", r.URL.Path)
+		assert.Equal(t, "/backup", r.URL.Query().Get("folder_path"))
+		writeJSON(t, w, map[string]interface{}{"status": 200, "result": map[string]interface{}{
+			"files": []map[string]interface{}{
+				{"name": "same.txt", "hash": md5Hex("same")},
+				{"name": "changed.txt", "hash": md5Hex("remote")},
+				{"name": "remote-only.txt", "hash": md5Hex("x")},
+				{"name": "unhashed.txt", "file_code": "unhashedfile"},
+			},
+		}})
+	}))
+
+	localPath := t.TempDir()
+	for name, content := range map[string]string{
+		"same.txt":       "same",
+		"changed.txt":    "local",
+		"local-only.txt": "y",
+		"unhashed.txt":   "unhashed",
+	} {
+		require.NoError(t, os.WriteFile(filepath.Join(localPath, name), []byte(content), 0666))
+	}
+
+	out, err := f.Command(ctx, "verify", []string{"backup", localPath}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, &verifyResult{
+		Matched:       2,
+		Mismatched:    []string{"changed.txt"},
+		MissingRemote: []string{"local-only.txt"},
+		MissingLocal:  []string{"remote-only.txt"},
+	}, out)
+}
+
+func TestOpenFileCode(t *testing.T) {
+	ctx := context.Background()
+	for _, test := range []struct {
+		name      string
+		root      string
+		object    Object
+		wantQuery string
+	}{
+		{
+			name:      "file code root",
+			root:      "abcdefghijk1",
+			object:    Object{remote: "file.txt"},
+			wantQuery: "file_code=abcdefghijk1",
+		},
+		{
+			name:      "folder root with stored code",
+			root:      "123456789012",
+			object:    Object{remote: "file.txt", fileCode: "zyxwvutsrq98"},
+			wantQuery: "file_code=zyxwvutsrq98",
+		},
+		{
+			name:      "folder root with decorated remote",
+			root:      "123456789012",
+			object:    Object{remote: "file (zyxwvutsrq98).txt"},
+			wantQuery: "file_code=zyxwvutsrq98",
+		},
+		{
+			name:      "folder root without code",
+			root:      "123456789012",
+			object:    Object{remote: "dir/file.txt"},
+			wantQuery: "file_path=%2F123456789012%2Fdir%2Ffile.txt",
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			var srvURL string
+			f := newTestFs(t, test.root, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch r.URL.Path {
+				case "/file/direct_link":
+					q := r.URL.Query()
+					q.Del("key")
+					assert.Equal(t, test.wantQuery, q.Encode())
+					writeJSON(t, w, map[string]interface{}{"status": 200, "result": map[string]interface{}{"url": srvURL + "/download", "size": 5}})
+				case "/download":
+					_, _ = io.WriteString(w, "hello")
+				default:
+					t.Errorf("unexpected request %q", r.URL.Path)
+				}
+			}))
+			srvURL = f.endpoint
+
+			o := test.object
+			o.fs = f
+			in, err := o.Open(ctx)
+			require.NoError(t, err)
+			data, err := io.ReadAll(in)
+			require.NoError(t, err)
+			require.NoError(t, in.Close())
+			assert.Equal(t, "hello", string(data))
+		})
+	}
+}
+
+func TestAPITimeout(t *testing.T) {
+	ctx := context.Background()
+	var (
+		mu    sync.Mutex
+		calls = 0
+	)
+	f := newTestFsOpt(t, "", configmap.Simple{"timeout": "50ms"}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/folder/list", r.URL.Path)
+		mu.Lock()
+		calls++
+		stuck := calls == 1
+		mu.Unlock()
+		if stuck {
+			// Hang until the client gives up
+			select {
+			case <-r.Context().Done():
+			case <-time.After(10 * time.Second):
+				t.Error("request wasn't cancelled")
+			}
+			return
+		}
+		writeJSON(t, w, map[string]interface{}{"status": 200, "result": map[string]interface{}{
+			"files": []map[string]interface{}{{"name": "file.txt"}},
+		}})
+	}))
+
+	start := time.Now()
+	entries, err := f.List(ctx, "")
+	require.NoError(t, err)
+	assert.Len(t, entries, 1)
+	assert.Equal(t, 2, calls, "stuck listing should be retried")
+	assert.Less(t, time.Since(start), 5*time.Second)
+}
+
+func TestCopyFolder(t *testing.T) {
+	ctx := accounting.WithStatsGroup(context.Background(), "TestCopyFolder")
+	var (
+		clones  = 0
+		moves   = map[string]string{}
+		creates []string
+	)
+	paths := map[string]interface{}{
+		"/src": map[string]interface{}{
+			"files":   []map[string]interface{}{{"name": "a.txt", "file_code": "aaaaaaaaaaaa", "size": 1}},
+			"folders": []map[string]interface{}{{"name": "sub", "fld_id": 11}, {"name": "empty", "fld_id": 12}},
+		},
+		"/src/sub": map[string]interface{}{
+			"files": []map[string]interface{}{{"name": "b.txt", "file_code": "bbbbbbbbbbbb", "size": 2}},
+		},
+		"/src/empty": map[string]interface{}{},
+	}
+	ids := map[string]interface{}{
+		"0": map[string]interface{}{"folders": []map[string]interface{}{{"name": "src", "fld_id": 10}, {"name": "dst", "fld_id": 20}}},
+	}
+	f := newTestFs(t, "", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		switch r.URL.Path {
+		case "/folder/list":
+			if q.Has("fld_id") {
+				writeJSON(t, w, map[string]interface{}{"status": 200, "result": ids[q.Get("fld_id")]})
+			} else {
+				writeJSON(t, w, map[string]interface{}{"status": 200, "result": paths[q.Get("folder_path")]})
+			}
+		case "/folder/create":
+			creates = append(creates, q.Get("parent_id")+"/"+q.Get("name"))
+			writeJSON(t, w, map[string]interface{}{"status": 200, "result": map[string]interface{}{"fld_id": 20 + len(creates)}})
+		case "/file/clone":
+			clones++
+			writeJSON(t, w, map[string]interface{}{"status": 200, "result": map[string]interface{}{"filecode": fmt.Sprintf("clone%07d", clones)}})
+		case "/file/set_folder":
+			moves[q.Get("file_code")] = q.Get("destination_folder_path")
+			writeJSON(t, w, map[string]interface{}{"status": 200})
+		default:
+			t.Errorf("unexpected request %q", r.URL.Path)
+		}
+	}))
+
+	out, err := f.Command(ctx, "copyfolder", []string{"src", "dst"}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, &copyFolderResult{Files: 2, Bytes: 3, Failed: []string{}}, out)
+	assert.Equal(t, map[string]string{"clone0000001": "/dst", "clone0000002": "/dst/sub"}, moves)
+	assert.Equal(t, []string{"20/empty", "20/sub"}, creates)
+	assert.Equal(t, int64(2), accounting.Stats(ctx).GetTransfers())
+}
+
+func TestNewObjectPlainPath(t *testing.T) {
+	ctx := context.Background()
+	var srvURL string
+	f := newTestFs(t, "", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		switch r.URL.Path {
+		case "/folder/list":
+			if q.Get("folder_path") != "/dir" {
+				writeJSON(t, w, map[string]interface{}{"status": 404, "msg": "Folder not found"})
+				return
+			}
+			writeJSON(t, w, map[string]interface{}{"status": 200, "result": map[string]interface{}{
+				"files": []map[string]interface{}{{"name": "file.txt", "file_code": "cccccccccccc", "size": 5}},
+			}})
+		case "/file/direct_link":
+			assert.Equal(t, "cccccccccccc", q.Get("file_code"))
+			writeJSON(t, w, map[string]interface{}{"status": 200, "result": map[string]interface{}{"url": srvURL + "/download", "size": 5}})
+		case "/download":
+			_, _ = io.WriteString(w, "hello")
+		case "/file/info":
+			assert.Equal(t, "cccccccccccc", q.Get("file_code"))
+			writeJSON(t, w, map[string]interface{}{"status": 200, "result": []map[string]string{{"hash": "0123456789abcdef"}}})
+		case "/file/remove":
+			assert.Equal(t, "cccccccccccc", q.Get("file_code"))
+			writeJSON(t, w, map[string]interface{}{"status": 200})
+		default:
+			t.Errorf("unexpected request %q", r.URL.Path)
+		}
+	}))
+	srvURL = f.endpoint
+
+	obj, err := f.NewObject(ctx, "dir/file.txt")
+	require.NoError(t, err)
+	assert.Equal(t, "cccccccccccc", obj.(*Object).fileCode)
+	assert.Equal(t, int64(5), obj.Size())
+
+	in, err := obj.Open(ctx)
+	require.NoError(t, err)
+	data, err := io.ReadAll(in)
+	require.NoError(t, err)
+	require.NoError(t, in.Close())
+	assert.Equal(t, "hello", string(data))
+
+	sum, err := obj.Hash(ctx, hash.MD5)
+	require.NoError(t, err)
+	assert.Equal(t, "0123456789abcdef", sum)
+
+	require.NoError(t, obj.Remove(ctx))
+
+	_, err = f.NewObject(ctx, "dir/missing.txt")
+	assert.Equal(t, fs.ErrorObjectNotFound, err)
+	_, err = f.NewObject(ctx, "missing/file.txt")
+	assert.Equal(t, fs.ErrorObjectNotFound, err)
+}
+
+func TestSizeMethod(t *testing.T) {
+	ctx := context.Background()
+	for _, test := range []struct {
+		method       string
+		noHeadObject string
+		want         map[string]int
+	}{
+		{method: "listing", want: map[string]int{"GET /folder/list": 1}},
+		{method: "info", want: map[string]int{"GET /folder/list": 1, "GET /file/info": 2}},
+		{method: "head", want: map[string]int{"GET /folder/list": 1, "GET /file/direct_link": 2, "HEAD /download": 2}},
+		{method: "head", noHeadObject: "true", want: map[string]int{"GET /folder/list": 1, "GET /file/info": 2}},
+	} {
+		t.Run(test.method+test.noHeadObject, func(t *testing.T) {
+			var (
+				mu       sync.Mutex
+				requests = map[string]int{}
+				srvURL   string
+			)
+			f := newTestFsOpt(t, "", configmap.Simple{"size_method": test.method, "no_head_object": test.noHeadObject}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				requests[r.Method+" "+r.URL.Path]++
+				mu.Unlock()
+				switch r.URL.Path {
+				case "/folder/list":
+					writeJSON(t, w, map[string]interface{}{"status": 200, "result": map[string]interface{}{
+						"files": []map[string]interface{}{{"name": "a.txt", "size": 42}, {"name": "b.txt", "size": 42}},
+					}})
+				case "/file/info":
+					writeJSON(t, w, map[string]interface{}{"status": 200, "result": []map[string]string{{"size": "42"}}})
+				case "/file/direct_link":
+					writeJSON(t, w, map[string]interface{}{"status": 200, "result": map[string]interface{}{"url": srvURL + "/download", "size": 42}})
+				case "/download":
+					w.Header().Set("Content-Length", "42")
+				default:
+					t.Errorf("unexpected request %q", r.URL.Path)
+				}
+			}))
+			srvURL = f.endpoint
+
+			entries, err := f.List(ctx, "")
+			require.NoError(t, err)
+			require.Len(t, entries, 2)
+			for _, entry := range entries {
+				assert.Equal(t, int64(42), entry.Size())
+			}
+			assert.Equal(t, test.want, requests)
+
+			// The size is cached so asking again makes no requests
+			_, err = entries[0].(*Object).fetchSize(ctx)
+			require.NoError(t, err)
+			assert.Equal(t, test.want, requests)
+		})
+	}
+}
+
+func TestLargeFolderID(t *testing.T) {
+	ctx := context.Background()
+	const bigID = int64(1)<<31 + 12345
+	var response api.FolderListResponse
+	require.NoError(t, json.Unmarshal([]byte(`{"status":200,"result":{"folders":[{"name":"big","fld_id":2147495993}]}}`), &response))
+	assert.Equal(t, bigID, response.Result.Folders[0].FldID)
+
+	var fldIDs []string
+	f := newTestFs(t, "", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		fldIDs = append(fldIDs, q.Get("fld_id"))
+		if q.Get("fld_id") == "0" || (!q.Has("fld_id") && q.Get("folder_path") == "") {
+			writeJSON(t, w, map[string]interface{}{"status": 200, "result": map[string]interface{}{"folders": []map[string]interface{}{{"name": "big", "fld_id": bigID}}}})
+			return
+		}
+		writeJSON(t, w, map[string]interface{}{"status": 200, "result": map[string]interface{}{}})
+	}))
+
+	entries, err := f.List(ctx, "")
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, "2147495993", entries[0].(fs.Directory).ID())
+
+	id, err := f.dirCache.FindDir(ctx, "big/sub", false)
+	assert.ErrorIs(t, err, fs.ErrorDirNotFound)
+	assert.Equal(t, "", id)
+	assert.Contains(t, fldIDs, "2147495993")
+}
+
+func TestDeleteCommand(t *testing.T) {
+	ctx := context.Background()
+	var removed []string
+	f := newTestFs(t, "", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/file/remove", r.URL.Path)
+		q := r.URL.Query()
+		if q.Get("file_code") == "bbbbbbbbbbbb" {
+			writeJSON(t, w, map[string]interface{}{"status": 404, "msg": "File not found"})
+			return
+		}
+		removed = append(removed, q.Get("file_code")+q.Get("file_path"))
+		writeJSON(t, w, map[string]interface{}{"status": 200})
+	}))
+
+	out, err := f.Command(ctx, "delete", []string{"aaaaaaaaaaaa", "bbbbbbbbbbbb", "dir/c.txt"}, nil)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "bbbbbbbbbbbb (error while deleting file: File not found)")
+	assert.Contains(t, err.Error(), "deleted: aaaaaaaaaaaa, dir/c.txt")
+	assert.Equal(t, &deleteResult{
+		Deleted: []string{"aaaaaaaaaaaa", "dir/c.txt"},
+		Failed:  map[string]string{"bbbbbbbbbbbb": "error while deleting file: File not found"},
+	}, out)
+	assert.Equal(t, []string{"aaaaaaaaaaaa", "/dir/c.txt"}, removed)
+
+	out, err = f.Command(ctx, "delete", []string{"aaaaaaaaaaaa"}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, &deleteResult{Deleted: []string{"aaaaaaaaaaaa"}, Failed: map[string]string{}}, out)
+}
+
+func TestDisableChecksum(t *testing.T) {
+	ctx := context.Background()
+	var (
+		srvURL   string
+		requests []string
+	)
+	f := newTestFsOpt(t, "", configmap.Simple{"disable_checksum": "true"}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.URL.Path)
+		switch r.URL.Path {
+		case "/folder/list":
+			writeJSON(t, w, map[string]interface{}{"status": 200, "result": map[string]interface{}{}})
+		case "/upload/server":
+			writeJSON(t, w, map[string]interface{}{"status": 200, "sess_id": "sess", "result": srvURL + "/upload"})
+		case "/upload":
+			writeJSON(t, w, []map[string]string{{"file_code": "nnnnnnnnnnnn", "file_status": "OK"}})
+		default:
+			t.Errorf("unexpected request %q", r.URL.Path)
+		}
+	}))
+	srvURL = f.endpoint
+
+	assert.Equal(t, hash.Set(hash.None), f.Hashes())
+
+	src := object.NewStaticObjectInfo("file.txt", time.Now(), 5, true, nil, nil)
+	obj, err := f.Put(ctx, strings.NewReader("hello"), src)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"/folder/list", "/upload/server", "/upload"}, requests)
+
+	_, err = obj.Hash(ctx, hash.MD5)
+	assert.Equal(t, hash.ErrUnsupported, err)
+	assert.Equal(t, []string{"/folder/list", "/upload/server", "/upload"}, requests)
+}
+
+// countingWriter counts the bytes of a response body
+type countingWriter struct {
+	http.ResponseWriter
+	n *int64
+}
+
+func (w countingWriter) Write(p []byte) (int, error) {
+	atomic.AddInt64(w.n, int64(len(p)))
+	return w.ResponseWriter.Write(p)
+}
+
+func TestOpenRange(t *testing.T) {
+	ctx := context.Background()
+	content := bytes.Repeat([]byte("0123456789abcdef"), 64*1024) // 1 MiB
+	var (
+		srvURL      string
+		linkCalls   int
+		served      int64
+		downloads   int
+		expireLinks bool
+	)
+	f := newTestFs(t, "", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/file/direct_link":
+			linkCalls++
+			assert.Equal(t, "cccccccccccc", r.URL.Query().Get("file_code"))
+			writeJSON(t, w, map[string]interface{}{"status": 200, "result": map[string]interface{}{
+				"url":  fmt.Sprintf("%s/download/%d", srvURL, linkCalls),
+				"size": len(content),
+			}})
+		case "/download/1", "/download/2":
+			if expireLinks && r.URL.Path == "/download/1" {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			downloads++
+			http.ServeContent(countingWriter{ResponseWriter: w, n: &served}, r, "file.bin", time.Time{}, bytes.NewReader(content))
+		default:
+			t.Errorf("unexpected request %q", r.URL.Path)
+		}
+	}))
+	srvURL = f.endpoint
+	o := &Object{fs: f, remote: "file.bin", fileCode: "cccccccccccc", size: int64(len(content)), hasSize: true}
+
+	read := func(options ...fs.OpenOption) []byte {
+		in, err := o.Open(ctx, options...)
+		require.NoError(t, err)
+		data, err := io.ReadAll(in)
+		require.NoError(t, err)
+		require.NoError(t, in.Close())
+		return data
+	}
+
+	// Seek around in the file as a mount would
+	assert.Equal(t, content[1000:2000], read(&fs.RangeOption{Start: 1000, End: 1999}))
+	assert.Equal(t, content[500000:500100], read(&fs.RangeOption{Start: 500000, End: 500099}))
+	assert.Equal(t, content[len(content)-10:], read(&fs.RangeOption{Start: -1, End: 10}))
+	assert.Equal(t, int64(1000+100+10), atomic.LoadInt64(&served))
+	assert.Equal(t, 3, downloads)
+	assert.Equal(t, 1, linkCalls, "the direct link should be reused")
+
+	// An expired link is replaced
+	expireLinks = true
+	assert.Equal(t, content[:10], read(&fs.RangeOption{Start: 0, End: 9}))
+	assert.Equal(t, 2, linkCalls)
+	assert.Equal(t, 4, downloads)
+}
+
+func TestListDeletedFolder(t *testing.T) {
+	ctx := context.Background()
+	deleted := map[string]interface{}{"status": 403, "msg": "Folder was deleted"}
+	f := newTestFs(t, "", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/folder/list", r.URL.Path)
+		q := r.URL.Query()
+		if q.Get("fld_id") == "0" {
+			writeJSON(t, w, map[string]interface{}{"status": 200, "result": map[string]interface{}{"folders": []map[string]interface{}{{"name": "gone", "fld_id": 9}}}})
+			return
+		}
+		writeJSON(t, w, deleted)
+	}))
+
+	_, err := f.List(ctx, "gone")
+	assert.Equal(t, fs.ErrorDirNotFound, err)
+
+	_, err = f.getFolderID(ctx, "gone/sub")
+	assert.Equal(t, fs.ErrorDirNotFound, err)
+
+	_, err = f.dirCache.FindDir(ctx, "gone/sub", false)
+	assert.ErrorIs(t, err, fs.ErrorDirNotFound)
+}
+
+func TestBatchDelete(t *testing.T) {
+	ctx := context.Background()
+	var (
+		removed       []string
+		removedDirs   []string
+		batchDisabled bool
+	)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		switch r.URL.Path {
+		case "/file/remove":
+			code := q.Get("file_code")
+			if batchDisabled && strings.Contains(code, ",") {
+				writeJSON(t, w, map[string]interface{}{"status": 400, "msg": "Invalid file code"})
+				return
+			}
+			removed = append(removed, code)
+			writeJSON(t, w, map[string]interface{}{"status": 200})
+		case "/folder/list":
+			listing := map[string]interface{}{}
+			switch q.Get("folder_path") {
+			case "/dir":
+				if len(removed) == 0 {
+					listing = map[string]interface{}{
+						"files":   []map[string]interface{}{{"name": "1", "file_code": "aaaaaaaaaaa1"}, {"name": "2", "file_code": "aaaaaaaaaaa2"}},
+						"folders": []map[string]interface{}{{"name": "sub", "fld_id": 5}},
+					}
+				}
+			case "/dir/sub":
+				if len(removed) == 0 {
+					listing = map[string]interface{}{
+						"files": []map[string]interface{}{{"name": "3", "file_code": "aaaaaaaaaaa3"}},
+					}
+				}
+			}
+			writeJSON(t, w, map[string]interface{}{"status": 200, "result": listing})
+		case "/folder/delete":
+			removedDirs = append(removedDirs, q.Get("folder_path"))
+			writeJSON(t, w, map[string]interface{}{"status": 200})
+		default:
+			t.Errorf("unexpected request %q", r.URL.Path)
+		}
+	})
+
+	// The delete command groups the codes
+	f := newTestFsOpt(t, "", configmap.Simple{"batch_delete": "2"}, handler)
+	_, err := f.Command(ctx, "delete", []string{"aaaaaaaaaaa1", "aaaaaaaaaaa2", "aaaaaaaaaaa3"}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"aaaaaaaaaaa1,aaaaaaaaaaa2", "aaaaaaaaaaa3"}, removed)
+
+	// Purge too
+	removed = nil
+	require.NoError(t, f.Purge(ctx, "dir"))
+	assert.Equal(t, []string{"aaaaaaaaaaa1,aaaaaaaaaaa2", "aaaaaaaaaaa3"}, removed)
+	assert.Equal(t, []string{"/dir/sub", "/dir"}, removedDirs)
+
+	// Files are deleted one at a time if batches aren't supported
+	removed = nil
+	batchDisabled = true
+	_, err = f.Command(ctx, "delete", []string{"aaaaaaaaaaa1", "aaaaaaaaaaa2", "aaaaaaaaaaa3"}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"aaaaaaaaaaa1", "aaaaaaaaaaa2", "aaaaaaaaaaa3"}, removed)
+}
+
+func TestDirCacheFlush(t *testing.T) {
+	ctx := context.Background()
+	var lookups int
+	f := newTestFs(t, "", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/folder/list", r.URL.Path)
+		lookups++
+		writeJSON(t, w, map[string]interface{}{"status": 200, "result": map[string]interface{}{"folders": []map[string]interface{}{{"name": "dir", "fld_id": 4}}}})
+	}))
+
+	for i := 0; i < 2; i++ {
+		id, err := f.dirCache.FindDir(ctx, "dir", false)
+		require.NoError(t, err)
+		assert.Equal(t, "4", id)
+	}
+	assert.Equal(t, 1, lookups)
+
+	f.Features().DirCacheFlush()
+	_, ok := f.dirCache.Get("dir")
+	assert.False(t, ok, "cache should be empty after DirCacheFlush")
+
+	id, err := f.dirCache.FindDir(ctx, "dir", false)
+	require.NoError(t, err)
+	assert.Equal(t, "4", id)
+	assert.Equal(t, 2, lookups)
+}
+
+func TestUploadFileStatus(t *testing.T) {
+	ctx := context.Background()
+	tempPath, _, err := createTempFileFromReader("", "", strings.NewReader("hello"), hash.None)
+	require.NoError(t, err)
+	defer func() { _ = os.Remove(tempPath) }()
+
+	for _, test := range []struct {
+		name      string
+		response  interface{}
+		infoPolls int      // number of file/info calls before the file is ready
+		states    []string // file_status of each file/info call which knows the file
+		want      string
+		wantErr   string
+	}{
+		{name: "ok", response: []map[string]string{{"file_code": "okokokokokok", "file_status": "OK"}}, want: "okokokokokok"},
+		{name: "duplicate", response: []map[string]string{{"file_code": "dupdupdupdup", "file_status": "duplicate"}}, want: "dupdupdupdup"},
+		{name: "exists", response: []map[string]string{{"file_code": "exexexexexex", "file_status": "exists"}}, want: "exexexexexex"},
+		{name: "duplicate without code", response: []map[string]string{{"file_status": "duplicate"}}, wantErr: "no file code"},
+		{name: "pending", response: []map[string]string{{"file_code": "pendpendpend", "file_status": "pending"}}, infoPolls: 3, want: "pendpendpend"},
+		{name: "processing", response: []map[string]string{{"file_code": "procprocproc", "file_status": "processing"}}, infoPolls: 1, want: "procprocproc"},
+		{name: "queued", response: []map[string]string{{"file_code": "queuequeuequ", "file_status": "queued"}}, infoPolls: 2, want: "queuequeuequ"},
+		{name: "info processing", response: []map[string]string{{"file_code": "infoinfoinfo", "file_status": "pending"}}, infoPolls: 3, states: []string{"processing", "Processing", "ready"}, want: "infoinfoinfo"},
+		{name: "info never ready", response: []map[string]string{{"file_code": "nevernever12", "file_status": "pending"}}, states: []string{"processing"}, wantErr: "still pending after 50ms: processing"},
+		{name: "failed", response: []map[string]string{{"file_code": "", "file_status": "failed"}}, wantErr: "upload failed with status: failed"},
+		{name: "empty", response: []map[string]string{}, wantErr: "empty response"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			infoCalls := 0
+			f := newTestFs(t, "", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch r.URL.Path {
+				case "/upload":
+					writeJSON(t, w, test.response)
+				case "/file/info":
+					infoCalls++
+					if test.states != nil {
+						state := test.states[min(infoCalls, len(test.states))-1]
+						writeJSON(t, w, map[string]interface{}{"status": 200, "result": []map[string]string{{"file_code": test.want, "file_status": state}}})
+						return
+					}
+					if infoCalls < test.infoPolls {
+						writeJSON(t, w, map[string]interface{}{"status": 404, "msg": "File is processing"})
+						return
+					}
+					writeJSON(t, w, map[string]interface{}{"status": 200, "result": []map[string]string{{"file_code": test.want}}})
+				default:
+					t.Errorf("unexpected request %q", r.URL.Path)
+				}
+			}))
+			f.opt.PollInterval = fs.Duration(time.Millisecond)
+			f.opt.PollTimeout = fs.Duration(50 * time.Millisecond)
+			got, err := f.uploadFile(ctx, f.endpoint+"/upload", "sess", "file.txt", tempPath)
+			if test.wantErr != "" {
+				assert.ErrorContains(t, err, test.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.want, got)
+			assert.Equal(t, test.infoPolls, infoCalls)
+		})
+	}
+}
+
+func TestImportLink(t *testing.T) {
+	ctx := context.Background()
+	for _, test := range []struct {
+		link    string
+		want    string
+		wantErr bool
+	}{
+		{link: "abcdefghijkl", want: "abcdefghijkl"},
+		{link: "https://filelu.com/abcdefghijkl", want: "abcdefghijkl"},
+		{link: "https://www.filelu.com/abcdefghijkl/name.html", want: "abcdefghijkl"},
+		{link: "http://filelu.com/d/abcdefghijkl?x=1", want: "abcdefghijkl"},
+		{link: "https://example.com/abcdefghijkl", wantErr: true},
+		{link: "https://filelu.com/about", wantErr: true},
+		{link: "123456789012", wantErr: true},
+		{link: "not a link", wantErr: true},
+	} {
+		got, err := fileCodeFromLink(test.link)
+		if test.wantErr {
+			assert.Error(t, err, test.link)
+			continue
+		}
+		require.NoError(t, err, test.link)
+		assert.Equal(t, test.want, got, test.link)
+	}
+
+	var calls []string
+	f := newTestFs(t, "", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		switch r.URL.Path {
+		case "/folder/list":
+			writeJSON(t, w, map[string]interface{}{"status": 200, "result": map[string]interface{}{"folders": []map[string]interface{}{{"name": "saved", "fld_id": 3}}}})
+		case "/file/clone":
+			calls = append(calls, "clone "+q.Get("file_code"))
+			writeJSON(t, w, map[string]interface{}{"status": 200, "result": map[string]string{"filecode": "mnopqrstuvwx"}})
+		case "/file/set_folder":
+			calls = append(calls, "set_folder "+q.Get("file_code")+" "+q.Get("destination_folder_path"))
+			writeJSON(t, w, map[string]interface{}{"status": 200})
+		default:
+			t.Errorf("unexpected request %q", r.URL.Path)
+		}
+	}))
+	out, err := f.Command(ctx, "importlink", []string{"saved", "https://filelu.com/abcdefghijkl/name.html"}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, &importLinkResult{FileCode: "mnopqrstuvwx", Folder: "/saved"}, out)
+	assert.Equal(t, []string{"clone abcdefghijkl", "set_folder mnopqrstuvwx /saved"}, calls)
+
+	_, err = f.Command(ctx, "importlink", []string{"https://example.com/abcdefghijkl"}, nil)
+	assert.Error(t, err)
+}
+
+func TestOpenRetryCDN(t *testing.T) {
+	ctx := context.Background()
+	var (
+		srvURL    string
+		link      = "/download"
+		downloads int
+		linkCalls int
+	)
+	f := newTestFs(t, "", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/file/direct_link":
+			linkCalls++
+			writeJSON(t, w, map[string]interface{}{"status": 200, "result": map[string]interface{}{"url": srvURL + link, "size": 5}})
+		case "/download":
+			downloads++
+			if downloads <= 2 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			_, _ = io.WriteString(w, "hello")
+		case "/gone":
+			downloads++
+			w.WriteHeader(http.StatusNotFound)
+		default:
+			t.Errorf("unexpected request %q", r.URL.Path)
+		}
+	}))
+	srvURL = f.endpoint
+	o := &Object{fs: f, remote: "file.txt", fileCode: "cccccccccccc", size: 5, hasSize: true}
+
+	in, err := o.Open(ctx)
+	require.NoError(t, err)
+	data, err := io.ReadAll(in)
+	require.NoError(t, err)
+	require.NoError(t, in.Close())
+	assert.Equal(t, "hello", string(data))
+	assert.Equal(t, 3, downloads)
+	assert.Equal(t, 1, linkCalls)
+
+	// A missing file is only retried once with a new link
+	downloads, linkCalls = 0, 0
+	link = "/gone"
+	f.forgetDirectLink(o.fileCode, "")
+	_, err = o.Open(ctx)
+	assert.ErrorContains(t, err, "received HTTP status 404")
+	assert.Equal(t, 2, downloads)
+	assert.Equal(t, 2, linkCalls)
+}
+
+func TestPutTiny(t *testing.T) {
+	ctx := context.Background()
+	for _, content := range []string{"", "x"} {
+		t.Run(fmt.Sprintf("%d bytes", len(content)), func(t *testing.T) {
+			var (
+				srvURL   string
+				uploaded []byte
+			)
+			f := newTestFs(t, "", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch r.URL.Path {
+				case "/folder/list":
+					writeJSON(t, w, map[string]interface{}{"status": 200, "result": map[string]interface{}{}})
+				case "/upload/server":
+					writeJSON(t, w, map[string]interface{}{"status": 200, "sess_id": "sess", "result": srvURL + "/upload"})
+				case "/upload":
+					file, _, err := r.FormFile("file_0")
+					require.NoError(t, err)
+					uploaded, err = io.ReadAll(file)
+					require.NoError(t, err)
+					writeJSON(t, w, []map[string]string{{"file_code": "tttttttttttt", "file_status": "OK"}})
+				default:
+					t.Errorf("unexpected request %q", r.URL.Path)
+				}
+			}))
+			srvURL = f.endpoint
+
+			src := object.NewStaticObjectInfo("tiny.txt", time.Now(), int64(len(content)), true, nil, nil)
+			obj, err := f.Put(ctx, strings.NewReader(content), src)
+			require.NoError(t, err)
+			assert.Equal(t, content, string(uploaded))
+			assert.Equal(t, int64(len(content)), obj.Size())
+		})
+	}
+}
+
+func TestFolderIDInPath(t *testing.T) {
+	ctx := context.Background()
+	var listedPaths []string
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/folder/list", r.URL.Path)
+		q := r.URL.Query()
+		var folders []map[string]interface{}
+		switch {
+		case q.Get("fld_id") == "0", !q.Has("fld_id") && q.Get("folder_path") == "":
+			folders = []map[string]interface{}{{"name": "dup", "fld_id": 5}, {"name": "dup", "fld_id": 6}}
+		case q.Get("fld_id") == "6":
+			folders = []map[string]interface{}{{"name": "sub", "fld_id": 7}}
+		default:
+			listedPaths = append(listedPaths, q.Get("folder_path"))
+		}
+		writeJSON(t, w, map[string]interface{}{"status": 200, "result": map[string]interface{}{"folders": folders}})
+	})
+
+	names := func(entries fs.DirEntries) (out []string) {
+		for _, entry := range entries {
+			out = append(out, entry.Remote())
+		}
+		return out
+	}
+
+	// Plain names by default
+	f := newTestFs(t, "", handler)
+	entries, err := f.List(ctx, "")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"dup", "dup"}, names(entries))
+	id, err := f.dirCache.FindDir(ctx, "dup", false)
+	require.NoError(t, err)
+	assert.Equal(t, "5", id)
+
+	// Decorated names when asked for, which are found by ID
+	f = newTestFsOpt(t, "", configmap.Simple{"folder_id_in_path": "true"}, handler)
+	entries, err = f.List(ctx, "")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"(5) dup", "(6) dup"}, names(entries))
+	id, err = f.dirCache.FindDir(ctx, "(6) dup/sub", false)
+	require.NoError(t, err)
+	assert.Equal(t, "7", id)
+
+	// The decorations aren't sent to FileLu
+	_, err = f.List(ctx, "(6) dup/sub")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"/dup/sub"}, listedPaths)
+}
+
+func TestMimeType(t *testing.T) {
+	ctx := context.Background()
+	infoCalls := 0
+	f := newTestFs(t, "", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/file/info", r.URL.Path)
+		infoCalls++
+		var info map[string]string
+		if r.URL.Query().Get("file_code") == "vvvvvvvvvvvv" {
+			info = map[string]string{"mime_type": "video/x-matroska"}
+		} else {
+			info = map[string]string{"name": "notes.txt"}
+		}
+		writeJSON(t, w, map[string]interface{}{"status": 200, "result": []map[string]string{info}})
+	}))
+
+	// The type from file/info is used and remembered
+	o := &Object{fs: f, remote: "film.bin", fileCode: "vvvvvvvvvvvv"}
+	assert.Equal(t, "video/x-matroska", o.MimeType(ctx))
+	assert.Equal(t, "video/x-matroska", o.MimeType(ctx))
+	assert.Equal(t, 1, infoCalls)
+
+	// Otherwise it comes from the extension
+	o = &Object{fs: f, remote: "dir/notes.txt", fileCode: "tttttttttttt"}
+	assert.Equal(t, "text/plain; charset=utf-8", o.MimeType(ctx))
+	assert.Equal(t, 2, infoCalls)
+	o = &Object{fs: f, remote: "photo.jpg"}
+	assert.Equal(t, "image/jpeg", o.MimeType(ctx))
+	assert.Equal(t, 2, infoCalls)
+}
+
+func TestOpenSeek(t *testing.T) {
+	ctx := context.Background()
+	f, m := newMockFs(t, "", nil)
+	content := "0123456789abcdefghijklmnopqrstuvwxyz"
+	m.addFile("seek.txt", content)
+	o, err := f.NewObject(ctx, "seek.txt")
+	require.NoError(t, err)
+
+	in, err := o.Open(ctx)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, in.Close()) }()
+	buf := make([]byte, 4)
+	_, err = io.ReadFull(in, buf)
+	require.NoError(t, err)
+	assert.Equal(t, "0123", string(buf))
+	assert.Equal(t, 1, m.callCount("/download/"+o.(*Object).fileCode))
+
+	// Seeking mid-file fetches from the new position
+	seeker, ok := in.(io.Seeker)
+	require.True(t, ok)
+	pos, err := seeker.Seek(20, io.SeekStart)
+	require.NoError(t, err)
+	assert.Equal(t, int64(20), pos)
+	_, err = io.ReadFull(in, buf)
+	require.NoError(t, err)
+	assert.Equal(t, "klmn", string(buf))
+
+	pos, err = seeker.Seek(-2, io.SeekCurrent)
+	require.NoError(t, err)
+	assert.Equal(t, int64(22), pos)
+	_, err = io.ReadFull(in, buf)
+	require.NoError(t, err)
+	assert.Equal(t, "mnop", string(buf))
+
+	pos, err = seeker.Seek(-3, io.SeekEnd)
+	require.NoError(t, err)
+	assert.Equal(t, int64(33), pos)
+	data, err := io.ReadAll(in)
+	require.NoError(t, err)
+	assert.Equal(t, "xyz", string(data))
+
+	// RangeSeek limits how much is read
+	rs, ok := in.(fs.RangeSeeker)
+	require.True(t, ok)
+	_, err = rs.RangeSeek(ctx, 10, io.SeekStart, 5)
+	require.NoError(t, err)
+	data, err = io.ReadAll(in)
+	require.NoError(t, err)
+	assert.Equal(t, "abcde", string(data))
+
+	_, err = seeker.Seek(-1, io.SeekStart)
+	assert.Error(t, err)
+
+	// A ranged Open starts at the range and seeks relative to it
+	in2, err := o.Open(ctx, &fs.RangeOption{Start: 30, End: 31})
+	require.NoError(t, err)
+	data, err = io.ReadAll(in2)
+	require.NoError(t, err)
+	assert.Equal(t, "uv", string(data))
+	pos, err = in2.(io.Seeker).Seek(0, io.SeekCurrent)
+	require.NoError(t, err)
+	assert.Equal(t, int64(32), pos)
+	require.NoError(t, in2.Close())
+}
+
+func TestUploadRetries(t *testing.T) {
+	ctx := context.Background()
+	content := "important data"
+	src := object.NewStaticObjectInfo("dir/file.txt", time.Now(), int64(len(content)), true, nil, nil)
+	fileContents := func(m *mockServer) (contents []string) {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		for _, file := range m.files {
+			contents = append(contents, string(file.content))
+		}
+		return contents
+	}
+
+	// The first upload is corrupted and the retry succeeds
+	f, m := newMockFs(t, "", configmap.Simple{"upload_retries": "1"})
+	m.corrupt = 1
+	obj, err := f.Put(ctx, strings.NewReader(content), src)
+	require.NoError(t, err)
+	assert.Equal(t, 2, m.callCount("/upload"))
+	assert.Equal(t, 1, m.callCount("/file/remove"))
+	assert.Equal(t, []string{content}, fileContents(m))
+	sum, err := obj.Hash(ctx, hash.MD5)
+	require.NoError(t, err)
+	assert.Equal(t, fmt.Sprintf("%x", md5.Sum([]byte(content))), sum)
+
+	// Updates are checked too, keeping the old content if all fail
+	newContent := "more important data"
+	newSrc := object.NewStaticObjectInfo("dir/file.txt", time.Now(), int64(len(newContent)), true, nil, nil)
+	m.corrupt = 1
+	require.NoError(t, obj.Update(ctx, strings.NewReader(newContent), newSrc))
+	assert.Equal(t, 4, m.callCount("/upload"))
+	assert.Equal(t, []string{newContent}, fileContents(m))
+	m.corrupt = 2
+	err = obj.Update(ctx, strings.NewReader(content), src)
+	assert.ErrorContains(t, err, "after 2 tries")
+	assert.Equal(t, []string{newContent}, fileContents(m))
+
+	// Give up when all the tries are corrupted, leaving nothing behind
+	f, m = newMockFs(t, "", configmap.Simple{"upload_retries": "1"})
+	m.corrupt = 2
+	_, err = f.Put(ctx, strings.NewReader(content), src)
+	assert.ErrorContains(t, err, "after 2 tries")
+	assert.Equal(t, 2, m.callCount("/upload"))
+	assert.Empty(t, fileContents(m))
+
+	// Uploads aren't checked by default
+	f, m = newMockFs(t, "", nil)
+	m.corrupt = 1
+	_, err = f.Put(ctx, strings.NewReader(content), src)
+	require.NoError(t, err)
+	assert.Equal(t, 1, m.callCount("/upload"))
+	assert.Equal(t, 0, m.callCount("/file/info"))
+}
+
+func TestFolderResultShapes(t *testing.T) {
+	ctx := context.Background()
+	for _, test := range []struct {
+		name   string
+		result interface{}
+	}{
+		{"String", "Folder created"},
+		{"Object", map[string]interface{}{"fld_id": 7}},
+		{"StringID", map[string]interface{}{"fld_id": "7"}},
+		{"Array", []interface{}{map[string]interface{}{"fld_id": 7}}},
+		{"EmptyArray", []interface{}{}},
+		{"Missing", nil},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			var calls []string
+			f := newTestFs(t, "", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				calls = append(calls, r.URL.Path)
+				switch r.URL.Path {
+				case "/folder/list":
+					result := map[string]interface{}{}
+					if r.FormValue("fld_id") == "0" {
+						result["folders"] = []map[string]interface{}{{"name": "new", "fld_id": 7}}
+					}
+					writeJSON(t, w, map[string]interface{}{"status": 200, "result": result})
+				case "/folder/create", "/folder/delete", "/folder/rename", "/folder/move":
+					writeJSON(t, w, map[string]interface{}{"status": 200, "msg": "OK", "result": test.result})
+				default:
+					t.Errorf("unexpected request %q", r.URL.Path)
+				}
+			}))
+
+			id, err := f.CreateDir(ctx, "0", "new")
+			require.NoError(t, err)
+			assert.Equal(t, "7", id)
+			require.NoError(t, f.renameFolder(ctx, "new", "renamed"))
+			require.NoError(t, f.moveFolderToDestination(ctx, "renamed", "other"))
+			require.NoError(t, f.Rmdir(ctx, "new"))
+			assert.Contains(t, calls, "/folder/delete")
+		})
+	}
+}
+
+func TestRmdirFilledMeanwhile(t *testing.T) {
+	ctx := context.Background()
+	lists := 0
+	f := newTestFs(t, "", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/folder/list":
+			// Empty when checked, then a file and a folder arrive
+			lists++
+			result := map[string]interface{}{}
+			if lists > 1 {
+				result["files"] = []map[string]interface{}{{"name": "late.txt", "file_code": "abcdefghijkl"}}
+				result["folders"] = []map[string]interface{}{{"name": "late", "fld_id": 5}}
+			}
+			writeJSON(t, w, map[string]interface{}{"status": 200, "result": result})
+		case "/folder/delete":
+			writeJSON(t, w, map[string]interface{}{"status": 400, "msg": "Folder is not empty"})
+		default:
+			t.Errorf("unexpected request %q", r.URL.Path)
+		}
+	}))
+
+	err := f.Rmdir(ctx, "dir")
+	assert.ErrorIs(t, err, fs.ErrorDirectoryNotEmpty)
+	assert.ErrorContains(t, err, "it now holds 1 files and 1 folders: Folder is not empty")
+	assert.Equal(t, 2, lists)
+}
+
+func TestMkdirLostRace(t *testing.T) {
+	ctx := context.Background()
+	f, m := newMockFs(t, "", nil)
+
+	// Mkdir succeeds when someone else made the folder first
+	m.lostRace = 1
+	require.NoError(t, f.Mkdir(ctx, "shared"))
+	assert.Equal(t, 1, m.callCount("/folder/create"))
+	id, ok := m.resolveFolder("/shared")
+	require.True(t, ok)
+
+	// Making the parents of an upload uses the folder which won
+	m.lostRace = 2
+	content := "x"
+	src := object.NewStaticObjectInfo("shared/a/b/file.txt", time.Now(), int64(len(content)), true, nil, nil)
+	_, err := f.Put(ctx, strings.NewReader(content), src)
+	require.NoError(t, err)
+	b, ok := m.resolveFolder("/shared/a/b")
+	require.True(t, ok)
+	cached, err := f.dirCache.FindDir(ctx, "shared/a/b", false)
+	require.NoError(t, err)
+	assert.Equal(t, strconv.FormatInt(b, 10), cached)
+	m.mu.Lock()
+	assert.Len(t, m.folders, 3)
+	assert.Equal(t, id, m.folders[m.folders[b].parent].parent)
+	m.mu.Unlock()
+	_, err = f.NewObject(ctx, "shared/a/b/file.txt")
+	assert.NoError(t, err)
+}
+
+func TestHashOnList(t *testing.T) {
+	ctx := context.Background()
+	content := "listed"
+	want := fmt.Sprintf("%x", md5.Sum([]byte(content)))
+	for _, test := range []struct {
+		hashOnList string
+		calls      int
+	}{
+		{hashOnList: "true", calls: 0},
+		{hashOnList: "false", calls: 1},
+	} {
+		f, m := newMockFs(t, "", configmap.Simple{"hash_on_list": test.hashOnList})
+		m.addFile("dir/file.txt", content)
+		entries, err := f.List(ctx, "dir")
+		require.NoError(t, err)
+		require.Len(t, entries, 1)
+
+		// Count the requests Hash makes
+		m.mu.Lock()
+		m.calls = map[string]int{}
+		m.mu.Unlock()
+		sum, err := entries[0].(fs.Object).Hash(ctx, hash.MD5)
+		require.NoError(t, err)
+		assert.Equal(t, want, sum, test.hashOnList)
+		m.mu.Lock()
+		calls := len(m.calls)
+		m.mu.Unlock()
+		assert.Equal(t, test.calls, calls, test.hashOnList)
+		assert.Equal(t, test.calls, m.callCount("/file/info"), test.hashOnList)
+	}
+}
+
+func TestListFileCodeRoot(t *testing.T) {
+	ctx := context.Background()
+	m := newMockServer(t)
+	code := m.addFile("dir/report.pdf", "twelve bytes")
+	require.True(t, isFileCode(code))
+	f := newTestFsOpt(t, code, nil, m)
+
+	entries, err := f.List(ctx, "")
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	o := entries[0].(*Object)
+	assert.Equal(t, "report.pdf", o.Remote())
+	assert.Equal(t, int64(12), o.Size())
+	want := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	assert.True(t, want.Equal(o.ModTime(ctx)), o.ModTime(ctx))
+	assert.Equal(t, 0, m.callCount("/file/direct_link"))
+
+	// Listing again gives the same modtime
+	entries, err = f.List(ctx, "")
+	require.NoError(t, err)
+	assert.True(t, want.Equal(entries[0].ModTime(ctx)))
+
+	// The file can be found by name
+	obj, err := f.NewObject(ctx, "report.pdf")
+	require.NoError(t, err)
+	assert.Equal(t, code, obj.(*Object).fileCode)
+	_, err = f.NewObject(ctx, "other.pdf")
+	assert.Equal(t, fs.ErrorObjectNotFound, err)
+	assert.Equal(t, 0, m.callCount("/file/direct_link"))
+}
+
+func TestCopyMoveOtherAccount(t *testing.T) {
+	ctx := context.Background()
+	m := newMockServer(t)
+	src := newTestFsOpt(t, "", configmap.Simple{"FileLu Rclone Key": "alice"}, m)
+	dst := newTestFsOpt(t, "", configmap.Simple{"FileLu Rclone Key": "bob"}, m)
+	m.addFile("shared/a.txt", "aaa")
+	m.addFile("shared/b.txt", "bbb")
+
+	// Copying clones the file into the other account
+	srcObj, err := src.NewObject(ctx, "shared/a.txt")
+	require.NoError(t, err)
+	dstObj, err := dst.Copy(ctx, srcObj, "backup/a.txt")
+	require.NoError(t, err)
+	assert.Equal(t, 1, m.callCount("/file/clone"))
+	assert.Equal(t, 0, m.callCount("/upload"))
+	assert.NotEqual(t, srcObj.(*Object).fileCode, dstObj.(*Object).fileCode)
+	_, err = dst.NewObject(ctx, "backup/a.txt")
+	require.NoError(t, err)
+
+	// Moving clones then removes the original
+	srcObj, err = src.NewObject(ctx, "shared/b.txt")
+	require.NoError(t, err)
+	_, err = dst.Move(ctx, srcObj, "backup/b.txt")
+	require.NoError(t, err)
+	assert.Equal(t, 2, m.callCount("/file/clone"))
+	assert.Equal(t, 0, m.callCount("/upload"))
+	_, err = dst.NewObject(ctx, "backup/b.txt")
+	require.NoError(t, err)
+	_, err = src.NewObject(ctx, "shared/b.txt")
+	assert.Equal(t, fs.ErrorObjectNotFound, err)
+
+	// Files which can't be cloned are left for rclone to stream
+	gone := &Object{fs: src, remote: "shared/gone.txt", fileCode: "abcdefghijkl"}
+	_, err = dst.Copy(ctx, gone, "backup/gone.txt")
+	assert.Equal(t, fs.ErrorCantCopy, err)
+	_, err = dst.Move(ctx, gone, "backup/gone.txt")
+	assert.Equal(t, fs.ErrorCantMove, err)
+
+	// Within one account a failed clone is an error
+	_, err = src.Copy(ctx, gone, "backup/gone.txt")
+	assert.ErrorContains(t, err, "copy:")
+}
+
+func TestSyncFastList(t *testing.T) {
+	uploaded := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	srcFiles := map[string]string{
+		"same.txt":         "same",
+		"dir/same.txt":     "same too",
+		"dir/changed.txt":  "new content",
+		"dir/sub/new.txt":  "new",
+		"other/added.txt":  "added",
+		"other/nested/x.y": "x",
+	}
+	dstFiles := map[string]string{
+		"same.txt":        "same",
+		"dir/same.txt":    "same too",
+		"dir/changed.txt": "old",
+		"dir/extra.txt":   "extra",
+		"gone/file.txt":   "gone",
+	}
+
+	// runSync runs a sync from srcFiles to dstFiles and returns the files
+	// which end up in the mock and the number of uploads and deletes
+	runSync := func(fastList bool) (files map[string]string, uploads, deletes int) {
+		ctx, ci := fs.AddConfig(context.Background())
+		ci.UseListR = fastList
+		src, err := fs.NewFs(ctx, ":memory:"+t.Name()+fmt.Sprint(fastList))
+		require.NoError(t, err)
+		for remote, content := range srcFiles {
+			info := object.NewStaticObjectInfo(remote, uploaded, int64(len(content)), true, nil, nil)
+			_, err := src.Put(ctx, strings.NewReader(content), info)
+			require.NoError(t, err)
+		}
+		f, m := newMockFs(t, "", nil)
+		require.NotNil(t, f.Features().ListR)
+		for remote, content := range dstFiles {
+			m.addFile(remote, content)
+		}
+
+		require.NoError(t, fssync.Sync(ctx, f, src, false))
+
+		return m.contents(), m.callCount("/upload"), m.callCount("/file/remove")
+	}
+
+	files, uploads, deletes := runSync(false)
+	assert.Equal(t, srcFiles, files)
+	assert.Equal(t, 4, uploads)
+
+	fastFiles, fastUploads, fastDeletes := runSync(true)
+	assert.Equal(t, files, fastFiles)
+	assert.Equal(t, uploads, fastUploads)
+	assert.Equal(t, deletes, fastDeletes)
+}
+
+func TestUpdateFileCode(t *testing.T) {
+	ctx := context.Background()
+	f, m := newMockFs(t, "", nil)
+	oldCode := m.addFile("dir/file.txt", "old")
+	obj, err := f.NewObject(ctx, "dir/file.txt")
+	require.NoError(t, err)
+	o := obj.(*Object)
+	assert.Equal(t, oldCode, o.ID())
+
+	// Read it to cache the direct link of the old code
+	in, err := o.Open(ctx)
+	require.NoError(t, err)
+	require.NoError(t, in.Close())
+
+	content := "new content"
+	src := object.NewStaticObjectInfo("dir/file.txt", time.Now(), int64(len(content)), true, nil, nil)
+	require.NoError(t, o.Update(ctx, strings.NewReader(content), src))
+
+	// The update has a new code which the object now uses
+	newCode := o.ID()
+	assert.NotEqual(t, oldCode, newCode)
+	m.mu.Lock()
+	_, oldExists := m.files[oldCode]
+	m.mu.Unlock()
+	assert.False(t, oldExists)
+	_, cached := f.linkCache[directLinkKey(oldCode, "")]
+	assert.False(t, cached)
+
+	obj, err = f.NewObject(ctx, "dir/file.txt")
+	require.NoError(t, err)
+	assert.Equal(t, newCode, obj.(fs.IDer).ID())
+	in, err = o.Open(ctx)
+	require.NoError(t, err)
+	data, err := io.ReadAll(in)
+	require.NoError(t, err)
+	require.NoError(t, in.Close())
+	assert.Equal(t, content, string(data))
+}
+
+func TestMaintenanceRetry(t *testing.T) {
+	ctx := context.Background()
+	var (
+		mu          sync.Mutex
+		calls       int
+		maintenance int // number of calls to answer with the maintenance page
+	)
+	f := newTestFs(t, "", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		calls++
+		down := calls <= maintenance
+		mu.Unlock()
+		if down {
+			w.Header().Set("Content-Type", "text/html")
+			_, _ = io.WriteString(w, "<html><body>FileLu is down for maintenance</body></html>")
+			return
+		}
+		writeJSON(t, w, map[string]interface{}{"status": 200, "result": map[string]interface{}{
+			"folders": []map[string]interface{}{{"name": "a", "fld_id": 1}},
+		}})
+	}))
+
+	// The pacer retries until the maintenance is over
+	mu.Lock()
+	maintenance = 2
+	mu.Unlock()
+	folders, err := f.listFolders(ctx, rootFolderID)
+	require.NoError(t, err)
+	assert.Len(t, folders, 1)
+	assert.Equal(t, 3, calls)
+
+	// and gives up with a clear error if it isn't
+	mu.Lock()
+	calls, maintenance = 0, 1000
+	mu.Unlock()
+	_, err = f.listFolders(ctx, rootFolderID)
+	require.Error(t, err)
+	assert.True(t, errors.Is(err, errUnavailable), err)
+	assert.Contains(t, err.Error(), "temporarily unavailable")
+	assert.Equal(t, fs.GetConfig(ctx).LowLevelRetries, calls)
+}
+
+func TestMaintenanceRetryEveryCall(t *testing.T) {
+	ctx := context.Background()
+	f, m := newMockFs(t, "", nil)
+	m.mkdir("dir")
+	paths := []string{"/upload/server", "/file/set_folder", "/file/info", "/file/rename", "/folder/rename"}
+	for _, p := range paths {
+		m.down[p] = 1
+	}
+
+	src := object.NewStaticObjectInfo("dir/file.txt", time.Now(), 4, true, nil, nil)
+	obj, err := f.Put(ctx, strings.NewReader("data"), src)
+	require.NoError(t, err)
+	_, err = (&Object{fs: f, remote: obj.Remote(), fileCode: obj.(*Object).fileCode}).Hash(ctx, hash.MD5)
+	require.NoError(t, err)
+	require.NoError(t, f.renameFile(ctx, "dir/file.txt", "renamed.txt"))
+	require.NoError(t, f.renameFolder(ctx, "dir", "renamed"))
+
+	// Each call met the maintenance page once and was tried again
+	for _, p := range paths {
+		assert.Equal(t, 0, m.down[p], p)
+		assert.Equal(t, 2, m.callCount(p), p)
+	}
+	assert.Equal(t, map[string]string{"renamed/renamed.txt": "data"}, m.contents())
+}
+
+func TestMoveToNamedRoot(t *testing.T) {
+	ctx := context.Background()
+	f, m := newMockFs(t, "base/dir", nil)
+	m.mkdir("base/dir/sub")
+	m.mkdir("other")
+
+	src, err := fs.NewFs(ctx, ":memory:"+t.Name())
+	require.NoError(t, err)
+	content := "moved"
+	srcObj, err := src.Put(ctx, strings.NewReader(content), object.NewStaticObjectInfo("in/file.txt", time.Now(), int64(len(content)), true, nil, nil))
+	require.NoError(t, err)
+
+	dst, err := f.MoveTo(ctx, srcObj, "sub")
+	require.NoError(t, err)
+	assert.Equal(t, "sub/file.txt", dst.Remote())
+
+	// The file is in the folder under the root, not the account root
+	sub, ok := m.resolveFolder("base/dir/sub")
+	require.True(t, ok)
+	m.mu.Lock()
+	file, ok := m.files[dst.(*Object).fileCode]
+	m.mu.Unlock()
+	require.True(t, ok)
+	assert.Equal(t, sub, file.folder)
+	assert.Equal(t, "file.txt", file.name)
+	_, err = f.NewObject(ctx, "sub/file.txt")
+	assert.NoError(t, err)
+
+	// and the source is gone
+	_, err = src.NewObject(ctx, "in/file.txt")
+	assert.Equal(t, fs.ErrorObjectNotFound, err)
+}
+
+func TestProbeHashType(t *testing.T) {
+	ctx := context.Background()
+	sha256Sum := strings.Repeat("ab", 32)
+	for _, test := range []struct {
+		name   string
+		status int
+		files  []map[string]interface{}
+		want   hash.Type
+	}{
+		{name: "md5", status: 200, files: []map[string]interface{}{{"name": "a", "hash": strings.Repeat("0", 32)}}, want: hash.MD5},
+		{name: "sha1", status: 200, files: []map[string]interface{}{{"name": "a", "hash": strings.Repeat("1", 40)}}, want: hash.SHA1},
+		{name: "sha256", status: 200, files: []map[string]interface{}{{"name": "a", "hash": ""}, {"name": "b", "hash": sha256Sum}}, want: hash.SHA256},
+		{name: "unknown", status: 200, files: []map[string]interface{}{{"name": "a", "hash": "not hex"}}, want: hash.MD5},
+		{name: "empty", status: 200, want: hash.MD5},
+		{name: "error", status: 403, want: hash.MD5},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			f := newTestFs(t, "", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				assert.Equal(t, "/folder/list", r.URL.Path)
+				writeJSON(t, w, map[string]interface{}{"status": test.status, "result": map[string]interface{}{"files": test.files}})
+			}))
+			f.probeHashType(ctx)
+			assert.Equal(t, hash.NewHashSet(test.want), f.Hashes())
+
+			// Objects report the hash as that type only
+			o := &Object{fs: f, remote: "b", hash: sha256Sum}
+			sum, err := o.Hash(ctx, test.want)
+			require.NoError(t, err)
+			assert.Equal(t, sha256Sum, sum)
+			for _, other := range []hash.Type{hash.MD5, hash.SHA1, hash.SHA256} {
+				if other != test.want {
+					_, err = o.Hash(ctx, other)
+					assert.Equal(t, hash.ErrUnsupported, err)
+				}
+			}
+		})
+	}
+}
+
+func TestPutMoveFailureCleanup(t *testing.T) {
+	ctx := context.Background()
+	content := "stray data"
+	src := object.NewStaticObjectInfo("dir/file.txt", time.Now(), int64(len(content)), true, nil, nil)
+	fileCount := func(m *mockServer) int {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		return len(m.files)
+	}
+
+	// A failed Put removes the upload from the root
+	f, m := newMockFs(t, "", nil)
+	m.failMove = 1
+	_, err := f.Put(ctx, strings.NewReader(content), src)
+	assert.ErrorContains(t, err, "Move failed")
+	assert.Equal(t, 1, m.callCount("/file/remove"))
+	assert.Equal(t, 0, fileCount(m))
+
+	// A failed Update leaves the old file alone
+	obj, err := f.Put(ctx, strings.NewReader(content), src)
+	require.NoError(t, err)
+	m.failMove = 1
+	err = obj.Update(ctx, strings.NewReader("new data"), src)
+	assert.ErrorContains(t, err, "Move failed")
+	assert.Equal(t, 1, fileCount(m))
+	_, err = f.NewObject(ctx, "dir/file.txt")
+	assert.NoError(t, err)
+}
+
+func TestListRecurseLimit(t *testing.T) {
+	ctx := context.Background()
+	listR := func(limit string) (remotes []string) {
+		f, m := newMockFs(t, "", configmap.Simple{"list_recurse_limit": limit})
+		m.addFile("top.txt", "0")
+		m.addFile("a/one.txt", "1")
+		m.addFile("a/b/two.txt", "2")
+		m.addFile("a/b/c/three.txt", "3")
+		err := f.ListR(ctx, "", func(entries fs.DirEntries) error {
+			for _, entry := range entries {
+				remotes = append(remotes, entry.Remote())
+			}
+			return nil
+		})
+		require.NoError(t, err)
+		sort.Strings(remotes)
+		return remotes
+	}
+
+	assert.Equal(t, []string{"a", "a/b", "a/b/c", "a/b/c/three.txt", "a/b/two.txt", "a/one.txt", "top.txt"}, listR("0"))
+	assert.Equal(t, []string{"a", "a/b", "a/one.txt", "top.txt"}, listR("2"))
+	assert.Equal(t, []string{"a", "top.txt"}, listR("1"))
+}
+
+func TestMkdirCached(t *testing.T) {
+	ctx := context.Background()
+	f, m := newMockFs(t, "base", nil)
+	m.mkdir("base/old")
+
+	// A folder which exists isn't made again
+	require.NoError(t, f.Mkdir(ctx, "old"))
+	assert.Equal(t, 0, m.callCount("/folder/create"))
+
+	// and one made is remembered
+	require.NoError(t, f.Mkdir(ctx, "a/b"))
+	assert.Equal(t, 2, m.callCount("/folder/create"))
+	lists := m.callCount("/folder/list")
+	require.NoError(t, f.Mkdir(ctx, "a/b"))
+	assert.Equal(t, 2, m.callCount("/folder/create"))
+	assert.Equal(t, lists, m.callCount("/folder/list"))
+	id, ok := m.resolveFolder("/base/a/b")
+	require.True(t, ok)
+	cached, ok := f.dirCache.Get("base/a/b")
+	require.True(t, ok)
+	assert.Equal(t, strconv.FormatInt(id, 10), cached)
+}
+
+func TestStrictMkdir(t *testing.T) {
+	ctx := context.Background()
+
+	// Missing parents are made normally
+	f, m := newMockFs(t, "", nil)
+	require.NoError(t, f.Mkdir(ctx, "a/b/c"))
+	_, ok := m.resolveFolder("/a/b/c")
+	assert.True(t, ok)
+
+	// but not when strict
+	f, m = newMockFs(t, "", configmap.Simple{"strict_mkdir": "true"})
+	err := f.Mkdir(ctx, "a/b/c")
+	assert.ErrorIs(t, err, fs.ErrorDirNotFound)
+	assert.Equal(t, 0, m.callCount("/folder/create"))
+	_, ok = m.resolveFolder("/a")
+	assert.False(t, ok)
+
+	// A folder whose parent exists is still made when strict
+	m.mkdir("a/b")
+	require.NoError(t, f.Mkdir(ctx, "a/b/c"))
+	_, ok = m.resolveFolder("/a/b/c")
+	assert.True(t, ok)
+}
+
+func TestOpenRedirect(t *testing.T) {
+	ctx := context.Background()
+	f, m := newMockFs(t, "", nil)
+	content := "0123456789abcdefghijklmnopqrstuvwxyz"
+	m.addFile("file.txt", content)
+	o, err := f.NewObject(ctx, "file.txt")
+	require.NoError(t, err)
+
+	// The range survives the redirects to the download
+	m.redirect = 3
+	in, err := o.Open(ctx, &fs.RangeOption{Start: 10, End: 15})
+	require.NoError(t, err)
+	data, err := io.ReadAll(in)
+	require.NoError(t, err)
+	require.NoError(t, in.Close())
+	assert.Equal(t, "abcdef", string(data))
+	assert.Equal(t, 1, m.callCount("/download/"+o.(*Object).fileCode))
+
+	// A link which redirects to itself fails clearly
+	m.redirect = -1
+	f.forgetDirectLink(o.(*Object).fileCode, "")
+	_, err = o.Open(ctx)
+	assert.ErrorIs(t, err, errRedirectLoop)
+}
+
+func TestVersion(t *testing.T) {
+	ctx := context.Background()
+	f, m := newMockFs(t, "", nil)
+	code := m.addFile("dir/file.txt", "old")
+	obj, err := f.NewObject(ctx, "dir/file.txt")
+	require.NoError(t, err)
+	o := obj.(*Object)
+
+	read := func() string {
+		in, err := o.Open(ctx)
+		require.NoError(t, err)
+		data, err := io.ReadAll(in)
+		require.NoError(t, err)
+		require.NoError(t, in.Close())
+		return string(data)
+	}
+
+	assert.Equal(t, "old", read())
+	assert.Equal(t, 1, m.callCount("/file/direct_link"))
+	version, err := o.Version(ctx)
+	require.NoError(t, err)
+
+	// An unchanged file keeps its cached link
+	again, err := o.Version(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, version, again)
+	assert.Equal(t, "old", read())
+	assert.Equal(t, 1, m.callCount("/file/direct_link"))
+
+	// Changing the file outside rclone gets a new link
+	m.mu.Lock()
+	m.files[code].content = []byte("newer")
+	m.files[code].uploaded = m.files[code].uploaded.Add(time.Hour)
+	m.mu.Unlock()
+	changed, err := o.Version(ctx)
+	require.NoError(t, err)
+	assert.NotEqual(t, version, changed)
+	assert.Equal(t, int64(5), o.Size())
+	assert.Equal(t, "newer", read())
+	assert.Equal(t, 2, m.callCount("/file/direct_link"))
+
+	// As does one read by file code
+	o, err = f.fileCodeObject(ctx, code)
+	require.NoError(t, err)
+	m.mu.Lock()
+	m.files[code].content = []byte("newest")
+	m.mu.Unlock()
+	_, err = o.Version(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "newest", read())
+	assert.Equal(t, 3, m.callCount("/file/direct_link"))
+}
+
+func TestOpenMirrors(t *testing.T) {
+	ctx := context.Background()
+	f, m := newMockFs(t, "", nil)
+	content := "0123456789abcdefghijklmnopqrstuvwxyz"
+	code := m.addFile("file.txt", content)
+	o, err := f.NewObject(ctx, "file.txt")
+	require.NoError(t, err)
+
+	// The first mirror is unavailable so the download moves on
+	m.mirrors = 1
+	in, err := o.Open(ctx, &fs.RangeOption{Start: 10, End: 15})
+	require.NoError(t, err)
+	data, err := io.ReadAll(in)
+	require.NoError(t, err)
+	require.NoError(t, in.Close())
+	assert.Equal(t, "abcdef", string(data))
+	assert.Equal(t, 1, m.callCount("/unavailable/0/"+code))
+	assert.Equal(t, 1, m.callCount("/download/"+code))
+	assert.Equal(t, 1, m.callCount("/file/direct_link"))
+
+	// Every mirror is tried whatever the order
+	f.opt.MirrorSelection = mirrorSelectionRandom
+	f.forgetDirectLink(code, "")
+	m.mirrors = 3
+	for i := 0; i < 5; i++ {
+		in, err = o.Open(ctx)
+		require.NoError(t, err)
+		data, err = io.ReadAll(in)
+		require.NoError(t, err)
+		require.NoError(t, in.Close())
+		assert.Equal(t, content, string(data))
+	}
+	assert.Equal(t, 6, m.callCount("/download/"+code))
+}
+
+func TestOverwrite(t *testing.T) {
+	ctx := context.Background()
+	src := object.NewStaticObjectInfo("dir/file.txt", time.Now(), 3, true, nil, nil)
+	for _, test := range []struct {
+		overwrite string
+		wantErr   bool
+		want      map[string]string
+	}{
+		{overwrite: "replace", want: map[string]string{"dir/file.txt": "new"}},
+		{overwrite: "error", wantErr: true, want: map[string]string{"dir/file.txt": "old"}},
+		{overwrite: "rename", want: map[string]string{"dir/file.txt": "old", "dir/file (1).txt": "new"}},
+	} {
+		t.Run(test.overwrite, func(t *testing.T) {
+			// Put over an existing file
+			f, m := newMockFs(t, "", configmap.Simple{"overwrite": test.overwrite})
+			m.addFile("dir/file.txt", "old")
+			_, err := f.Put(ctx, strings.NewReader("new"), src)
+			if test.wantErr {
+				assert.True(t, fserrors.IsNoRetryError(err))
+			} else {
+				require.NoError(t, err)
+			}
+			assert.Equal(t, test.want, m.contents())
+
+			// Update an existing file
+			f, m = newMockFs(t, "", configmap.Simple{"overwrite": test.overwrite})
+			m.addFile("dir/file.txt", "old")
+			obj, err := f.NewObject(ctx, "dir/file.txt")
+			require.NoError(t, err)
+			err = obj.Update(ctx, strings.NewReader("new"), src)
+			if test.wantErr {
+				assert.True(t, fserrors.IsNoRetryError(err))
+			} else {
+				require.NoError(t, err)
+			}
+			assert.Equal(t, test.want, m.contents())
+			if test.overwrite == "rename" {
+				assert.Equal(t, "dir/file (1).txt", obj.Remote())
+			}
+		})
+	}
+
+	// Identical content is never uploaded again
+	f, m := newMockFs(t, "", configmap.Simple{"overwrite": "rename"})
+	m.addFile("dir/file.txt", "new")
+	_, err := f.Put(ctx, strings.NewReader("new"), src)
+	require.NoError(t, err)
+	assert.Equal(t, 0, m.callCount("/upload"))
+}
+
+func TestUploadServerBadURL(t *testing.T) {
+	ctx := context.Background()
+	f := newTestFs(t, "", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/folder/list":
+			writeJSON(t, w, map[string]interface{}{"status": 200, "result": map[string]interface{}{}})
+		case "/upload/server":
+			writeJSON(t, w, map[string]interface{}{"status": 200, "sess_id": "sess", "result": "/cgi-bin/upload.cgi"})
+		default:
+			t.Errorf("unexpected request %q", r.URL.Path)
+		}
+	}))
+
+	src := object.NewStaticObjectInfo("file.txt", time.Now(), 5, true, nil, nil)
+	_, err := f.Put(ctx, strings.NewReader("hello"), src)
+	assert.ErrorContains(t, err, `upload server returned "/cgi-bin/upload.cgi" which isn't an absolute http(s) URL`)
+}
+
+func TestNoModTime(t *testing.T) {
+	ctx := context.Background()
+	f, m := newMockFs(t, "", configmap.Simple{"no_modtime": "true"})
+	m.addFile("dir/file.txt", "content")
+	assert.Equal(t, fs.ModTimeNotSupported, f.Precision())
+
+	entries, err := f.List(ctx, "dir")
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	o := entries[0].(*Object)
+	assert.True(t, o.modTime.IsZero())
+	assert.Equal(t, time.Unix(0, 0), o.ModTime(ctx))
+	assert.ErrorIs(t, o.SetModTime(ctx, time.Now()), fs.ErrorCantSetModTime)
+	assert.Equal(t, time.Unix(0, 0), o.ModTime(ctx))
+
+	// Only the listing was asked for
+	m.mu.Lock()
+	assert.Equal(t, map[string]int{"/folder/list": m.calls["/folder/list"]}, m.calls)
+	m.mu.Unlock()
+}
+
+func TestModTimeNotSupported(t *testing.T) {
+	ctx := context.Background()
+	f, m := newMockFs(t, "", nil)
+	m.addFile("file.txt", "content")
+	assert.Equal(t, fs.ModTimeNotSupported, f.Precision())
+
+	// The upload time is shown but can't be changed
+	o, err := f.NewObject(ctx, "file.txt")
+	require.NoError(t, err)
+	uploaded := o.ModTime(ctx)
+	assert.False(t, uploaded.Equal(time.Unix(0, 0)))
+	assert.ErrorIs(t, o.SetModTime(ctx, time.Now()), fs.ErrorCantSetModTime)
+	assert.Equal(t, uploaded, o.ModTime(ctx))
+}
+
+func TestConcurrentUploads(t *testing.T) {
+	ctx := context.Background()
+	f, m := newMockFs(t, "", nil)
+	m.uploadDelay = 20 * time.Millisecond
+
+	// Put one file so there is an idle session for the others to fight over
+	src := object.NewStaticObjectInfo("first.txt", time.Now(), 5, true, nil, nil)
+	_, err := f.Put(ctx, strings.NewReader("first"), src)
+	require.NoError(t, err)
+
+	const n = 8
+	want := map[string]string{"first.txt": "first"}
+	var wg sync.WaitGroup
+	errs := make([]error, n)
+	for i := 0; i < n; i++ {
+		remote := fmt.Sprintf("dir/file%d.txt", i)
+		content := fmt.Sprintf("content of file %d", i)
+		want[remote] = content
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			src := object.NewStaticObjectInfo(remote, time.Now(), int64(len(content)), true, nil, nil)
+			_, errs[i] = f.Put(ctx, strings.NewReader(content), src)
+		}(i)
+	}
+	wg.Wait()
+	for i, err := range errs {
+		assert.NoError(t, err, i)
+	}
+	assert.Equal(t, want, m.contents())
+	assert.Equal(t, n+1, m.callCount("/upload"))
+
+	// The sessions are reused by later uploads
+	sessions := m.callCount("/upload/server")
+	assert.LessOrEqual(t, sessions, n)
+	src = object.NewStaticObjectInfo("last.txt", time.Now(), 4, true, nil, nil)
+	_, err = f.Put(ctx, strings.NewReader("last"), src)
+	require.NoError(t, err)
+	assert.Equal(t, sessions, m.callCount("/upload/server"))
+}
+
+// TestConcurrentAccess runs listings, uploads and removals of the same
+// folders at once. Run it with -race to check the caches they share are
+// guarded.
+func TestAccountTypeUnlocked(t *testing.T) {
+	ctx := context.Background()
+	var f *Fs
+	calls := 0
+	f = newTestFsOpt(t, "", configmap.Simple{"account_type": accountTypeAuto}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/account/info", r.URL.Path)
+		calls++
+		// Other uploads mustn't wait on the lock for this call
+		if assert.True(t, f.accountTypeMu.TryLock()) {
+			f.accountTypeMu.Unlock()
+		}
+		writeJSON(t, w, map[string]interface{}{"status": 200, "result": map[string]interface{}{"utype": "free"}})
+	}))
+
+	assert.Equal(t, url.Values{"utype": {"reg"}}, f.uploadFields(ctx))
+	assert.Equal(t, url.Values{"utype": {"reg"}}, f.uploadFields(ctx))
+	assert.Equal(t, 1, calls)
+}
+
+func TestConcurrentAccess(t *testing.T) {
+	ctx := context.Background()
+	f, m := newMockFs(t, "", configmap.Simple{"list_cache_time": "1m"})
+	id := m.mkdir("dir")
+	for i := 0; i < 4; i++ {
+		m.addFile(fmt.Sprintf("dir/old%d.txt", i), "old")
+	}
+
+	const n = 4
+	var wg sync.WaitGroup
+	errs := make(chan error, 5*n)
+	for i := 0; i < n; i++ {
+		wg.Add(4)
+		go func() {
+			defer wg.Done()
+			_, err := f.List(ctx, "dir")
+			errs <- err
+			_, err = f.List(ctx, "")
+			errs <- err
+		}()
+		go func(i int) {
+			defer wg.Done()
+			content := fmt.Sprintf("content of file %d", i)
+			src := object.NewStaticObjectInfo(fmt.Sprintf("dir/new%d.txt", i), time.Now(), int64(len(content)), true, nil, nil)
+			_, err := f.Put(ctx, strings.NewReader(content), src)
+			errs <- err
+		}(i)
+		go func(i int) {
+			defer wg.Done()
+			o, err := f.NewObject(ctx, fmt.Sprintf("dir/old%d.txt", i))
+			if err == nil {
+				err = o.Remove(ctx)
+			}
+			errs <- err
+		}(i)
+		go func() {
+			defer wg.Done()
+			_, err := f.Command(ctx, "setfolderid", []string{"0"}, nil)
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		assert.NoError(t, err)
+	}
+
+	entries, err := f.List(ctx, "dir")
+	require.NoError(t, err)
+	assert.Len(t, entries, n)
+	folder, err := f.dirCache.FindDir(ctx, "dir", false)
+	require.NoError(t, err)
+	assert.Equal(t, strconv.FormatInt(id, 10), folder)
+}
+
+func TestAssumeRootFolderExists(t *testing.T) {
+	ctx := context.Background()
+	for _, assume := range []bool{false, true} {
+		var requests atomic.Int32
+		f := newTestFsOpt(t, "dir", configmap.Simple{"assume_root_folder_exists": strconv.FormatBool(assume)}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests.Add(1)
+			writeJSON(t, w, map[string]interface{}{"status": 200, "result": map[string]interface{}{}})
+		}))
+		f.start(ctx)
+		if assume {
+			assert.Equal(t, int32(0), requests.Load())
+		} else {
+			assert.Equal(t, int32(1), requests.Load())
+		}
+		assert.Equal(t, hash.NewHashSet(hash.MD5), f.Hashes())
+	}
+}
+
+func TestListShallow(t *testing.T) {
+	ctx := context.Background()
+	f, m := newMockFs(t, "", nil)
+	m.addFile("a/b/c/d/deep.txt", "deep")
+	m.addFile("a/b/file.txt", "file")
+
+	// Listing a folder lists it alone and nothing under it
+	entries, err := f.List(ctx, "a/b")
+	require.NoError(t, err)
+	assert.Len(t, entries, 2)
+	assert.Equal(t, 1, m.callCount("/folder/list"))
+
+	// The subfolders listed are used without looking up their parents
+	content := "new"
+	src := object.NewStaticObjectInfo("a/b/c/new.txt", time.Now(), int64(len(content)), true, nil, nil)
+	_, err = f.Put(ctx, strings.NewReader(content), src)
+	require.NoError(t, err)
+	assert.Equal(t, 2, m.callCount("/folder/list"))
+	assert.Equal(t, "new", m.contents()["a/b/c/new.txt"])
+}
+
+func TestCheckStable(t *testing.T) {
+	ctx := context.Background()
+	files := map[string]string{
+		"a.txt":         "a",
+		"dir/b.txt":     "bb",
+		"dir/sub/c.txt": "ccc",
+	}
+	src, err := fs.NewFs(ctx, ":memory:"+t.Name())
+	require.NoError(t, err)
+	f, m := newMockFs(t, "", nil)
+	for remote, content := range files {
+		info := object.NewStaticObjectInfo(remote, time.Now(), int64(len(content)), true, nil, nil)
+		_, err := src.Put(ctx, strings.NewReader(content), info)
+		require.NoError(t, err)
+		m.addFile(remote, content)
+	}
+
+	for i := 1; i <= 2; i++ {
+		var match, differ, combined bytes.Buffer
+		err := operations.Check(ctx, &operations.CheckOpt{
+			Fdst:     f,
+			Fsrc:     src,
+			OneWay:   true,
+			Match:    &match,
+			Differ:   &differ,
+			Combined: &combined,
+		})
+		require.NoError(t, err, "check %d: %s", i, combined.String())
+		assert.Empty(t, differ.String(), "check %d", i)
+		assert.Equal(t, 3, strings.Count(match.String(), "\n"), "check %d", i)
+	}
+}
+
+func TestListStable(t *testing.T) {
+	ctx := context.Background()
+	f := newTestFs(t, "", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(t, w, map[string]interface{}{"status": 200, "result": map[string]interface{}{
+			"folders": []map[string]interface{}{{"name": "dir", "fld_id": 1}},
+			"files": []map[string]interface{}{
+				{"name": "good.txt", "file_code": "aaaaaaaaaaaa", "size": 1, "hash": "h1", "uploaded": "2024-01-02 03:04:05"},
+				{"name": "bad.txt", "file_code": "bbbbbbbbbbbb", "size": 2, "hash": "h2", "uploaded": "not a time"},
+			},
+		}})
+	}))
+	f.opt.HashOnList = true
+
+	// describe returns what check sees of each entry
+	describe := func() (out []string) {
+		entries, err := f.listDirectory(ctx, "")
+		require.NoError(t, err)
+		for _, entry := range entries {
+			line := fmt.Sprintf("%s %d %s", entry.Remote(), entry.Size(), entry.ModTime(ctx).UTC())
+			if o, ok := entry.(fs.Object); ok {
+				sum, err := o.Hash(ctx, hash.MD5)
+				require.NoError(t, err)
+				line += " " + sum
+			}
+			out = append(out, line)
+		}
+		return out
+	}
+	first := describe()
+	time.Sleep(10 * time.Millisecond)
+	assert.Equal(t, first, describe())
+	assert.Contains(t, first, fmt.Sprintf("bad.txt 2 %s h2", unknownModTime.UTC()))
+}
+
+func TestAccountRoot(t *testing.T) {
+	ctx := context.Background()
+	for _, root := range []string{"", "/", "0"} {
+		t.Run(fmt.Sprintf("%q", root), func(t *testing.T) {
+			mock, m := newMockFs(t, "", nil)
+			m.addFile("old.txt", "old")
+			m.mkdir("dir")
+			fsi, err := NewFs(ctx, "TestFileLu", root, configmap.Simple{
+				"FileLu Rclone Key": "key",
+				"size_method":       "listing",
+				"list_order":        "name",
+				"overwrite":         "replace",
+				"mirror_selection":  "first",
+				"account_type":      accountTypePremium,
+				"disable_checksum":  "true",
+			})
+			require.NoError(t, err)
+			f := fsi.(*Fs)
+			f.endpoint = mock.endpoint
+			assert.Equal(t, "", f.Root())
+
+			// The account root always exists
+			require.NoError(t, f.Mkdir(ctx, ""))
+			require.NoError(t, f.Mkdir(ctx, "new"))
+			src := object.NewStaticObjectInfo("put.txt", time.Now(), 3, true, nil, nil)
+			_, err = f.Put(ctx, strings.NewReader("put"), src)
+			require.NoError(t, err)
+
+			entries, err := f.List(ctx, "")
+			require.NoError(t, err)
+			var names []string
+			for _, entry := range entries {
+				names = append(names, entry.Remote())
+			}
+			assert.Equal(t, []string{"dir", "new", "old.txt", "put.txt"}, names)
+			assert.Equal(t, map[string]string{"old.txt": "old", "put.txt": "put"}, m.contents())
+			assert.ErrorIs(t, f.Rmdir(ctx, ""), errAccountRoot)
+		})
+	}
+
+	// Folders are made under the root, not the account root
+	f, m := newMockFs(t, "a", nil)
+	m.mkdir("a")
+	require.NoError(t, f.Mkdir(ctx, "sub/deeper"))
+	_, ok := m.resolveFolder("/a/sub/deeper")
+	assert.True(t, ok)
+	_, ok = m.resolveFolder("/sub")
+	assert.False(t, ok)
+}
+
+func TestDefaultFolderID(t *testing.T) {
+	ctx := context.Background()
+	f, m := newMockFs(t, "", nil)
+	id := m.mkdir("a/inbox")
+	f.opt.DefaultFolderID = id
+
+	put := func(remote string) {
+		info := object.NewStaticObjectInfo(remote, time.Now(), 5, true, nil, nil)
+		_, err := f.Put(ctx, strings.NewReader("hello"), info)
+		require.NoError(t, err)
+	}
+	put("file.txt")
+	put("dir/other.txt")
+	put("again.txt")
+	assert.Equal(t, map[string]string{
+		"a/inbox/file.txt":  "hello",
+		"a/inbox/again.txt": "hello",
+		"dir/other.txt":     "hello",
+	}, m.contents())
+
+	// A missing folder fails the upload
+	f.opt.DefaultFolderID = 99999
+	f.defaultFolder = ""
+	info := object.NewStaticObjectInfo("lost.txt", time.Now(), 5, true, nil, nil)
+	_, err := f.Put(ctx, strings.NewReader("hello"), info)
+	assert.ErrorIs(t, err, fs.ErrorDirNotFound)
+	assert.NotContains(t, m.contents(), "lost.txt")
+}
+
+func TestAccountType(t *testing.T) {
+	ctx := context.Background()
+	put := func(f *Fs, remote string) error {
+		info := object.NewStaticObjectInfo(remote, time.Now(), 5, true, nil, nil)
+		_, err := f.Put(ctx, strings.NewReader("hello"), info)
+		return err
+	}
+	for _, test := range []struct {
+		utype       string // what account/info reports
+		accountType string // the account_type option
+		wantInfo    int    // account/info calls for two uploads
+		wantErr     bool
+	}{
+		{utype: "prem", wantInfo: 1},
+		{utype: "free", wantInfo: 1},
+		{utype: "free", accountType: accountTypeFree},
+		{utype: "prem", accountType: accountTypePremium},
+		{utype: "free", accountType: accountTypePremium, wantErr: true},
+	} {
+		name := test.utype + "/" + test.accountType
+		f, m := newMockFs(t, "", configmap.Simple{"account_type": test.accountType})
+		m.utype = test.utype
+		err := put(f, "one.txt")
+		if test.wantErr {
+			assert.ErrorContains(t, err, `upload rejected the utype field "prem"`, name)
+			assert.True(t, fserrors.IsNoRetryError(err), name)
+			continue
+		}
+		require.NoError(t, err, name)
+		require.NoError(t, put(f, "two.txt"), name)
+		assert.Equal(t, map[string]string{"one.txt": "hello", "two.txt": "hello"}, m.contents(), name)
+		assert.Equal(t, test.wantInfo, m.callCount("/account/info"), name)
+	}
+
+	// Reading the type is retried through maintenance rather than
+	// taking the account to be premium
+	f, m := newMockFs(t, "", configmap.Simple{"account_type": accountTypeAuto})
+	m.utype = "free"
+	m.down["/account/info"] = 1
+	require.NoError(t, put(f, "one.txt"))
+	assert.Equal(t, 2, m.callCount("/account/info"))
+
+	_, err := NewFs(ctx, "TestFileLu", "", configmap.Simple{
+		"FileLu Rclone Key": "key",
+		"size_method":       "listing",
+		"list_order":        "name",
+		"overwrite":         "replace",
+		"mirror_selection":  "first",
+		"account_type":      "gold",
+	})
+	assert.ErrorContains(t, err, "unknown account_type")
+}
+
+func TestListZeroSize(t *testing.T) {
+	ctx := context.Background()
+	f, m := newMockFs(t, "", nil)
+	for name, content := range map[string]string{"new.txt": "hello", "done.txt": "hi", "empty.txt": ""} {
+		code := m.addFile(name, content)
+		m.files[code].uploaded = time.Now().UTC()
+		m.files[code].processing = name == "new.txt"
+	}
+	m.addFile("old.txt", "hello")
+	m.zeroList = true
+
+	entries, err := f.List(ctx, "")
+	require.NoError(t, err)
+	sizes := map[string]int64{}
+	for _, entry := range entries {
+		sizes[entry.Remote()] = entry.Size()
+	}
+	// Only files uploaded recently are checked, and only those FileLu is
+	// still processing with the direct link
+	assert.Equal(t, map[string]int64{"new.txt": 5, "done.txt": 2, "empty.txt": 0, "old.txt": 0}, sizes)
+	assert.Equal(t, 3, m.callCount("/file/info"))
+	assert.Equal(t, 1, m.callCount("/file/direct_link"))
+
+	// The direct link is kept for opening the file
+	o, err := f.NewObject(ctx, "new.txt")
+	require.NoError(t, err)
+	in, err := o.Open(ctx)
+	require.NoError(t, err)
+	data, err := io.ReadAll(in)
+	require.NoError(t, err)
+	require.NoError(t, in.Close())
+	assert.Equal(t, "hello", string(data))
+	assert.Equal(t, 1, m.callCount("/file/direct_link"))
+}
+
+func TestFolderLookupReusesConnections(t *testing.T) {
+	ctx := context.Background()
+	const depth = 20
+	m := newMockServer(t)
+	parts := make([]string, depth)
+	for i := range parts {
+		parts[i] = fmt.Sprintf("d%d", i)
+	}
+	deep := strings.Join(parts, "/")
+	want := m.mkdir(deep)
+
+	// Pad each response so reading the JSON doesn't reach the end of the
+	// body, which would free the connection even if it wasn't closed
+	padded := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		m.ServeHTTP(w, r)
+		_, _ = w.Write(bytes.Repeat([]byte(" "), 64*1024))
+	})
+	var conns atomic.Int32
+	srv := httptest.NewUnstartedServer(padded)
+	srv.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			conns.Add(1)
+		}
+	}
+	srv.Start()
+	t.Cleanup(srv.Close)
+	f := newTestFs(t, "", m)
+	f.endpoint = srv.URL
+
+	id, err := f.resolveFolderPath(ctx, deep)
+	require.NoError(t, err)
+	assert.Equal(t, want, id)
+	id, err = f.getFolderID(ctx, deep)
+	require.NoError(t, err)
+	assert.Equal(t, want, id)
+
+	// Each body is closed before the next request so one connection does
+	assert.Equal(t, 2*depth, m.callCount("/folder/list"))
+	assert.Equal(t, int32(1), conns.Load())
+}
+
+func TestFolderIDLookalikeName(t *testing.T) {
+	ctx := context.Background()
+	for _, idInPath := range []bool{false, true} {
+		f, m := newMockFs(t, "", configmap.Simple{"folder_id_in_path": strconv.FormatBool(idInPath)})
+		other := m.mkdir("other")
+		require.Equal(t, int64(1), other)
+		named := m.mkdir("(1) x")
+
+		// Only with folder_id_in_path is the prefix a folder ID
+		id, err := f.resolveFolderPath(ctx, "(1) x")
+		require.NoError(t, err)
+		if idInPath {
+			assert.Equal(t, other, id)
+		} else {
+			assert.Equal(t, named, id)
+		}
+	}
+}
+
+func TestFolderNameSpaces(t *testing.T) {
+	ctx := context.Background()
+	f, m := newMockFs(t, "", nil)
+	spaced := m.mkdir("docs /sub")
+
+	// A path with the spaces trimmed still finds the folder
+	id, err := f.resolveFolderPath(ctx, "docs/sub")
+	require.NoError(t, err)
+	assert.Equal(t, spaced, id)
+	id, err = f.getFolderID(ctx, "docs/sub")
+	require.NoError(t, err)
+	assert.Equal(t, spaced, id)
+	dirID, err := f.dirCache.FindDir(ctx, "docs/sub", false)
+	require.NoError(t, err)
+	assert.Equal(t, strconv.FormatInt(spaced, 10), dirID)
+
+	// An exact match is preferred
+	plain := m.mkdir("docs")
+	id, err = f.getFolderID(ctx, "docs")
+	require.NoError(t, err)
+	assert.Equal(t, plain, id)
+	id, err = f.getFolderID(ctx, "docs ")
+	require.NoError(t, err)
+	assert.NotEqual(t, plain, id)
+
+	_, err = f.getFolderID(ctx, "doc")
+	assert.ErrorIs(t, err, fs.ErrorDirNotFound)
+}
+
+func TestCopyToMoveTo(t *testing.T) {
+	ctx := context.Background()
+	f, m := newMockFs(t, "", nil)
+	m.addFile("a.txt", "aaa")
+	m.addFile("dir/a.txt", "other")
+
+	// Server side copy and move to new names
+	require.NoError(t, operations.CopyFile(ctx, f, f, "b.txt", "a.txt"))
+	require.NoError(t, operations.MoveFile(ctx, f, f, "dir/c.txt", "b.txt"))
+	// Moving next to a file with the old name renames the right one
+	require.NoError(t, operations.MoveFile(ctx, f, f, "dir/d.txt", "a.txt"))
+
+	// Uploads to a new name
+	src, err := fs.NewFs(ctx, ":memory:"+t.Name())
+	require.NoError(t, err)
+	info := object.NewStaticObjectInfo("local.txt", time.Now(), 5, true, nil, nil)
+	_, err = src.Put(ctx, strings.NewReader("local"), info)
+	require.NoError(t, err)
+	require.NoError(t, operations.CopyFile(ctx, f, src, "dir/uploaded.txt", "local.txt"))
+
+	assert.Equal(t, map[string]string{
+		"dir/a.txt":        "other",
+		"dir/d.txt":        "aaa",
+		"dir/c.txt":        "aaa",
+		"dir/uploaded.txt": "local",
+	}, m.contents())
+	for _, remote := range []string{"dir/c.txt", "dir/d.txt", "dir/uploaded.txt"} {
+		o, err := f.NewObject(ctx, remote)
+		require.NoError(t, err, remote)
+		assert.Equal(t, remote, o.Remote())
+	}
+}
+
+func TestCopyOntoExisting(t *testing.T) {
+	ctx := context.Background()
+	for _, test := range []struct {
+		overwrite string
+		want      map[string]string
+	}{
+		{overwrite: "replace", want: map[string]string{"a.txt": "new", "dir/b.txt": "new"}},
+		{overwrite: "rename", want: map[string]string{"a.txt": "new", "dir/b.txt": "old", "dir/b (1).txt": "new"}},
+	} {
+		t.Run(test.overwrite, func(t *testing.T) {
+			f, m := newMockFs(t, "", configmap.Simple{"overwrite": test.overwrite})
+			m.addFile("a.txt", "new")
+			m.addFile("dir/b.txt", "old")
+			src, err := f.NewObject(ctx, "a.txt")
+			require.NoError(t, err)
+
+			dst, err := f.Copy(ctx, src, "dir/b.txt")
+			require.NoError(t, err)
+			assert.Equal(t, test.want, m.contents())
+			o, err := f.NewObject(ctx, dst.Remote())
+			require.NoError(t, err)
+			assert.Equal(t, dst.(*Object).fileCode, o.(*Object).fileCode)
+		})
+	}
+}
+
+func TestMoveOntoExisting(t *testing.T) {
+	ctx := context.Background()
+	for _, test := range []struct {
+		overwrite string
+		want      map[string]string
+	}{
+		{overwrite: "replace", want: map[string]string{"dir/b.txt": "new"}},
+		{overwrite: "rename", want: map[string]string{"dir/b.txt": "old", "dir/b (1).txt": "new"}},
+	} {
+		t.Run(test.overwrite, func(t *testing.T) {
+			f, m := newMockFs(t, "", configmap.Simple{"overwrite": test.overwrite})
+			m.addFile("a.txt", "new")
+			m.addFile("dir/b.txt", "old")
+			src, err := f.NewObject(ctx, "a.txt")
+			require.NoError(t, err)
+
+			// The file replaced isn't left beside the one moved
+			dst, err := f.Move(ctx, src, "dir/b.txt")
+			require.NoError(t, err)
+			assert.Equal(t, test.want, m.contents())
+			o, err := f.NewObject(ctx, dst.Remote())
+			require.NoError(t, err)
+			assert.Equal(t, dst.(*Object).fileCode, o.(*Object).fileCode)
+		})
+	}
+}
+
+func TestCopyFailedMove(t *testing.T) {
+	ctx := context.Background()
+	f, m := newMockFs(t, "", nil)
+	m.addFile("a.txt", "aaa")
+	m.mkdir("dir")
+	src, err := f.NewObject(ctx, "a.txt")
+	require.NoError(t, err)
+
+	// A clone which can't be moved into place isn't left in the root
+	m.failMove = 1
+	_, err = f.Copy(ctx, src, "dir/b.txt")
+	require.Error(t, err)
+	assert.Equal(t, map[string]string{"a.txt": "aaa"}, m.contents())
+}
+
+func TestUploadBesideSameName(t *testing.T) {
+	ctx := context.Background()
+	f, m := newMockFs(t, "", nil)
+	m.addFile("x.txt", "root")
+	src, err := fs.NewFs(ctx, ":memory:"+t.Name())
+	require.NoError(t, err)
+	info := object.NewStaticObjectInfo("x.txt", time.Now(), 5, true, nil, nil)
+	_, err = src.Put(ctx, strings.NewReader("local"), info)
+	require.NoError(t, err)
+
+	// Uploads go through the account root, which already has a file of
+	// the same name, so must be moved into place by their code
+	want := map[string]string{"x.txt": "root"}
+	for _, dir := range []string{"a", "b", "c", "d"} {
+		info := object.NewStaticObjectInfo(dir+"/x.txt", time.Now(), 3, true, nil, nil)
+		_, err := f.Put(ctx, strings.NewReader(dir+"!!"), info)
+		require.NoError(t, err)
+		want[dir+"/x.txt"] = dir + "!!"
+	}
+	require.NoError(t, operations.CopyFile(ctx, f, src, "copied/x.txt", "x.txt"))
+	want["copied/x.txt"] = "local"
+	require.NoError(t, operations.CopyFile(ctx, f, src, "a/x.txt", "x.txt"))
+	want["a/x.txt"] = "local"
+	assert.Equal(t, want, m.contents())
+}
@@ -0,0 +1,380 @@
+package filelu
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/rclone/rclone/backend/filelu/api"
+	"github.com/rclone/rclone/fs"
+)
+
+// folderTreeNode is a folder in the tree returned by foldertree
+//
+// Folders is nil if the folder wasn't listed because of the depth limit
+// and empty if it has no subfolders.
+type folderTreeNode struct {
+	FldID    string            `json:"fld_id"`
+	Code     string            `json:"code,omitempty"`
+	Name     string            `json:"name"`
+	Parent   string            `json:"parent,omitempty"`
+	Public   bool              `json:"public"`
+	Filedrop bool              `json:"filedrop"`
+	Folders  []*folderTreeNode `json:"folders"`
+}
+
+// listFolders returns the folders in the folder with ID fldID
+func (f *Fs) listFolders(ctx context.Context, fldID string) ([]api.FolderListFolder, error) {
+	var result api.FolderListResponse
+	err := f.pacer.Call(func() (bool, error) {
+		err := f.callAPI(ctx, "/folder/list", url.Values{"fld_id": {fldID}}, &result)
+		return shouldRetry(ctx, err)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list folder: %w", err)
+	}
+	if isFolderGone(result.Status, result.Msg) {
+		return nil, fs.ErrorDirNotFound
+	}
+	if result.Status != 200 {
+		return nil, fmt.Errorf("error: %s", result.Msg)
+	}
+	return result.Result.Folders, nil
+}
+
+// newFolderTreeNode makes a node for folder found in the folder with ID parent
+func newFolderTreeNode(folder api.FolderListFolder, parent string) *folderTreeNode {
+	return &folderTreeNode{
+		FldID:    strconv.FormatInt(folder.FldID, 10),
+		Code:     folder.Code,
+		Name:     folder.Name,
+		Parent:   parent,
+		Public:   folder.FldPublic != 0,
+		Filedrop: folder.Filedrop != 0,
+	}
+}
+
+// folderTree returns the tree of folders under the root, descending at
+// most depth levels, or without limit if depth is negative.
+//
+// The folders found are added to the dir cache.
+func (f *Fs) folderTree(ctx context.Context, depth int) (*folderTreeNode, error) {
+	// Finding the root flushes the folder cache so do it first
+	if err := f.dirCache.FindRoot(ctx, false); err != nil {
+		return nil, fmt.Errorf("foldertree: %w", err)
+	}
+	rootPath := f.Root()
+	root := &folderTreeNode{FldID: rootFolderID}
+	if rootPath != "" {
+		// Look the root up in its parent to find its flags
+		leaf, parentID, err := f.dirCache.FindPath(ctx, rootPath, false)
+		if err != nil {
+			return nil, fmt.Errorf("foldertree: %w", err)
+		}
+		folders, err := f.listFolders(ctx, parentID)
+		if err != nil {
+			return nil, fmt.Errorf("foldertree: %w", err)
+		}
+		i := matchFolderName(folders, leaf)
+		if i < 0 {
+			return nil, fmt.Errorf("foldertree: %w", fs.ErrorDirNotFound)
+		}
+		root = newFolderTreeNode(folders[i], parentID)
+		f.dirCache.Put(rootPath, root.FldID)
+	}
+	if err := f.addFolderTree(ctx, root, rootPath, depth); err != nil {
+		return nil, fmt.Errorf("foldertree: %w", err)
+	}
+	return root, nil
+}
+
+// addFolderTree lists the subfolders of node, which is at dirPath from
+// the account root, into node.Folders recursively
+func (f *Fs) addFolderTree(ctx context.Context, node *folderTreeNode, dirPath string, depth int) error {
+	if depth == 0 {
+		return nil
+	}
+	folders, err := f.listFolders(ctx, node.FldID)
+	if err != nil {
+		return err
+	}
+	f.cacheFolders(dirPath, folders)
+	sort.SliceStable(folders, func(i, j int) bool { return folders[i].Name < folders[j].Name })
+	node.Folders = make([]*folderTreeNode, 0, len(folders))
+	for _, folder := range folders {
+		child := newFolderTreeNode(folder, node.FldID)
+		childPath := path.Join(dirPath, f.folderLeaf(folder))
+		if err := f.addFolderTree(ctx, child, childPath, depth-1); err != nil {
+			return err
+		}
+		node.Folders = append(node.Folders, child)
+	}
+	return nil
+}
+
+// folderCodePath returns the path relative to the root of the folder
+// under the root with the folder code code
+func (f *Fs) folderCodePath(ctx context.Context, code string) (string, error) {
+	rootPath := f.Root()
+	rootID, err := f.dirCache.FindDir(ctx, rootPath, false)
+	if err != nil {
+		return "", err
+	}
+	dir, err := f.findFolder(ctx, rootID, rootPath, func(folder api.FolderListFolder) bool {
+		return folder.Code == code
+	})
+	if err != nil {
+		return "", fmt.Errorf("no folder with code %q: %w", code, err)
+	}
+	return strings.Trim(strings.TrimPrefix(dir, rootPath), "/"), nil
+}
+
+// findFolder returns the path from the account root of the first folder
+// under the folder with ID parentID at parentDir which match is true for,
+// or fs.ErrorDirNotFound if there is none.
+//
+// FileLu can only look a folder up by path so the folders are listed a
+// level at a time until it is found. The folders listed are added to the
+// dir cache.
+func (f *Fs) findFolder(ctx context.Context, parentID, parentDir string, match func(folder api.FolderListFolder) bool) (string, error) {
+	type queued struct {
+		id  string
+		dir string
+	}
+	queue := []queued{{id: parentID, dir: parentDir}}
+	for len(queue) > 0 {
+		parent := queue[0]
+		queue = queue[1:]
+		folders, err := f.listFolders(ctx, parent.id)
+		if err != nil {
+			return "", err
+		}
+		f.cacheFolders(parent.dir, folders)
+		for _, folder := range folders {
+			id := strconv.FormatInt(folder.FldID, 10)
+			dir := path.Join(parent.dir, f.folderLeaf(folder))
+			if match(folder) {
+				return dir, nil
+			}
+			queue = append(queue, queued{id: id, dir: dir})
+		}
+	}
+	return "", fs.ErrorDirNotFound
+}
+
+// defaultFolderPath returns the path from the account root of the folder
+// set by the default_folder_id option, finding it the first time
+func (f *Fs) defaultFolderPath(ctx context.Context) (string, error) {
+	f.defaultFolderMu.Lock()
+	defer f.defaultFolderMu.Unlock()
+	if f.defaultFolder != "" {
+		return f.defaultFolder, nil
+	}
+	if err := f.dirCache.FindRoot(ctx, false); err != nil {
+		return "", err
+	}
+	dir, err := f.findFolder(ctx, rootFolderID, "", func(folder api.FolderListFolder) bool {
+		return folder.FldID == f.opt.DefaultFolderID
+	})
+	if err != nil {
+		return "", fmt.Errorf("no folder with ID %d: %w", f.opt.DefaultFolderID, err)
+	}
+	f.defaultFolder = dir
+	return dir, nil
+}
+
+// setFolderID makes the folder with ID arg the root.
+//
+// The dir cache is kept as it holds paths from the account root, but
+// the files remembered by importmanifest are forgotten as their paths
+// are relative to the old root.
+func (f *Fs) setFolderID(ctx context.Context, arg string) (map[string]string, error) {
+	if f.opt.RootIsDrop {
+		return nil, fmt.Errorf("setfolderid: %w", errFiledrop)
+	}
+	id, dir, err := f.folderIDPath(ctx, arg)
+	if err != nil {
+		return nil, fmt.Errorf("setfolderid: %w", err)
+	}
+	f.rootMu.Lock()
+	f.root = dir
+	f.isFile, f.targetFile = false, ""
+	f.rootMu.Unlock()
+	f.objectCacheMu.Lock()
+	f.objectCache = nil
+	f.objectCacheMu.Unlock()
+	fs.Debugf(f, "setfolderid: root is now folder ID %d", id)
+	return map[string]string{"fld_id": strconv.FormatInt(id, 10), "root": dir}, nil
+}
+
+// folderIDPath returns the folder ID arg and the path from the account
+// root of the folder it is the ID of, which is "" for the account root
+func (f *Fs) folderIDPath(ctx context.Context, arg string) (int64, string, error) {
+	id, err := strconv.ParseInt(strings.TrimSpace(arg), 10, 64)
+	if err != nil || id < 0 {
+		return 0, "", fmt.Errorf("bad folder ID %q", arg)
+	}
+	if id == 0 {
+		return 0, "", nil
+	}
+	if err := f.dirCache.FindRoot(ctx, false); err != nil {
+		return 0, "", err
+	}
+	dir, err := f.findFolder(ctx, rootFolderID, "", func(folder api.FolderListFolder) bool {
+		return folder.FldID == id
+	})
+	if err != nil {
+		return 0, "", fmt.Errorf("no folder with ID %d: %w", id, err)
+	}
+	return id, dir, nil
+}
+
+// moveFolderResult is returned by the movefolder command
+type moveFolderResult struct {
+	From string `json:"from"` // old path of the folder from the account root
+	To   string `json:"to"`   // new path of the folder from the account root
+}
+
+// moveFolderArg returns the path from the account root of the folder arg
+// names in the movefolder command. This is a folder ID with the id
+// option, a folder code under the root with the code option, or else a
+// path relative to dir.
+func (f *Fs) moveFolderArg(ctx context.Context, opt map[string]string, dir, arg string) (string, error) {
+	if value, ok := opt["id"]; ok {
+		byID, err := strconv.ParseBool(value)
+		if err != nil {
+			return "", fmt.Errorf("invalid id value %q: %w", value, err)
+		}
+		if byID {
+			_, folderPath, err := f.folderIDPath(ctx, arg)
+			return folderPath, err
+		}
+	}
+	rel, err := f.commandFolder(ctx, opt, arg)
+	if err != nil {
+		return "", err
+	}
+	if byCode, _ := strconv.ParseBool(opt["code"]); byCode {
+		dir = f.Root()
+	}
+	return strings.Trim(path.Join(dir, rel), "/"), nil
+}
+
+// moveFolder moves the folder at src into the folder at dstParent, both
+// from the account root, keeping its name.
+//
+// It refuses to move a folder into itself or one of its own folders, or
+// next to a folder with the same name.
+func (f *Fs) moveFolder(ctx context.Context, src, dstParent string) (*moveFolderResult, error) {
+	src, dstParent = strings.Trim(src, "/"), strings.Trim(dstParent, "/")
+	if src == "" {
+		return nil, errors.New("can't move the root folder")
+	}
+	srcPath, dstPath := f.apiPath(src), f.apiPath(dstParent)
+	if dstPath == srcPath || strings.HasPrefix(dstPath, srcPath+"/") {
+		return nil, fmt.Errorf("can't move folder %q into itself at %q", srcPath, dstPath)
+	}
+	if _, err := f.dirCache.FindDir(ctx, src, false); err != nil {
+		return nil, fmt.Errorf("source: %w", err)
+	}
+	dstID, err := f.dirCache.FindDir(ctx, dstParent, false)
+	if err != nil {
+		return nil, fmt.Errorf("destination: %w", err)
+	}
+	_, found, err := f.FindLeaf(ctx, dstID, path.Base(src))
+	if err != nil {
+		return nil, err
+	}
+	if found {
+		return nil, fs.ErrorDirExists
+	}
+
+	if err := f.moveFolderToDestination(ctx, src, dstParent); err != nil {
+		return nil, err
+	}
+	f.dirCache.FlushDir(src)
+	return &moveFolderResult{
+		From: srcPath,
+		To:   f.apiPath(path.Join(dstParent, path.Base(src))),
+	}, nil
+}
+
+// commandFolder returns the path relative to the root of the folder arg
+// names in a command. This is arg itself unless the code option is set,
+// when it is a folder code.
+func (f *Fs) commandFolder(ctx context.Context, opt map[string]string, arg string) (string, error) {
+	byCode := false
+	if value, ok := opt["code"]; ok {
+		var err error
+		byCode, err = strconv.ParseBool(value)
+		if err != nil {
+			return "", fmt.Errorf("invalid code value %q: %w", value, err)
+		}
+	}
+	if !byCode || arg == "" {
+		return strings.Trim(arg, "/"), nil
+	}
+	return f.folderCodePath(ctx, arg)
+}
+
+// mkdirTree makes the folders at dirs, relative to the root, and all
+// their parents and returns the folder IDs by path relative to the root.
+//
+// Each folder which already existed is listed at most once and the
+// folders made here aren't listed at all, so a tree of new folders takes
+// one request per folder.
+func (f *Fs) mkdirTree(ctx context.Context, dirs []string) (map[string]string, error) {
+	rootPath := f.Root()
+	rootID, err := f.dirCache.FindDir(ctx, rootPath, true)
+	if err != nil {
+		return nil, fmt.Errorf("mkdirtree: %w", err)
+	}
+	var (
+		ids      = map[string]string{}
+		children = map[string][]api.FolderListFolder{} // subfolders of the folders listed or made
+	)
+	sort.Strings(dirs)
+	for _, dir := range dirs {
+		parentPath, parentID := rootPath, rootID
+		rel := ""
+		for _, leaf := range strings.Split(strings.Trim(dir, "/"), "/") {
+			if leaf == "" {
+				continue
+			}
+			rel = path.Join(rel, leaf)
+			dirPath := path.Join(parentPath, leaf)
+			id, found := f.dirCache.Get(dirPath)
+			if !found {
+				folders, ok := children[parentPath]
+				if !ok {
+					folders, err = f.listFolders(ctx, parentID)
+					if err != nil {
+						return ids, fmt.Errorf("mkdirtree: %w", err)
+					}
+					f.cacheFolders(parentPath, folders)
+					children[parentPath] = folders
+				}
+				if id, found = f.matchLeaf(folders, leaf); found {
+					f.dirCache.Put(dirPath, id)
+				}
+			}
+			if !found {
+				id, err = f.CreateDir(ctx, parentID, leaf)
+				if err != nil {
+					return ids, fmt.Errorf("mkdirtree: failed to make %q: %w", rel, err)
+				}
+				f.dirCache.Put(dirPath, id)
+				// A new folder is empty so needn't be listed
+				children[dirPath] = nil
+			}
+			ids[rel] = id
+			parentPath, parentID = dirPath, id
+		}
+	}
+	return ids, nil
+}
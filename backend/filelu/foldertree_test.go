@@ -0,0 +1,299 @@
+package filelu
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/config/configmap"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFolderTree(t *testing.T) {
+	ctx := context.Background()
+	folders := map[string][]map[string]interface{}{
+		"0": {{"name": "b", "fld_id": 2, "filedrop": 1}, {"name": "a", "fld_id": 1, "fld_public": 1, "code": "codea"}},
+		"1": {{"name": "c", "fld_id": 3}},
+		"2": {},
+		"3": {},
+	}
+	var (
+		mu    sync.Mutex
+		calls int
+	)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		calls++
+		mu.Unlock()
+		assert.Equal(t, "/folder/list", r.URL.Path)
+		writeJSON(t, w, map[string]interface{}{"status": 200, "result": map[string]interface{}{"folders": folders[r.URL.Query().Get("fld_id")]}})
+	})
+
+	f := newTestFs(t, "", handler)
+	out, err := f.Command(ctx, "foldertree", nil, nil)
+	require.NoError(t, err)
+	assert.Equal(t, &folderTreeNode{
+		FldID: "0",
+		Folders: []*folderTreeNode{
+			{FldID: "1", Code: "codea", Name: "a", Parent: "0", Public: true, Folders: []*folderTreeNode{
+				{FldID: "3", Name: "c", Parent: "1", Folders: []*folderTreeNode{}},
+			}},
+			{FldID: "2", Name: "b", Parent: "0", Filedrop: true, Folders: []*folderTreeNode{}},
+		},
+	}, out)
+	assert.Equal(t, 4, calls)
+
+	// The folders found are cached
+	id, err := f.dirCache.FindDir(ctx, "a/c", false)
+	require.NoError(t, err)
+	assert.Equal(t, "3", id)
+	assert.Equal(t, 4, calls)
+
+	// Only the top level folders with depth=1
+	out, err = f.Command(ctx, "foldertree", nil, map[string]string{"depth": "1"})
+	require.NoError(t, err)
+	assert.Equal(t, &folderTreeNode{
+		FldID: "0",
+		Folders: []*folderTreeNode{
+			{FldID: "1", Code: "codea", Name: "a", Parent: "0", Public: true},
+			{FldID: "2", Name: "b", Parent: "0", Filedrop: true},
+		},
+	}, out)
+
+	// A remote pointing at a folder starts the tree there
+	f = newTestFs(t, "a", handler)
+	out, err = f.Command(ctx, "foldertree", nil, nil)
+	require.NoError(t, err)
+	assert.Equal(t, &folderTreeNode{FldID: "1", Code: "codea", Name: "a", Parent: "0", Public: true, Folders: []*folderTreeNode{
+		{FldID: "3", Name: "c", Parent: "1", Folders: []*folderTreeNode{}},
+	}}, out)
+
+	_, err = f.Command(ctx, "foldertree", nil, map[string]string{"depth": "x"})
+	assert.Error(t, err)
+}
+
+func TestFolderTreeSameNames(t *testing.T) {
+	ctx := context.Background()
+	folders := map[string][]map[string]interface{}{
+		"0": {{"name": "a", "fld_id": 1}, {"name": "a", "fld_id": 2}},
+		"1": {{"name": "c", "fld_id": 3}},
+		"2": {},
+		"3": {},
+	}
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/folder/list", r.URL.Path)
+		writeJSON(t, w, map[string]interface{}{"status": 200, "result": map[string]interface{}{"folders": folders[r.URL.Query().Get("fld_id")]}})
+	})
+
+	// The first folder of a name is cached, as FindLeaf finds it
+	f := newTestFs(t, "", handler)
+	_, err := f.Command(ctx, "foldertree", nil, nil)
+	require.NoError(t, err)
+	id, ok := f.dirCache.Get("a")
+	assert.True(t, ok)
+	assert.Equal(t, "1", id)
+	f = newTestFs(t, "", handler)
+	out, err := f.Command(ctx, "mkdirtree", []string{"a/c"}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"a": "1", "a/c": "3"}, out)
+
+	// With folder_id_in_path each is cached under the name listings give it
+	opt := configmap.Simple{"folder_id_in_path": "true"}
+	f = newTestFsOpt(t, "", opt, handler)
+	_, err = f.Command(ctx, "foldertree", nil, nil)
+	require.NoError(t, err)
+	for dir, want := range map[string]string{"(1) a": "1", "(2) a": "2", "(1) a/(3) c": "3"} {
+		id, ok := f.dirCache.Get(dir)
+		assert.True(t, ok, dir)
+		assert.Equal(t, want, id, dir)
+	}
+	_, ok = f.dirCache.Get("a")
+	assert.False(t, ok)
+	f = newTestFsOpt(t, "", opt, handler)
+	out, err = f.Command(ctx, "mkdirtree", []string{"(2) a", "a/c"}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"(2) a": "2", "a": "1", "a/c": "3"}, out)
+	id, err = f.dirCache.FindDir(ctx, "(1) a/(3) c", false)
+	require.NoError(t, err)
+	assert.Equal(t, "3", id)
+}
+
+func TestFolderCode(t *testing.T) {
+	ctx := context.Background()
+	f, m := newMockFs(t, "base", nil)
+	m.addFile("base/x/y/file.txt", "hello")
+	code := mockFolderCode(m.mkdir("base/x/y"))
+	outside := mockFolderCode(m.mkdir("other"))
+
+	dir, err := f.folderCodePath(ctx, code)
+	require.NoError(t, err)
+	assert.Equal(t, "x/y", dir)
+
+	// Folder commands take a code with the code option
+	_, err = f.Command(ctx, "copyfolder", []string{code, "z"}, map[string]string{"code": "true"})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"base/x/y/file.txt": "hello",
+		"base/z/file.txt":   "hello",
+	}, m.contents())
+
+	// The code is looked for under the root only
+	_, err = f.Command(ctx, "copyfolder", []string{outside, "z"}, map[string]string{"code": "true"})
+	assert.ErrorIs(t, err, fs.ErrorDirNotFound)
+
+	_, err = f.Command(ctx, "copyfolder", []string{code, "z"}, map[string]string{"code": "x"})
+	assert.Error(t, err)
+}
+
+func TestMkdirTree(t *testing.T) {
+	ctx := context.Background()
+	f, m := newMockFs(t, "base", nil)
+	m.mkdir("base/a/old")
+
+	out, err := f.Command(ctx, "mkdirtree", []string{"a/b/c", "/a/b/d/", "a/e", "x", "a/old"}, nil)
+	require.NoError(t, err)
+	ids := out.(map[string]string)
+	assert.Len(t, ids, 7)
+	for _, dir := range []string{"a", "a/b", "a/b/c", "a/b/d", "a/e", "a/old", "x"} {
+		id, ok := m.resolveFolder("base/" + dir)
+		require.True(t, ok, dir)
+		assert.Equal(t, strconv.FormatInt(id, 10), ids[dir], dir)
+	}
+
+	// Only the account root, base and a, which existed, were listed
+	assert.Equal(t, 3, m.callCount("/folder/list"))
+	assert.Equal(t, 5, m.callCount("/folder/create"))
+
+	// Doing it again finds everything in the cache
+	again, err := f.Command(ctx, "mkdirtree", []string{"a/b/c", "x"}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, ids["a/b/c"], again.(map[string]string)["a/b/c"])
+	assert.Equal(t, 3, m.callCount("/folder/list"))
+	assert.Equal(t, 5, m.callCount("/folder/create"))
+
+	_, err = f.Command(ctx, "mkdirtree", nil, nil)
+	assert.Error(t, err)
+}
+
+func TestSetFolderID(t *testing.T) {
+	ctx := context.Background()
+	f, m := newMockFs(t, "a", nil)
+	m.addFile("a/one.txt", "1")
+	m.addFile("b/c/two.txt", "2")
+	id := m.mkdir("b/c")
+
+	entries, err := f.List(ctx, "")
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, "one.txt", entries[0].Remote())
+
+	out, err := f.Command(ctx, "setfolderid", []string{strconv.FormatInt(id, 10)}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"fld_id": strconv.FormatInt(id, 10), "root": "b/c"}, out)
+	assert.Equal(t, "b/c", f.Root())
+	entries, err = f.List(ctx, "")
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, "two.txt", entries[0].Remote())
+	_, err = f.NewObject(ctx, "two.txt")
+	require.NoError(t, err)
+
+	// A folder which isn't there leaves the root alone
+	_, err = f.Command(ctx, "setfolderid", []string{"999"}, nil)
+	assert.ErrorIs(t, err, fs.ErrorDirNotFound)
+	_, err = f.Command(ctx, "setfolderid", []string{"b"}, nil)
+	assert.Error(t, err)
+	assert.Equal(t, "b/c", f.Root())
+
+	// 0 is the account root
+	_, err = f.Command(ctx, "setfolderid", []string{"0"}, nil)
+	require.NoError(t, err)
+	entries, err = f.List(ctx, "")
+	require.NoError(t, err)
+	assert.Len(t, entries, 2)
+}
+
+func TestEditFolderCommand(t *testing.T) {
+	ctx := context.Background()
+	f, m := newMockFs(t, "", nil)
+	m.addFile("a/b/file.txt", "hello")
+
+	out, err := f.Command(ctx, "editfolder", []string{"a/b"}, map[string]string{"name": "c", "description": "my folder"})
+	require.NoError(t, err)
+	assert.Equal(t, &editFolderResult{
+		Path:    "/a/c",
+		Applied: map[string]string{"name": "c"},
+		Ignored: []string{"description"},
+	}, out)
+	assert.Equal(t, map[string]string{"a/c/file.txt": "hello"}, m.contents())
+
+	// Only unsupported settings still checks the folder
+	out, err = f.Command(ctx, "editfolder", []string{"a/c"}, map[string]string{"colour": "red"})
+	require.NoError(t, err)
+	assert.Equal(t, &editFolderResult{Path: "/a/c", Applied: map[string]string{}, Ignored: []string{"colour"}}, out)
+
+	_, err = f.Command(ctx, "editfolder", []string{"a/b"}, map[string]string{"name": "d"})
+	assert.ErrorIs(t, err, fs.ErrorDirNotFound)
+	_, err = f.Command(ctx, "editfolder", []string{"a/c"}, nil)
+	assert.ErrorContains(t, err, "nothing to change")
+	_, err = f.Command(ctx, "editfolder", []string{"a", "c"}, map[string]string{"name": "d"})
+	assert.Error(t, err)
+}
+
+func TestMoveFolderCommand(t *testing.T) {
+	ctx := context.Background()
+	f, m := newMockFs(t, "", nil)
+	m.addFile("a/b/c/file.txt", "1")
+	m.mkdir("x")
+	m.mkdir("other/b")
+
+	out, err := f.Command(ctx, "movefolder", []string{"a/b", "x"}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, &moveFolderResult{From: "/a/b", To: "/x/b"}, out)
+	assert.Equal(t, map[string]string{"x/b/c/file.txt": "1"}, m.contents())
+	_, ok := f.dirCache.Get("a/b")
+	assert.False(t, ok, "moved folder should be flushed from the dir cache")
+	entries, err := f.List(ctx, "x/b/c")
+	require.NoError(t, err)
+	assert.Len(t, entries, 1)
+
+	// Moving a folder into itself is refused before asking FileLu
+	moves := m.callCount("/folder/move")
+	_, err = f.Command(ctx, "movefolder", []string{"x", "x/b/c"}, nil)
+	assert.ErrorContains(t, err, "into itself")
+	_, err = f.Command(ctx, "movefolder", []string{"x/b", "x/b"}, nil)
+	assert.ErrorContains(t, err, "into itself")
+	_, err = f.Command(ctx, "movefolder", []string{"", "x"}, nil)
+	assert.Error(t, err)
+	assert.Equal(t, moves, m.callCount("/folder/move"))
+
+	// as is one onto a folder of the same name
+	_, err = f.Command(ctx, "movefolder", []string{"x/b", "other"}, nil)
+	assert.ErrorIs(t, err, fs.ErrorDirExists)
+	_, err = f.Command(ctx, "movefolder", []string{"missing", "other"}, nil)
+	assert.ErrorIs(t, err, fs.ErrorDirNotFound)
+	assert.Equal(t, moves, m.callCount("/folder/move"))
+
+	// By folder ID, with the cycle checked on the paths they resolve to
+	c, ok := m.resolveFolder("/x/b/c")
+	require.True(t, ok)
+	x, ok := m.resolveFolder("/x")
+	require.True(t, ok)
+	_, err = f.Command(ctx, "movefolder", []string{strconv.FormatInt(x, 10), strconv.FormatInt(c, 10)}, map[string]string{"id": "true"})
+	assert.ErrorContains(t, err, "into itself")
+	out, err = f.Command(ctx, "movefolder", []string{strconv.FormatInt(c, 10), "0"}, map[string]string{"id": "true"})
+	require.NoError(t, err)
+	assert.Equal(t, &moveFolderResult{From: "/x/b/c", To: "/c"}, out)
+
+	// The folder the remote points to into a folder from the account root
+	_, err = f.Command(ctx, "setfolderid", []string{strconv.FormatInt(c, 10)}, nil)
+	require.NoError(t, err)
+	out, err = f.Command(ctx, "movefolder", []string{"/x"}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, &moveFolderResult{From: "/c", To: "/x/c"}, out)
+	assert.Equal(t, map[string]string{"x/c/file.txt": "1"}, m.contents())
+}
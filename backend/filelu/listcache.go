@@ -0,0 +1,107 @@
+package filelu
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/rclone/rclone/backend/filelu/api"
+	"github.com/rclone/rclone/fs"
+)
+
+// listCacheEntry is a folder listing remembered by the listing cache
+type listCacheEntry struct {
+	result  api.FolderListResult // files and folders in the folder
+	expires time.Time            // when to list the folder again
+}
+
+// folderListing returns the files and folders in the folder at
+// fullPath, from the account root, reusing a listing made in the last
+// list_cache_time.
+func (f *Fs) folderListing(ctx context.Context, fullPath string) (api.FolderListResult, error) {
+	key := f.apiPath(fullPath)
+	if f.opt.ListCacheTime > 0 {
+		f.listCacheMu.Lock()
+		entry, ok := f.listCache[key]
+		f.listCacheMu.Unlock()
+		if ok && time.Now().Before(entry.expires) {
+			fs.Debugf(f, "Using cached listing of %q", key)
+			return entry.result, nil
+		}
+	}
+
+	var result api.FolderListResponse
+	err := f.pacer.Call(func() (bool, error) {
+		err := f.callAPI(ctx, "/folder/list", url.Values{"folder_path": {fullPath}}, &result)
+		return shouldRetry(ctx, err)
+	})
+	if err != nil {
+		return api.FolderListResult{}, fmt.Errorf("failed to list directory: %w", err)
+	}
+	if isFolderGone(result.Status, result.Msg) {
+		return api.FolderListResult{}, fs.ErrorDirNotFound
+	}
+	if result.Status != 200 {
+		return api.FolderListResult{}, fmt.Errorf("API error: %s", result.Msg)
+	}
+
+	if f.opt.ListCacheTime > 0 {
+		f.listCacheMu.Lock()
+		if f.listCache == nil {
+			f.listCache = make(map[string]listCacheEntry)
+		}
+		f.listCache[key] = listCacheEntry{
+			result:  result.Result,
+			expires: time.Now().Add(time.Duration(f.opt.ListCacheTime)),
+		}
+		f.listCacheMu.Unlock()
+	}
+	return result.Result, nil
+}
+
+// forgetListing removes the listing of the folder at dir, from the
+// account root, from the listing cache along with those of its parents,
+// which writing to dir may have created folders in
+func (f *Fs) forgetListing(dir string) {
+	key := f.apiPath(dir)
+	f.listCacheMu.Lock()
+	defer f.listCacheMu.Unlock()
+	for {
+		delete(f.listCache, key)
+		if key == "/" {
+			return
+		}
+		key = path.Dir(key)
+	}
+}
+
+// forgetParentListing removes the listing of the folder holding remote,
+// relative to the root, and of its parents from the listing cache
+func (f *Fs) forgetParentListing(remote string) {
+	f.forgetListing(path.Dir(path.Join("/", f.Root(), remote)))
+}
+
+// forgetListingTree removes the listings of the folder at dir, from the
+// account root, its parents and every folder under it from the listing
+// cache, for when dir is removed or moved
+func (f *Fs) forgetListingTree(dir string) {
+	f.forgetListing(dir)
+	prefix := strings.TrimSuffix(f.apiPath(dir), "/") + "/"
+	f.listCacheMu.Lock()
+	defer f.listCacheMu.Unlock()
+	for key := range f.listCache {
+		if strings.HasPrefix(key, prefix) {
+			delete(f.listCache, key)
+		}
+	}
+}
+
+// forgetListings empties the listing cache
+func (f *Fs) forgetListings() {
+	f.listCacheMu.Lock()
+	f.listCache = nil
+	f.listCacheMu.Unlock()
+}
@@ -0,0 +1,88 @@
+package filelu
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/config/configmap"
+	"github.com/rclone/rclone/fs/object"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestListCache(t *testing.T) {
+	ctx := context.Background()
+	f, m := newMockFs(t, "", configmap.Simple{"list_cache_time": "1m"})
+	m.addFile("dir/one.txt", "1")
+	m.addFile("other/two.txt", "2")
+
+	names := func(dir string) []string {
+		entries, err := f.List(ctx, dir)
+		require.NoError(t, err)
+		var names []string
+		for _, entry := range entries {
+			names = append(names, entry.Remote())
+		}
+		return names
+	}
+
+	// A second listing within the time is served from the cache
+	assert.Equal(t, []string{"dir/one.txt"}, names("dir"))
+	calls := m.callCount("/folder/list")
+	assert.Equal(t, []string{"dir/one.txt"}, names("dir"))
+	assert.Equal(t, calls, m.callCount("/folder/list"))
+	assert.Equal(t, []string{"other/two.txt"}, names("other"))
+	calls = m.callCount("/folder/list")
+
+	// Uploading into the folder is seen straight away
+	src := object.NewStaticObjectInfo("dir/three.txt", time.Now(), 1, true, nil, nil)
+	_, err := f.Put(ctx, strings.NewReader("3"), src)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"dir/one.txt", "dir/three.txt"}, names("dir"))
+
+	// but other folders are still cached
+	calls = m.callCount("/folder/list")
+	assert.Equal(t, []string{"other/two.txt"}, names("other"))
+	assert.Equal(t, calls, m.callCount("/folder/list"))
+
+	// as are removals and new folders
+	o, err := f.NewObject(ctx, "dir/one.txt")
+	require.NoError(t, err)
+	require.NoError(t, o.Remove(ctx))
+	assert.Equal(t, []string{"dir/three.txt"}, names("dir"))
+	require.NoError(t, f.Mkdir(ctx, "dir/sub/deeper"))
+	assert.Equal(t, []string{"dir/sub", "dir/three.txt"}, names("dir"))
+	require.NoError(t, f.Rmdir(ctx, "dir/sub/deeper"))
+	assert.Empty(t, names("dir/sub"))
+
+	// Once the time is up the folder is listed again
+	m.addFile("other/four.txt", "4")
+	assert.Equal(t, []string{"other/two.txt"}, names("other"))
+	f.listCacheMu.Lock()
+	for key, entry := range f.listCache {
+		entry.expires = time.Now()
+		f.listCache[key] = entry
+	}
+	f.listCacheMu.Unlock()
+	assert.Equal(t, []string{"other/four.txt", "other/two.txt"}, names("other"))
+
+	// A folder which has gone isn't cached
+	require.NoError(t, f.Purge(ctx, "other"))
+	_, err = f.List(ctx, "other")
+	assert.ErrorIs(t, err, fs.ErrorDirNotFound)
+}
+
+func TestListCacheDisabled(t *testing.T) {
+	ctx := context.Background()
+	f, m := newMockFs(t, "", nil)
+	m.addFile("dir/one.txt", "1")
+	_, err := f.List(ctx, "dir")
+	require.NoError(t, err)
+	_, err = f.List(ctx, "dir")
+	require.NoError(t, err)
+	assert.Equal(t, 2, m.callCount("/folder/list"))
+	assert.Empty(t, f.listCache)
+}
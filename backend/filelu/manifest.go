@@ -0,0 +1,155 @@
+package filelu
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+)
+
+// manifestVersion is the version of the manifest format written by
+// exportmanifest
+const manifestVersion = 1
+
+// manifest describes a tree of folders and files on FileLu.
+//
+// All paths are relative to the root of the Fs it was exported from.
+type manifest struct {
+	Version int              `json:"version"`
+	Folders []manifestFolder `json:"folders"`
+	Files   []manifestFile   `json:"files"`
+}
+
+// manifestFolder is a folder in a manifest
+type manifestFolder struct {
+	Path  string `json:"path"`
+	FldID string `json:"fld_id"`
+}
+
+// manifestFile is a file in a manifest
+type manifestFile struct {
+	Path     string    `json:"path"`
+	FileCode string    `json:"file_code"`
+	Hash     string    `json:"hash,omitempty"`
+	Size     int64     `json:"size"`
+	Uploaded time.Time `json:"uploaded"`
+}
+
+// manifestResult is returned by the manifest commands
+type manifestResult struct {
+	Folders int `json:"folders"` // number of folders in the manifest
+	Files   int `json:"files"`   // number of files in the manifest
+}
+
+// exportManifest walks the remote and writes a manifest of it to the
+// local file at localPath
+func (f *Fs) exportManifest(ctx context.Context, localPath string) (*manifestResult, error) {
+	m := manifest{
+		Version: manifestVersion,
+		Folders: []manifestFolder{},
+		Files:   []manifestFile{},
+	}
+	err := f.ListR(ctx, "", func(entries fs.DirEntries) error {
+		for _, entry := range entries {
+			switch x := entry.(type) {
+			case fs.Directory:
+				m.Folders = append(m.Folders, manifestFolder{Path: x.Remote(), FldID: x.ID()})
+			case *Object:
+				m.Files = append(m.Files, manifestFile{
+					Path:     x.remote,
+					FileCode: x.fileCode,
+					Hash:     x.hash,
+					Size:     x.size,
+					Uploaded: x.modTime,
+				})
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("exportmanifest: %w", err)
+	}
+	sort.Slice(m.Folders, func(i, j int) bool { return m.Folders[i].Path < m.Folders[j].Path })
+	sort.Slice(m.Files, func(i, j int) bool { return m.Files[i].Path < m.Files[j].Path })
+
+	data, err := json.MarshalIndent(&m, "", "\t")
+	if err != nil {
+		return nil, fmt.Errorf("exportmanifest: failed to encode manifest: %w", err)
+	}
+	if err := os.WriteFile(localPath, data, 0666); err != nil {
+		return nil, fmt.Errorf("exportmanifest: failed to write manifest: %w", err)
+	}
+	return &manifestResult{Folders: len(m.Folders), Files: len(m.Files)}, nil
+}
+
+// importManifest reads the manifest in the local file at localPath and
+// seeds the folder and file caches from it so the paths in it don't
+// need to be looked up again
+func (f *Fs) importManifest(ctx context.Context, localPath string) (*manifestResult, error) {
+	data, err := os.ReadFile(localPath)
+	if err != nil {
+		return nil, fmt.Errorf("importmanifest: failed to read manifest: %w", err)
+	}
+	var m manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("importmanifest: failed to decode manifest: %w", err)
+	}
+	if m.Version != manifestVersion {
+		return nil, fmt.Errorf("importmanifest: unsupported manifest version %d", m.Version)
+	}
+
+	// Finding the root flushes the folder cache so do it first
+	if err := f.dirCache.FindRoot(ctx, false); err != nil {
+		return nil, fmt.Errorf("importmanifest: %w", err)
+	}
+	for _, folder := range m.Folders {
+		if folder.FldID == "" {
+			continue
+		}
+		f.dirCache.Put(path.Join(f.Root(), folder.Path), folder.FldID)
+	}
+	f.objectCacheMu.Lock()
+	if f.objectCache == nil {
+		f.objectCache = make(map[string]manifestFile, len(m.Files))
+	}
+	for _, file := range m.Files {
+		f.objectCache[file.Path] = file
+	}
+	f.objectCacheMu.Unlock()
+	fs.Debugf(f, "importmanifest: cached %d folders and %d files", len(m.Folders), len(m.Files))
+	return &manifestResult{Folders: len(m.Folders), Files: len(m.Files)}, nil
+}
+
+// cachedObject returns the object at remote from the cache seeded by
+// importmanifest, if it is there
+func (f *Fs) cachedObject(remote string) (*Object, bool) {
+	f.objectCacheMu.Lock()
+	defer f.objectCacheMu.Unlock()
+	file, ok := f.objectCache[remote]
+	if !ok {
+		return nil, false
+	}
+	o := &Object{
+		fs:       f,
+		remote:   remote,
+		modTime:  file.Uploaded,
+		hash:     file.Hash,
+		fileCode: file.FileCode,
+	}
+	o.setSize(file.Size)
+	return o, true
+}
+
+// forgetObject removes remote from the cache seeded by importmanifest
+// and its direct link from the link cache
+func (f *Fs) forgetObject(remote string) {
+	f.objectCacheMu.Lock()
+	delete(f.objectCache, remote)
+	f.objectCacheMu.Unlock()
+	f.forgetDirectLink("", path.Join(f.Root(), remote))
+}
@@ -0,0 +1,54 @@
+package filelu
+
+import (
+	"context"
+	"net/http"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestManifestRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	listings := map[string]interface{}{
+		"": map[string]interface{}{
+			"files":   []map[string]interface{}{{"name": "a.txt", "file_code": "aaaaaaaaaaaa", "hash": "hash-a", "size": 1, "uploaded": "2024-01-02 03:04:05"}},
+			"folders": []map[string]interface{}{{"name": "sub", "fld_id": 7}},
+		},
+		"/sub": map[string]interface{}{
+			"files": []map[string]interface{}{{"name": "b.txt", "file_code": "bbbbbbbbbbbb", "hash": "hash-b", "size": 2, "uploaded": "2024-01-02 03:04:05"}},
+		},
+	}
+	src := newTestFs(t, "", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/folder/list", r.URL.Path)
+		writeJSON(t, w, map[string]interface{}{"status": 200, "result": listings[r.URL.Query().Get("folder_path")]})
+	}))
+	manifestPath := filepath.Join(t.TempDir(), "manifest.json")
+	out, err := src.Command(ctx, "exportmanifest", []string{manifestPath}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, &manifestResult{Folders: 1, Files: 2}, out)
+
+	// A fresh Fs which fails any API call must be able to find
+	// everything in the manifest
+	dst := newTestFs(t, "", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("unexpected request %q", r.URL.Path)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	out, err = dst.Command(ctx, "importmanifest", []string{manifestPath}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, &manifestResult{Folders: 1, Files: 2}, out)
+
+	id, err := dst.dirCache.FindDir(ctx, "sub", false)
+	require.NoError(t, err)
+	assert.Equal(t, "7", id)
+
+	obj, err := dst.NewObject(ctx, "sub/b.txt")
+	require.NoError(t, err)
+	o := obj.(*Object)
+	assert.Equal(t, "bbbbbbbbbbbb", o.fileCode)
+	assert.Equal(t, "hash-b", o.hash)
+	assert.Equal(t, int64(2), o.Size())
+	assert.Equal(t, "2024-01-02 03:04:05", o.ModTime(ctx).Format(uploadedTimeFormat))
+}
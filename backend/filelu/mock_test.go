@@ -0,0 +1,602 @@
+package filelu
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/config/configmap"
+	"github.com/rclone/rclone/fs/hash"
+	"github.com/rclone/rclone/fs/object"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// mockFolder is a folder held by mockServer
+type mockFolder struct {
+	id     int64
+	parent int64
+	name   string
+}
+
+// mockFile is a file held by mockServer
+type mockFile struct {
+	code       string
+	folder     int64
+	name       string
+	content    []byte
+	uploaded   time.Time
+	processing bool // whether file/info reports the file as processing and empty
+}
+
+// mockServer is an in memory FileLu which implements enough of the API
+// to exercise the backend offline.
+//
+// Uploads land in the root folder as they do on FileLu. Files are
+// downloaded from /download/<file_code>, which supports ranges, and
+// mirrors under /unavailable/ always fail.
+type mockServer struct {
+	t *testing.T
+
+	mu       sync.Mutex
+	folders  map[int64]*mockFolder
+	files    map[string]*mockFile
+	nextID   int64
+	calls    map[string]int // number of calls by URL path
+	sessions map[string]bool
+	corrupt  int            // number of uploads to corrupt before storing them
+	lostRace int            // number of folder creations to lose to another client
+	failMove int            // number of file moves to fail
+	redirect int            // number of redirects direct links go through, -1 for a loop
+	utype    string         // account type account/info reports, "prem" if not set
+	zeroList bool           // whether folder/list reports every file as empty
+	mirrors  int            // number of unavailable mirrors direct links offer first
+	down     map[string]int // number of calls by URL path to answer with a maintenance page
+
+	uploading   map[string]bool // upload sessions with an upload in progress
+	uploadDelay time.Duration   // how long each upload takes
+}
+
+// newMockServer returns an empty mockServer
+func newMockServer(t *testing.T) *mockServer {
+	return &mockServer{
+		t:        t,
+		folders:  map[int64]*mockFolder{},
+		files:    map[string]*mockFile{},
+		nextID:   1,
+		calls:    map[string]int{},
+		sessions: map[string]bool{},
+		down:     map[string]int{},
+
+		uploading: map[string]bool{},
+	}
+}
+
+// newMockFs returns an Fs rooted at root talking to a new mockServer,
+// with the default options overridden by config
+func newMockFs(t *testing.T, root string, config configmap.Simple) (*Fs, *mockServer) {
+	m := newMockServer(t)
+	return newTestFsOpt(t, root, config, m), m
+}
+
+// mkdir makes the folders in dir, a slash separated path, returning the
+// ID of the last one
+func (m *mockServer) mkdir(dir string) int64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	id := int64(0)
+	for _, name := range strings.Split(strings.Trim(dir, "/"), "/") {
+		if name == "" {
+			continue
+		}
+		child, ok := m.findFolder(id, name)
+		if !ok {
+			child = m.newID()
+			m.folders[child] = &mockFolder{id: child, parent: id, name: name}
+		}
+		id = child
+	}
+	return id
+}
+
+// accountUType returns the account type account/info reports
+func (m *mockServer) accountUType() string {
+	if m.utype == "" {
+		return "prem"
+	}
+	return m.utype
+}
+
+// mockFolderCode returns the folder code of the folder with ID id
+func mockFolderCode(id int64) string {
+	return fmt.Sprintf("fold%08d", id)
+}
+
+// addFile adds a file with content at filePath, making its folders, and
+// returns its file code
+func (m *mockServer) addFile(filePath string, content string) string {
+	dir, name := "", filePath
+	if i := strings.LastIndex(filePath, "/"); i >= 0 {
+		dir, name = filePath[:i], filePath[i+1:]
+	}
+	folder := m.mkdir(dir)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.newFile(folder, name, []byte(content))
+}
+
+// contents returns the content of every file by its path
+func (m *mockServer) contents() map[string]string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	files := map[string]string{}
+	for _, file := range m.files {
+		var parts []string
+		for id := file.folder; id != 0; id = m.folders[id].parent {
+			parts = append([]string{m.folders[id].name}, parts...)
+		}
+		files[path.Join(append(parts, file.name)...)] = string(file.content)
+	}
+	return files
+}
+
+// callCount returns the number of calls made to the endpoint at urlPath
+func (m *mockServer) callCount(urlPath string) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.calls[urlPath]
+}
+
+// newID returns an unused folder ID - call with the lock held
+func (m *mockServer) newID() int64 {
+	id := m.nextID
+	m.nextID++
+	return id
+}
+
+// newFile adds a file and returns its code - call with the lock held
+func (m *mockServer) newFile(folder int64, name string, content []byte) string {
+	code := fmt.Sprintf("mock%08d", m.newID())
+	m.files[code] = &mockFile{
+		code:     code,
+		folder:   folder,
+		name:     name,
+		content:  content,
+		uploaded: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+	}
+	return code
+}
+
+// findFolder finds the folder called name in parent - call with the lock held
+func (m *mockServer) findFolder(parent int64, name string) (int64, bool) {
+	for _, folder := range m.folders {
+		if folder.parent == parent && folder.name == name {
+			return folder.id, true
+		}
+	}
+	return 0, false
+}
+
+// resolveFolder returns the ID of the folder at folderPath - call with
+// the lock held
+func (m *mockServer) resolveFolder(folderPath string) (int64, bool) {
+	id := int64(0)
+	for _, name := range strings.Split(strings.Trim(folderPath, "/"), "/") {
+		if name == "" {
+			continue
+		}
+		var ok bool
+		if id, ok = m.findFolder(id, name); !ok {
+			return 0, false
+		}
+	}
+	return id, true
+}
+
+// resolveFile returns the file selected by file_code or file_path in q -
+// call with the lock held
+func (m *mockServer) resolveFile(q map[string][]string) (*mockFile, bool) {
+	get := func(key string) string {
+		if values := q[key]; len(values) > 0 {
+			return values[0]
+		}
+		return ""
+	}
+	if code := get("file_code"); code != "" {
+		file, ok := m.files[code]
+		return file, ok
+	}
+	filePath := strings.Trim(get("file_path"), "/")
+	dir, name := "", filePath
+	if i := strings.LastIndex(filePath, "/"); i >= 0 {
+		dir, name = filePath[:i], filePath[i+1:]
+	}
+	folder, ok := m.resolveFolder(dir)
+	if !ok {
+		return nil, false
+	}
+	for _, file := range m.files {
+		if file.folder == folder && file.name == name {
+			return file, true
+		}
+	}
+	return nil, false
+}
+
+// startUpload marks the upload session sessID as in use, returning how
+// long the upload should take or false if the session is already in use
+func (m *mockServer) startUpload(sessID string) (time.Duration, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.uploading[sessID] {
+		return 0, false
+	}
+	m.uploading[sessID] = true
+	return m.uploadDelay, true
+}
+
+// endUpload marks the upload session sessID as no longer in use
+func (m *mockServer) endUpload(sessID string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.uploading, sessID)
+}
+
+// reply writes v as the JSON response
+func (m *mockServer) reply(w http.ResponseWriter, v interface{}) {
+	writeJSON(m.t, w, v)
+}
+
+// replyStatus writes a response with status and msg
+func (m *mockServer) replyStatus(w http.ResponseWriter, status int, msg string) {
+	m.reply(w, map[string]interface{}{"status": status, "msg": msg})
+}
+
+// ServeHTTP implements the API endpoints
+func (m *mockServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseMultipartForm(32 << 20); err != nil && err != http.ErrNotMultipart {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if r.URL.Path == "/upload" {
+		// A session only takes one upload at a time
+		sessID := r.FormValue("sess_id")
+		delay, ok := m.startUpload(sessID)
+		if !ok {
+			m.reply(w, []map[string]string{{"file_status": "session busy"}})
+			return
+		}
+		defer m.endUpload(sessID)
+		time.Sleep(delay)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.calls[r.URL.Path]++
+	if m.down[r.URL.Path] > 0 {
+		m.down[r.URL.Path]--
+		w.Header().Set("Content-Type", "text/html")
+		_, _ = io.WriteString(w, "<html><body>FileLu is down for maintenance</body></html>")
+		return
+	}
+	q := r.Form
+	srvURL := "http://" + r.Host
+
+	switch r.URL.Path {
+	case "/folder/list":
+		id, ok := int64(0), true
+		if fldID := q.Get("fld_id"); fldID != "" {
+			var err error
+			id, err = strconv.ParseInt(fldID, 10, 64)
+			_, exists := m.folders[id]
+			ok = err == nil && (id == 0 || exists)
+		} else {
+			id, ok = m.resolveFolder(q.Get("folder_path"))
+		}
+		if !ok {
+			m.replyStatus(w, 404, "Folder not found")
+			return
+		}
+		folders := []map[string]interface{}{}
+		for _, folder := range m.folders {
+			if folder.parent == id {
+				folders = append(folders, map[string]interface{}{"name": folder.name, "fld_id": folder.id, "code": mockFolderCode(folder.id)})
+			}
+		}
+		files := []map[string]interface{}{}
+		for _, file := range m.files {
+			if file.folder == id {
+				size := len(file.content)
+				if m.zeroList {
+					size = 0
+				}
+				files = append(files, map[string]interface{}{
+					"name":      file.name,
+					"file_code": file.code,
+					"fld_id":    file.folder,
+					"size":      size,
+					"hash":      fmt.Sprintf("%x", md5.Sum(file.content)),
+					"uploaded":  file.uploaded.Format(uploadedTimeFormat),
+				})
+			}
+		}
+		sort.Slice(folders, func(i, j int) bool { return folders[i]["name"].(string) < folders[j]["name"].(string) })
+		sort.Slice(files, func(i, j int) bool { return files[i]["name"].(string) < files[j]["name"].(string) })
+		m.reply(w, map[string]interface{}{"status": 200, "result": map[string]interface{}{"folders": folders, "files": files}})
+
+	case "/folder/create":
+		parent, err := strconv.ParseInt(q.Get("parent_id"), 10, 64)
+		if _, exists := m.folders[parent]; err != nil || (parent != 0 && !exists) {
+			m.replyStatus(w, 404, "Parent folder not found")
+			return
+		}
+		id, ok := m.findFolder(parent, q.Get("name"))
+		if !ok {
+			id = m.newID()
+			m.folders[id] = &mockFolder{id: id, parent: parent, name: q.Get("name")}
+		}
+		if m.lostRace > 0 {
+			// Someone else made it first
+			m.lostRace--
+			m.replyStatus(w, 400, "Folder already exists")
+			return
+		}
+		m.reply(w, map[string]interface{}{"status": 200, "result": map[string]interface{}{"fld_id": id}})
+
+	case "/folder/rename":
+		id, ok := m.resolveFolder(q.Get("folder_path"))
+		if !ok || id == 0 {
+			m.replyStatus(w, 404, "Folder not found")
+			return
+		}
+		m.folders[id].name = q.Get("name")
+		m.replyStatus(w, 200, "OK")
+
+	case "/folder/move":
+		id, ok := m.resolveFolder(q.Get("folder_path"))
+		dest, destOK := m.resolveFolder(q.Get("dest_folder_path"))
+		if !ok || id == 0 || !destOK {
+			m.replyStatus(w, 404, "Folder not found")
+			return
+		}
+		m.folders[id].parent = dest
+		m.replyStatus(w, 200, "OK")
+
+	case "/folder/delete":
+		id, ok := m.resolveFolder(q.Get("folder_path"))
+		if !ok || id == 0 {
+			m.replyStatus(w, 404, "Folder not found")
+			return
+		}
+		for _, folder := range m.folders {
+			if folder.parent == id {
+				m.replyStatus(w, 400, "Folder is not empty")
+				return
+			}
+		}
+		for _, file := range m.files {
+			if file.folder == id {
+				m.replyStatus(w, 400, "Folder is not empty")
+				return
+			}
+		}
+		delete(m.folders, id)
+		m.replyStatus(w, 200, "OK")
+
+	case "/file/remove":
+		var codes []string
+		if code := q.Get("file_code"); code != "" {
+			codes = strings.Split(code, ",")
+		} else if file, ok := m.resolveFile(q); ok {
+			codes = []string{file.code}
+		}
+		for _, code := range codes {
+			if _, ok := m.files[code]; !ok {
+				m.replyStatus(w, 404, "File not found")
+				return
+			}
+		}
+		if len(codes) == 0 {
+			m.replyStatus(w, 404, "File not found")
+			return
+		}
+		for _, code := range codes {
+			delete(m.files, code)
+		}
+		m.replyStatus(w, 200, "OK")
+
+	case "/file/set_folder":
+		if m.failMove > 0 {
+			m.failMove--
+			m.replyStatus(w, 500, "Move failed")
+			return
+		}
+		file, ok := m.resolveFile(q)
+		if !ok {
+			m.replyStatus(w, 404, "File not found")
+			return
+		}
+		folder, ok := m.resolveFolder(q.Get("destination_folder_path"))
+		if !ok {
+			m.replyStatus(w, 404, "Folder not found")
+			return
+		}
+		file.folder = folder
+		m.replyStatus(w, 200, "OK")
+
+	case "/file/rename":
+		file, ok := m.resolveFile(q)
+		if !ok {
+			m.replyStatus(w, 404, "File not found")
+			return
+		}
+		file.name = q.Get("name")
+		m.replyStatus(w, 200, "OK")
+
+	case "/file/clone":
+		file, ok := m.resolveFile(q)
+		if !ok {
+			m.replyStatus(w, 404, "File not found")
+			return
+		}
+		code := m.newFile(0, file.name, file.content)
+		m.reply(w, map[string]interface{}{"status": 200, "result": map[string]string{"filecode": code}})
+
+	case "/file/info":
+		file, ok := m.resolveFile(q)
+		if !ok {
+			m.replyStatus(w, 404, "File not found")
+			return
+		}
+		info := map[string]string{
+			"file_code": file.code,
+			"name":      file.name,
+			"size":      strconv.Itoa(len(file.content)),
+			"uploaded":  file.uploaded.Format(uploadedTimeFormat),
+			"hash":      fmt.Sprintf("%x", md5.Sum(file.content)),
+		}
+		if file.processing {
+			info["size"], info["file_status"] = "0", uploadStatusProcessing
+		}
+		m.reply(w, map[string]interface{}{"status": 200, "result": []map[string]string{info}})
+
+	case "/file/direct_link":
+		file, ok := m.resolveFile(q)
+		if !ok {
+			m.replyStatus(w, 404, "File not found")
+			return
+		}
+		link := srvURL + "/download/" + file.code
+		if m.redirect != 0 {
+			link = fmt.Sprintf("%s/redirect/%d/%s", srvURL, m.redirect, file.code)
+		}
+		result := map[string]interface{}{"url": link, "size": len(file.content)}
+		if m.mirrors > 0 {
+			var urls []string
+			for i := 0; i < m.mirrors; i++ {
+				urls = append(urls, fmt.Sprintf("%s/unavailable/%d/%s", srvURL, i, file.code))
+			}
+			result["url"] = urls[0]
+			result["urls"] = append(urls, link)
+		}
+		m.reply(w, map[string]interface{}{"status": 200, "result": result})
+
+	case "/upload/server":
+		sessID := fmt.Sprintf("sess%d", len(m.sessions))
+		m.sessions[sessID] = true
+		m.reply(w, map[string]interface{}{"status": 200, "sess_id": sessID, "result": srvURL + "/upload"})
+
+	case "/account/info":
+		m.reply(w, map[string]interface{}{"status": 200, "result": map[string]interface{}{"utype": m.accountUType()}})
+
+	case "/upload":
+		if !m.sessions[r.FormValue("sess_id")] {
+			m.reply(w, []map[string]string{{"file_status": "bad session"}})
+			return
+		}
+		if want := map[string]string{"prem": "prem", "free": "reg"}[m.accountUType()]; r.FormValue("utype") != want {
+			m.reply(w, []map[string]string{{"file_status": "Invalid utype for this account"}})
+			return
+		}
+		file, header, err := r.FormFile("file_0")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		content, err := io.ReadAll(file)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if m.corrupt > 0 {
+			m.corrupt--
+			content = append(content, '!')
+		}
+		code := m.newFile(0, header.Filename, content)
+		m.reply(w, []map[string]string{{"file_code": code, "file_status": "OK"}})
+
+	default:
+		if code, ok := strings.CutPrefix(r.URL.Path, "/download/"); ok {
+			file, ok := m.files[code]
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+			http.ServeContent(w, r, file.name, file.uploaded, bytes.NewReader(file.content))
+			return
+		}
+		if rest, ok := strings.CutPrefix(r.URL.Path, "/redirect/"); ok {
+			n, code, _ := strings.Cut(rest, "/")
+			left, _ := strconv.Atoi(n)
+			switch {
+			case left < 0:
+				http.Redirect(w, r, r.URL.Path, http.StatusFound)
+			case left <= 1:
+				http.Redirect(w, r, "/download/"+code, http.StatusFound)
+			default:
+				http.Redirect(w, r, fmt.Sprintf("/redirect/%d/%s", left-1, code), http.StatusFound)
+			}
+			return
+		}
+		if strings.HasPrefix(r.URL.Path, "/unavailable/") {
+			http.Error(w, "mirror unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		m.t.Errorf("mock: unexpected request %q", r.URL.Path)
+		http.NotFound(w, r)
+	}
+}
+
+func TestMockServer(t *testing.T) {
+	ctx := context.Background()
+	f, m := newMockFs(t, "", nil)
+	m.addFile("docs/readme.txt", "read me")
+
+	// Upload into a new folder
+	content := "hello, world"
+	src := object.NewStaticObjectInfo("docs/new/hello.txt", time.Now(), int64(len(content)), true, nil, nil)
+	obj, err := f.Put(ctx, strings.NewReader(content), src)
+	require.NoError(t, err)
+	assert.Equal(t, 1, m.callCount("/upload"))
+
+	// List it
+	entries, err := f.List(ctx, "docs")
+	require.NoError(t, err)
+	var remotes []string
+	for _, entry := range entries {
+		remotes = append(remotes, entry.Remote())
+	}
+	assert.Equal(t, []string{"docs/new", "docs/readme.txt"}, remotes)
+
+	// Read it back
+	obj, err = f.NewObject(ctx, obj.Remote())
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(content)), obj.Size())
+	in, err := obj.Open(ctx, &fs.RangeOption{Start: 7, End: -1})
+	require.NoError(t, err)
+	data, err := io.ReadAll(in)
+	require.NoError(t, err)
+	require.NoError(t, in.Close())
+	assert.Equal(t, "world", string(data))
+	sum, err := obj.Hash(ctx, hash.MD5)
+	require.NoError(t, err)
+	assert.Equal(t, fmt.Sprintf("%x", md5.Sum([]byte(content))), sum)
+
+	// Remove it and its folder
+	require.NoError(t, obj.Remove(ctx))
+	_, err = f.NewObject(ctx, obj.Remote())
+	assert.Equal(t, fs.ErrorObjectNotFound, err)
+	require.NoError(t, f.Rmdir(ctx, "docs/new"))
+	_, err = f.List(ctx, "docs/new")
+	assert.Equal(t, fs.ErrorDirNotFound, err)
+}
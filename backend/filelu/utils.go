@@ -1,15 +1,479 @@
 package filelu
 
 import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rclone/rclone/backend/filelu/api"
+	"github.com/rclone/rclone/fs"
+)
+
+// Units of storage values reported without one. account/info gives the
+// size of the plan as a count of GB and the storage used as a count of
+// bytes.
+const (
+	storageBareUnit     = 1 << 30
+	storageUsedBareUnit = 1
 )
 
-// parseStorageToBytes converts a storage string (e.g., "10") to bytes
-func parseStorageToBytes(storage string) (int64, error) {
-	var gb float64
-	_, err := fmt.Sscanf(storage, "%f", &gb)
+// storageUnits maps the units FileLu may use to their size in bytes
+var storageUnits = map[string]float64{
+	"b":     1,
+	"byte":  1,
+	"bytes": 1,
+	"k":     1 << 10,
+	"kb":    1 << 10,
+	"kib":   1 << 10,
+	"m":     1 << 20,
+	"mb":    1 << 20,
+	"mib":   1 << 20,
+	"g":     1 << 30,
+	"gb":    1 << 30,
+	"gib":   1 << 30,
+	"t":     1 << 40,
+	"tb":    1 << 40,
+	"tib":   1 << 40,
+	"p":     1 << 50,
+	"pb":    1 << 50,
+	"pib":   1 << 50,
+}
+
+// parseStorageToBytes converts a storage string to bytes
+//
+// It accepts a number with an optional unit, with or without whitespace
+// in between, e.g. "10", "10 GB", "10GB", "10.5 TB" or "1073741824".
+// A bare number is a count of bareUnit bytes.
+func parseStorageToBytes(storage string, bareUnit int64) (int64, error) {
+	s := strings.TrimSpace(storage)
+	i := 0
+	for i < len(s) && (s[i] >= '0' && s[i] <= '9' || s[i] == '.') {
+		i++
+	}
+	number, unit := s[:i], strings.ToLower(strings.TrimSpace(s[i:]))
+	value, err := strconv.ParseFloat(number, 64)
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse storage %q: %w", storage, err)
+	}
+	var multiplier float64
+	if unit == "" {
+		multiplier = float64(bareUnit)
+	} else {
+		var ok bool
+		multiplier, ok = storageUnits[unit]
+		if !ok {
+			return 0, fmt.Errorf("failed to parse storage %q: unknown unit %q", storage, unit)
+		}
+	}
+	return int64(value * multiplier), nil
+}
+
+// uploadedTimeFormat is the layout of the "uploaded" field in listings
+const uploadedTimeFormat = "2006-01-02 15:04:05"
+
+// accountTypeOf returns the type of account, see the account_type
+// option, for the utype account/info reports, or "" if it isn't known
+func accountTypeOf(utype string) string {
+	switch strings.ToLower(strings.TrimSpace(utype)) {
+	case "prem", "premium":
+		return accountTypePremium
+	case "reg", "registered", "free":
+		return accountTypeFree
+	}
+	return ""
+}
+
+// rejectedField returns the name of the upload form field in fields that
+// the file_status of a failed upload, status, names, or "" if it doesn't
+// name one
+func rejectedField(status string, fields url.Values) string {
+	status = strings.ToLower(status)
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if strings.Contains(status, key) {
+			return key
+		}
+	}
+	return ""
+}
+
+// unknownModTime is the modification time of files and folders FileLu
+// doesn't give a time for. It is fixed rather than the time of listing so
+// that listings of the same files are always the same.
+var unknownModTime = time.Unix(0, 0)
+
+// parseUploadedTime parses the upload time reported by folder/list
+func parseUploadedTime(uploaded string) (time.Time, error) {
+	t, err := time.Parse(uploadedTimeFormat, strings.TrimSpace(uploaded))
 	if err != nil {
-		return 0, fmt.Errorf("failed to parse storage: %w", err)
+		return time.Time{}, fmt.Errorf("failed to parse upload time %q: %w", uploaded, err)
+	}
+	return t, nil
+}
+
+// decodeContent returns data, a response body with the Content-Encoding
+// encoding, decoded.
+//
+// Some servers send raw deflate data rather than the zlib format HTTP
+// asks for, so deflate data which isn't zlib is read as raw deflate.
+func decodeContent(encoding string, data []byte) ([]byte, error) {
+	var r io.ReadCloser
+	var err error
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "", "identity":
+		return data, nil
+	case "gzip", "x-gzip":
+		r, err = gzip.NewReader(bytes.NewReader(data))
+	case "deflate":
+		r, err = zlib.NewReader(bytes.NewReader(data))
+		if err != nil {
+			r = flate.NewReader(bytes.NewReader(data))
+			err = nil
+		}
+	default:
+		return nil, fmt.Errorf("unsupported Content-Encoding %q", encoding)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode %s response: %w", encoding, err)
+	}
+	defer func() {
+		_ = r.Close()
+	}()
+	decoded, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode %s response: %w", encoding, err)
+	}
+	return decoded, nil
+}
+
+// unavailableError returns an error wrapping errUnavailable if the API
+// response with statusCode, contentType and body says FileLu is down,
+// or nil otherwise.
+//
+// During maintenance FileLu answers with 503, with an HTML page instead
+// of JSON, or with a JSON message mentioning maintenance.
+func unavailableError(statusCode int, contentType string, body []byte) error {
+	if statusCode == http.StatusServiceUnavailable {
+		return fmt.Errorf("%w: HTTP %d", errUnavailable, statusCode)
+	}
+	if statusCode != http.StatusOK {
+		return nil
+	}
+	trimmed := bytes.TrimSpace(body)
+	if strings.HasPrefix(contentType, "text/html") || bytes.HasPrefix(trimmed, []byte("<")) {
+		return fmt.Errorf("%w: received an HTML page instead of an API response", errUnavailable)
+	}
+	var reply struct {
+		Msg string `json:"msg"`
+	}
+	if json.Unmarshal(trimmed, &reply) == nil && strings.Contains(strings.ToLower(reply.Msg), "maintenance") {
+		return fmt.Errorf("%w: %s", errUnavailable, reply.Msg)
+	}
+	return nil
+}
+
+// uploadServerURL returns the upload URL from the result of an
+// upload/server response. It is normally a string but is also read from
+// an object holding it under url or upload_url.
+func uploadServerURL(result json.RawMessage) string {
+	var rawURL string
+	if json.Unmarshal(result, &rawURL) == nil {
+		return rawURL
+	}
+	var fields struct {
+		URL       string `json:"url"`
+		UploadURL string `json:"upload_url"`
+	}
+	if json.Unmarshal(result, &fields) != nil {
+		return ""
+	}
+	if fields.UploadURL != "" {
+		return fields.UploadURL
+	}
+	return fields.URL
+}
+
+// checkUploadURL returns an error unless rawURL, an upload URL returned
+// by upload/server, is an absolute http or https URL
+func checkUploadURL(rawURL string) error {
+	if rawURL == "" {
+		return errors.New("upload server returned no upload URL")
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return fmt.Errorf("upload server returned a bad upload URL: %w", err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("upload server returned %q which isn't an absolute http(s) URL", rawURL)
+	}
+	return nil
+}
+
+// tempSlugRe matches the runs of characters not kept in temporary file
+// names
+var tempSlugRe = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// maxTempSlug is the most characters of a remote put in a temporary file
+// name
+const maxTempSlug = 64
+
+// tempFilePattern returns the os.CreateTemp pattern for a temporary file
+// staging remote. The name starts with prefix followed by a slug of
+// remote so the file can be matched up with its remote in the logs.
+func tempFilePattern(prefix, remote string) string {
+	slug := strings.Trim(tempSlugRe.ReplaceAllString(remote, "_"), "_.")
+	if len(slug) > maxTempSlug {
+		// Keep the end as it has the file name
+		slug = slug[len(slug)-maxTempSlug:]
+	}
+	if slug == "" {
+		return prefix + "-*.tmp"
+	}
+	return prefix + "-" + slug + "-*.tmp"
+}
+
+// folderGoneMessages are the parts of a folder/list error message which
+// mean the folder doesn't exist, for example because it was deleted
+// outside rclone
+var folderGoneMessages = []string{"not found", "deleted", "no such folder", "invalid folder"}
+
+// isFolderGone returns whether a folder/list response with status and
+// msg means the folder listed doesn't exist
+func isFolderGone(status int, msg string) bool {
+	if status == 200 {
+		return false
+	}
+	if status == 404 {
+		return true
+	}
+	msg = strings.ToLower(msg)
+	for _, gone := range folderGoneMessages {
+		if strings.Contains(msg, gone) {
+			return true
+		}
+	}
+	return false
+}
+
+// isFolderExists returns whether a folder/create response with status and
+// msg means a folder with that name is already there, for example
+// because another rclone created it at the same time
+func isFolderExists(status int, msg string) bool {
+	return status != 200 && strings.Contains(strings.ToLower(msg), "already exist")
+}
+
+// isFolderNotEmpty returns whether a folder/delete response with status
+// and msg means the folder still has files or folders in it
+func isFolderNotEmpty(status int, msg string) bool {
+	return status != 200 && strings.Contains(strings.ToLower(msg), "not empty")
+}
+
+// folderDecorationRe matches a folder name decorated with its ID, as in
+// "(123) name"
+var folderDecorationRe = regexp.MustCompile(`^\((\d+)\) (.*)$`)
+
+// matchFolderName returns the index of the folder in folders called
+// name, or -1 if there isn't one.
+//
+// FileLu may trim the spaces around a name when it creates a folder and a
+// path part may have been trimmed, so if no name matches exactly one
+// matching once surrounding spaces are ignored is returned.
+func matchFolderName(folders []api.FolderListFolder, name string) int {
+	loose := -1
+	for i, folder := range folders {
+		if folder.Name == name {
+			return i
+		}
+		if loose < 0 && strings.TrimSpace(folder.Name) == strings.TrimSpace(name) {
+			loose = i
+		}
+	}
+	return loose
+}
+
+// decorateFolderName returns name decorated with the folder ID id
+func decorateFolderName(id int64, name string) string {
+	return fmt.Sprintf("(%d) %s", id, name)
+}
+
+// parseFolderDecoration splits a folder name decorated with its ID into
+// the ID and the name, with ok false if it isn't decorated
+func parseFolderDecoration(leaf string) (id string, name string, ok bool) {
+	match := folderDecorationRe.FindStringSubmatch(leaf)
+	if match == nil {
+		return "", leaf, false
+	}
+	return match[1], match[2], true
+}
+
+// stripFolderIDs removes the folder ID decorations from each element of p
+func stripFolderIDs(p string) string {
+	parts := strings.Split(p, "/")
+	for i, part := range parts {
+		_, parts[i], _ = parseFolderDecoration(part)
+	}
+	return strings.Join(parts, "/")
+}
+
+// Orders for the entries returned by List, see the list_order option
+const (
+	listOrderName         = "name"
+	listOrderNameDesc     = "name_desc"
+	listOrderSize         = "size"
+	listOrderSizeDesc     = "size_desc"
+	listOrderUploaded     = "uploaded"
+	listOrderUploadedDesc = "uploaded_desc"
+)
+
+// entryFileCode returns the file code of entry or "" if it doesn't have one
+func entryFileCode(entry fs.DirEntry) string {
+	if o, ok := entry.(*Object); ok {
+		return o.fileCode
+	}
+	return ""
+}
+
+// isFileCode returns whether s looks like a FileLu file code.
+//
+// File codes are lower case letters and digits. They have been 12
+// characters long but any length from minFileCodeLen to maxFileCodeLen
+// is accepted in case FileLu changes that. A code which is all digits is
+// taken to be a folder ID instead.
+func isFileCode(s string) bool {
+	if len(s) < minFileCodeLen || len(s) > maxFileCodeLen {
+		return false
+	}
+	letters := false
+	for _, c := range s {
+		switch {
+		case c >= 'a' && c <= 'z':
+			letters = true
+		case c >= '0' && c <= '9':
+		default:
+			return false
+		}
+	}
+	return letters
+}
+
+// folderSize returns the size in bytes a folder listing reports for a
+// folder, given as a number or a string of digits, or -1 if it doesn't
+// report one
+func folderSize(raw json.RawMessage) int64 {
+	var size json.Number
+	if json.Unmarshal(raw, &size) != nil {
+		return -1
+	}
+	n, err := strconv.ParseInt(size.String(), 10, 64)
+	if err != nil || n < 0 {
+		return -1
+	}
+	return n
+}
+
+// fileVersion returns a version of the file file/info describes which
+// changes when its content does.
+//
+// FileLu doesn't report a version as such so it is made from the size,
+// upload time and hash, which a new upload changes.
+func fileVersion(info api.FileInfo) string {
+	return info.Size + "/" + strings.TrimSpace(info.Uploaded) + "/" + strings.ToLower(info.Hash)
+}
+
+// mirrorURLs returns the download URLs from a file/direct_link result,
+// first then the others, without empty or repeated ones
+func mirrorURLs(first string, others []string) []string {
+	urls := make([]string, 0, 1+len(others))
+	seen := make(map[string]bool, 1+len(others))
+	for _, u := range append([]string{first}, others...) {
+		if u != "" && !seen[u] {
+			seen[u] = true
+			urls = append(urls, u)
+		}
+	}
+	return urls
+}
+
+// orderMirrors returns urls in the order to try them in for selection,
+// see the mirror_selection option
+func orderMirrors(urls []string, selection string) []string {
+	if selection != mirrorSelectionRandom || len(urls) < 2 {
+		return urls
+	}
+	shuffled := append([]string(nil), urls...)
+	rand.Shuffle(len(shuffled), func(i, j int) {
+		shuffled[i], shuffled[j] = shuffled[j], shuffled[i]
+	})
+	return shuffled
+}
+
+// sortEntries sorts entries into order.
+//
+// Ties are broken by file code then by remote so the result is the same
+// whatever order FileLu returned the entries in.
+func sortEntries(entries fs.DirEntries, order string) error {
+	ctx := context.Background()
+	var compare func(a, b fs.DirEntry) int
+	switch strings.TrimSuffix(order, "_desc") {
+	case listOrderName:
+		compare = func(a, b fs.DirEntry) int {
+			return strings.Compare(a.Remote(), b.Remote())
+		}
+	case listOrderSize:
+		compare = func(a, b fs.DirEntry) int {
+			return compareInt64(a.Size(), b.Size())
+		}
+	case listOrderUploaded:
+		compare = func(a, b fs.DirEntry) int {
+			return a.ModTime(ctx).Compare(b.ModTime(ctx))
+		}
+	default:
+		return fmt.Errorf("unknown list_order %q", order)
+	}
+	desc := strings.HasSuffix(order, "_desc")
+	sort.SliceStable(entries, func(i, j int) bool {
+		a, b := entries[i], entries[j]
+		c := compare(a, b)
+		if desc {
+			c = -c
+		}
+		if c == 0 {
+			c = strings.Compare(entryFileCode(a), entryFileCode(b))
+		}
+		if c == 0 {
+			c = strings.Compare(a.Remote(), b.Remote())
+		}
+		return c < 0
+	})
+	return nil
+}
+
+// compareInt64 returns -1, 0 or +1 depending on whether a is less than,
+// equal to or greater than b
+func compareInt64(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
 	}
-	return int64(gb * 1024 * 1024 * 1024), nil
+	return 0
 }
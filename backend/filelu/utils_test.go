@@ -0,0 +1,356 @@
+package filelu
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rclone/rclone/backend/filelu/api"
+	"github.com/rclone/rclone/fs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseStorageToBytes(t *testing.T) {
+	for _, test := range []struct {
+		in       string
+		bareUnit int64
+		want     int64
+		wantErr  bool
+	}{
+		{in: "10", bareUnit: storageBareUnit, want: 10 << 30},
+		{in: " 10 ", bareUnit: storageBareUnit, want: 10 << 30},
+		{in: "10 GB", want: 10 << 30},
+		{in: "10GB", want: 10 << 30},
+		{in: "10gb", want: 10 << 30},
+		{in: "10.5 TB", want: 10.5 * (1 << 40)},
+		{in: "512 MB", want: 512 << 20},
+		{in: "1 KiB", want: 1024},
+		{in: "123 B", want: 123},
+		{in: "1073741824", bareUnit: storageUsedBareUnit, want: 1 << 30},
+		{in: "500000", bareUnit: storageUsedBareUnit, want: 500000},
+		{in: "0", bareUnit: storageUsedBareUnit, want: 0},
+		// Bare values keep their unit however large or small they are
+		{in: "1048575", bareUnit: storageUsedBareUnit, want: 1048575},
+		{in: "1048576", bareUnit: storageUsedBareUnit, want: 1048576},
+		{in: "1048575", bareUnit: storageBareUnit, want: 1048575 << 30},
+		{in: "1048576", bareUnit: storageBareUnit, want: 1048576 << 30},
+		{in: "", bareUnit: storageBareUnit, wantErr: true},
+		{in: "unlimited", bareUnit: storageBareUnit, wantErr: true},
+		{in: "10 XB", bareUnit: storageBareUnit, wantErr: true},
+	} {
+		got, err := parseStorageToBytes(test.in, test.bareUnit)
+		if test.wantErr {
+			assert.Error(t, err, test.in)
+			continue
+		}
+		require.NoError(t, err, test.in)
+		assert.Equal(t, test.want, got, test.in)
+	}
+}
+
+func TestParseUploadedTime(t *testing.T) {
+	got, err := parseUploadedTime("2024-01-02 03:04:05")
+	require.NoError(t, err)
+	assert.Equal(t, "2024-01-02T03:04:05Z", got.Format("2006-01-02T15:04:05Z07:00"))
+
+	_, err = parseUploadedTime("yesterday")
+	assert.Error(t, err)
+}
+
+func TestSortEntries(t *testing.T) {
+	t1 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	t2 := t1.Add(time.Hour)
+	t3 := t2.Add(time.Hour)
+	f := &Fs{}
+	newEntries := func() fs.DirEntries {
+		return fs.DirEntries{
+			&Object{fs: f, remote: "b.txt", size: 10, modTime: t1, fileCode: "cccccccccccc"},
+			&Object{fs: f, remote: "a.txt", size: 20, modTime: t2, fileCode: "bbbbbbbbbbbb"},
+			fs.NewDir("d", t3),
+			&Object{fs: f, remote: "c.txt", size: 10, modTime: t1, fileCode: "aaaaaaaaaaaa"},
+		}
+	}
+	for _, test := range []struct {
+		order string
+		want  []string
+	}{
+		{order: listOrderName, want: []string{"a.txt", "b.txt", "c.txt", "d"}},
+		{order: listOrderNameDesc, want: []string{"d", "c.txt", "b.txt", "a.txt"}},
+		{order: listOrderSize, want: []string{"d", "c.txt", "b.txt", "a.txt"}},
+		{order: listOrderSizeDesc, want: []string{"a.txt", "c.txt", "b.txt", "d"}},
+		{order: listOrderUploaded, want: []string{"c.txt", "b.txt", "a.txt", "d"}},
+		{order: listOrderUploadedDesc, want: []string{"d", "a.txt", "c.txt", "b.txt"}},
+	} {
+		entries := newEntries()
+		require.NoError(t, sortEntries(entries, test.order), test.order)
+		var got []string
+		for _, entry := range entries {
+			got = append(got, entry.Remote())
+		}
+		assert.Equal(t, test.want, got, test.order)
+	}
+
+	assert.Error(t, sortEntries(newEntries(), "random"))
+}
+
+func TestIsFolderGone(t *testing.T) {
+	for _, test := range []struct {
+		status int
+		msg    string
+		want   bool
+	}{
+		{status: 200, msg: "OK", want: false},
+		{status: 404, msg: "", want: true},
+		{status: 400, msg: "Folder not found", want: true},
+		{status: 403, msg: "Folder was deleted", want: true},
+		{status: 400, msg: "Invalid folder id", want: true},
+		{status: 403, msg: "Wrong key", want: false},
+		{status: 500, msg: "Internal error", want: false},
+	} {
+		assert.Equal(t, test.want, isFolderGone(test.status, test.msg), test.msg)
+	}
+}
+
+func TestIsFileCode(t *testing.T) {
+	for in, want := range map[string]bool{
+		"abc123def4":        true,  // 10 characters
+		"abc123def456":      true,  // 12 characters
+		"abc123def456ghi":   true,  // 15 characters
+		"abc123def456ghij":  true,  // 16 characters
+		"abc123def":         false, // too short
+		"abc123def456ghijk": false, // too long
+		"123456789012":      false, // a folder ID
+		"ABC123DEF456":      false,
+		"abc-123-def4":      false,
+		"abc123 def45":      false,
+		"":                  false,
+	} {
+		assert.Equal(t, want, isFileCode(in), in)
+	}
+
+	// Every user of file codes agrees
+	for _, code := range []string{"abc123def4", "abc123def456ghi"} {
+		assert.Equal(t, code, fileCodeFromRemote("dir/("+code+") file.txt"))
+		got, err := fileCodeFromLink("https://filelu.com/" + code + "/file.txt")
+		require.NoError(t, err)
+		assert.Equal(t, code, got)
+		f := &Fs{root: code}
+		assert.Equal(t, code, f.rootFileCode())
+	}
+	assert.Equal(t, "", fileCodeFromRemote("dir/(123456789012) file.txt"))
+	assert.Equal(t, "", (&Fs{root: "123456789012"}).rootFileCode())
+}
+
+func TestIsFolderExists(t *testing.T) {
+	for _, test := range []struct {
+		status int
+		msg    string
+		want   bool
+	}{
+		{status: 200, msg: "OK", want: false},
+		{status: 400, msg: "Folder already exists", want: true},
+		{status: 403, msg: "A folder with this name already exist", want: true},
+		{status: 404, msg: "Parent folder not found", want: false},
+	} {
+		assert.Equal(t, test.want, isFolderExists(test.status, test.msg), test.msg)
+	}
+}
+
+func TestUploadServerURL(t *testing.T) {
+	for _, test := range []struct {
+		result  string
+		want    string
+		wantErr string
+	}{
+		{result: `"https://s1.filelu.com/cgi-bin/upload.cgi"`, want: "https://s1.filelu.com/cgi-bin/upload.cgi"},
+		{result: `{"url":"http://s2.filelu.com/upload"}`, want: "http://s2.filelu.com/upload"},
+		{result: `{"upload_url":"https://s3.filelu.com/upload"}`, want: "https://s3.filelu.com/upload"},
+		{result: `""`, wantErr: "no upload URL"},
+		{result: `null`, wantErr: "no upload URL"},
+		{result: `"/cgi-bin/upload.cgi"`, wantErr: "isn't an absolute http(s) URL"},
+		{result: `"ftp://s1.filelu.com/upload"`, wantErr: "isn't an absolute http(s) URL"},
+		{result: `"https://bad host/"`, wantErr: "bad upload URL"},
+	} {
+		got := uploadServerURL(json.RawMessage(test.result))
+		err := checkUploadURL(got)
+		if test.wantErr != "" {
+			assert.ErrorContains(t, err, test.wantErr, test.result)
+			continue
+		}
+		assert.NoError(t, err, test.result)
+		assert.Equal(t, test.want, got, test.result)
+	}
+}
+
+func TestTempFilePattern(t *testing.T) {
+	for _, test := range []struct {
+		remote string
+		want   string
+	}{
+		{remote: "", want: "upload-*.tmp"},
+		{remote: "file.txt", want: "upload-file.txt-*.tmp"},
+		{remote: "dir/sub dir/file*.txt", want: "upload-dir_sub_dir_file_.txt-*.tmp"},
+		{remote: ".hidden", want: "upload-hidden-*.tmp"},
+		{remote: "ünïcode/файл", want: "upload-n_code-*.tmp"},
+		{remote: strings.Repeat("a", 100) + "/name.txt", want: "upload-" + strings.Repeat("a", 55) + "_name.txt-*.tmp"},
+	} {
+		assert.Equal(t, test.want, tempFilePattern("upload", test.remote), test.remote)
+	}
+}
+
+func TestUnavailableError(t *testing.T) {
+	for _, test := range []struct {
+		status      int
+		contentType string
+		body        string
+		want        bool
+	}{
+		{status: 200, contentType: "application/json", body: `{"status":200,"msg":"OK"}`, want: false},
+		{status: 200, contentType: "application/json", body: `[{"file_status":"OK"}]`, want: false},
+		{status: 503, contentType: "text/plain", body: "down", want: true},
+		{status: 200, contentType: "text/html; charset=utf-8", body: "We'll be back soon", want: true},
+		{status: 200, contentType: "", body: "\n<html><body>Maintenance</body></html>", want: true},
+		{status: 200, contentType: "application/json", body: `{"status":500,"msg":"Site is under maintenance"}`, want: true},
+		{status: 404, contentType: "text/html", body: "<html>Not found</html>", want: false},
+		{status: 500, contentType: "application/json", body: `{"msg":"maintenance"}`, want: false},
+	} {
+		err := unavailableError(test.status, test.contentType, []byte(test.body))
+		assert.Equal(t, test.want, errors.Is(err, errUnavailable), test.body)
+	}
+}
+
+func TestFolderDecoration(t *testing.T) {
+	assert.Equal(t, "(123) name", decorateFolderName(123, "name"))
+
+	for _, test := range []struct {
+		in       string
+		wantID   string
+		wantName string
+		wantOK   bool
+	}{
+		{in: "(123) name", wantID: "123", wantName: "name", wantOK: true},
+		{in: "(123) (45) name", wantID: "123", wantName: "(45) name", wantOK: true},
+		{in: "name", wantName: "name"},
+		{in: "(abc) name", wantName: "(abc) name"},
+		{in: "(123)name", wantName: "(123)name"},
+	} {
+		id, name, ok := parseFolderDecoration(test.in)
+		assert.Equal(t, test.wantID, id, test.in)
+		assert.Equal(t, test.wantName, name, test.in)
+		assert.Equal(t, test.wantOK, ok, test.in)
+	}
+
+	assert.Equal(t, "/a/b/file.txt", stripFolderIDs("/(1) a/(22) b/file.txt"))
+	assert.Equal(t, "a/b", stripFolderIDs("a/b"))
+}
+
+func TestRejectedField(t *testing.T) {
+	fields := url.Values{"sess_id": {"s"}, "utype": {"prem"}}
+	assert.Equal(t, "utype", rejectedField("Invalid UTYPE", fields))
+	assert.Equal(t, "sess_id", rejectedField("bad sess_id", fields))
+	assert.Equal(t, "", rejectedField("server busy", fields))
+}
+
+func TestEmptyArrayResult(t *testing.T) {
+	for _, body := range []string{
+		`{"status":200,"msg":"OK","result":{}}`,
+		`{"status":200,"msg":"OK","result":[]}`,
+		`{"status":200,"msg":"OK","result":[ ]}`,
+	} {
+		var list api.FolderListResponse
+		require.NoError(t, json.Unmarshal([]byte(body), &list), body)
+		assert.Equal(t, 200, list.Status, body)
+		assert.Empty(t, list.Result.Files, body)
+		assert.Empty(t, list.Result.Folders, body)
+
+		var account api.AccountInfoResponse
+		require.NoError(t, json.Unmarshal([]byte(body), &account), body)
+		assert.Equal(t, "OK", account.Msg, body)
+		assert.Equal(t, api.AccountInfoResult{}, account.Result, body)
+
+		var clone api.CloneResponse
+		require.NoError(t, json.Unmarshal([]byte(body), &clone), body)
+		assert.Equal(t, api.CloneResult{}, clone.Result, body)
+
+		var link api.DirectLinkResponse
+		require.NoError(t, json.Unmarshal([]byte(body), &link), body)
+		assert.Equal(t, api.DirectLinkResult{}, link.Result, body)
+
+		var zip api.FolderZipResponse
+		require.NoError(t, json.Unmarshal([]byte(body), &zip), body)
+		assert.Equal(t, api.FolderZipResult{}, zip.Result, body)
+	}
+
+	// Results which aren't empty still decode
+	var list api.FolderListResponse
+	require.NoError(t, json.Unmarshal([]byte(`{"status":200,"result":{"folders":[{"name":"a","fld_id":1}]}}`), &list))
+	require.Len(t, list.Result.Folders, 1)
+	assert.Equal(t, "a", list.Result.Folders[0].Name)
+	var clone api.CloneResponse
+	require.NoError(t, json.Unmarshal([]byte(`{"status":200,"result":{"filecode":"x"}}`), &clone))
+	assert.Equal(t, "x", clone.Result.FileCode)
+
+	// but an array with something in it is still an error
+	assert.Error(t, json.Unmarshal([]byte(`{"result":[{"filecode":"x"}]}`), &clone))
+}
+
+func TestMirrorURLs(t *testing.T) {
+	assert.Equal(t, []string{"a"}, mirrorURLs("a", nil))
+	assert.Equal(t, []string{"a", "b", "c"}, mirrorURLs("a", []string{"b", "a", "", "c"}))
+	assert.Equal(t, []string{"b"}, mirrorURLs("", []string{"b"}))
+	assert.Empty(t, mirrorURLs("", nil))
+
+	urls := []string{"a", "b", "c", "d"}
+	assert.Equal(t, urls, orderMirrors(urls, mirrorSelectionFirst))
+	shuffled := orderMirrors(urls, mirrorSelectionRandom)
+	assert.ElementsMatch(t, urls, shuffled)
+	assert.Equal(t, []string{"a", "b", "c", "d"}, urls, "shuffle changed its input")
+}
+
+func TestDecodeContent(t *testing.T) {
+	const want = `{"status":200}`
+	compress := func(newWriter func(*bytes.Buffer) io.WriteCloser) []byte {
+		var buf bytes.Buffer
+		w := newWriter(&buf)
+		_, err := w.Write([]byte(want))
+		require.NoError(t, err)
+		require.NoError(t, w.Close())
+		return buf.Bytes()
+	}
+	gzipped := compress(func(buf *bytes.Buffer) io.WriteCloser { return gzip.NewWriter(buf) })
+	zlibbed := compress(func(buf *bytes.Buffer) io.WriteCloser { return zlib.NewWriter(buf) })
+	deflated := compress(func(buf *bytes.Buffer) io.WriteCloser {
+		w, _ := flate.NewWriter(buf, flate.DefaultCompression)
+		return w
+	})
+	for _, test := range []struct {
+		encoding string
+		data     []byte
+	}{
+		{"", []byte(want)},
+		{"identity", []byte(want)},
+		{"gzip", gzipped},
+		{"GZIP", gzipped},
+		{"x-gzip", gzipped},
+		{"deflate", zlibbed},
+		{"deflate", deflated},
+	} {
+		got, err := decodeContent(test.encoding, test.data)
+		require.NoError(t, err, test.encoding)
+		assert.Equal(t, want, string(got), test.encoding)
+	}
+
+	_, err := decodeContent("gzip", []byte(want))
+	assert.Error(t, err)
+	_, err = decodeContent("br", []byte(want))
+	assert.ErrorContains(t, err, "unsupported")
+}